@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"github.com/dchest/captcha"
 	"github.com/go-redis/redis"
+	"sync"
 	"time"
 )
 
@@ -29,16 +30,29 @@ func (impl *StoreImpl) Set(id string, digits []byte) {
 }
 
 func (impl *StoreImpl) Get(id string, clear bool) (digits []byte) {
-	bytes, _ := impl.RDB.Get(fmt.Sprintf(CaptchaFormat, id)).Bytes()
+	key := fmt.Sprintf(CaptchaFormat, id)
+	bytes, _ := impl.RDB.Get(key).Bytes()
+	//校验时删除, 每个验证码只能校验一次
+	if clear {
+		impl.RDB.Del(key)
+	}
 	return bytes
 }
 
+var captchaStoreOnce sync.Once
+
+// 使用Redis存储验证码, 需要在生成、展示、校验之前指定
+func useCaptchaStore() {
+	captchaStoreOnce.Do(func() {
+		captcha.SetCustomStore(&StoreImpl{
+			RDB:        RedisClient,
+			Expiration: time.Second * CaptchaExpire,
+		})
+	})
+}
+
 // BuildCaptchaId 生成验证码图片id
 func BuildCaptchaId() string {
-	//需要在New之前进行指定
-	captcha.SetCustomStore(&StoreImpl{
-		RDB:        RedisClient,
-		Expiration: time.Second * CaptchaExpire,
-	})
+	useCaptchaStore()
 	return captcha.New()
 }
@@ -0,0 +1,219 @@
+/**
+ * @project Accounts
+ * @filename challenge.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 14:00
+ * @version 1.0
+ * @description
+ * 人机验证(挑战), 触发访问限制时返回, 由 /captcha/image 展示、/captcha/verify 校验
+ * 每种挑战实现ChallengeProvider, 一种(或同一协议的几种)一个文件(challenge_名称.go), 在init中注册
+ * 使用的挑战类型: 访问限制规则的Challenge > 项目配置 [Challenge.Games] > [Challenge].Default, 默认为图形验证码(image)
+ * 按项目选择时只使用签名正确的game_id; 锁定时保存挑战类型, 锁定期间使用同一类型, 其他类型的挑战不能解除锁定
+ * 挑战的类型、答案及触发的锁定保存在Redis中, 校验时按保存的类型处理, 通过后删除保存的锁定并重新计数, 每个挑战只能校验一次
+ */
+
+package base
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ChallengeDefaultProvider = "image"
+	ChallengeExpire          = 300                     //挑战有效期, 秒
+	ChallengeFormat          = "_account_challenge_%s" //挑战信息key, %s 为挑战id
+)
+
+// ChallengeProvider 挑战类型, 每种一个文件, 在init中调用RegisterChallengeProvider注册
+type ChallengeProvider interface {
+	// Name 挑战类型名称, 与配置 [Challenge.Providers.名称] 一致
+	Name() string
+	// Remote 是否调用第三方校验接口, 需要配置SiteKey、SecretKey
+	Remote() bool
+	// New 生成挑战, 返回挑战id、需要保存的答案及返回给客户端的参数
+	New(conf ChallengeProviderConf) (id string, answer string, params map[string]string)
+	// Render 输出挑战内容, refresh时重新生成并返回新的答案; 在客户端渲染的返回ChallengeRenderUnsupported
+	Render(w http.ResponseWriter, challenge *Challenge, data *CaptchaImageFields, conf ChallengeProviderConf) (string, *MyError)
+	// Verify 校验客户端提交的结果, 通过返回空
+	Verify(challenge *Challenge, code, ip string, conf ChallengeProviderConf) *MyError
+}
+
+// 已生成的挑战
+type Challenge struct {
+	Id       string
+	Provider string //挑战类型
+	Lock     string //触发的锁定key, 验证通过后删除
+	Counter  string //锁定对应的计数key, 验证通过后重新计数
+	Answer   string //需要在服务端校验的答案, 如滑块的位置
+}
+
+// 已注册的挑战类型, key: 名称
+var challengeProviders = map[string]ChallengeProvider{}
+
+// RegisterChallengeProvider 注册挑战类型, 名称重复时后注册的覆盖
+func RegisterChallengeProvider(provider ChallengeProvider) {
+	challengeProviders[provider.Name()] = provider
+}
+
+// GetChallengeProvider 按名称获取挑战类型及配置, 未注册时使用图形验证码
+func GetChallengeProvider(name string) (ChallengeProvider, ChallengeProviderConf) {
+	provider, ok := challengeProviders[name]
+	if !ok {
+		provider = challengeProviders[ChallengeDefaultProvider]
+	}
+	return provider, GConf.Challenge.Providers[provider.Name()]
+}
+
+// CheckChallengeConf 检查挑战配置, 启动时调用
+func CheckChallengeConf(rules []LimitRuleConf) error {
+	names := map[string]string{"default": GConf.Challenge.Default}
+	for gameId, name := range GConf.Challenge.Games {
+		if _, err := strconv.Atoi(gameId); err != nil {
+			return fmt.Errorf("challenge game id %s error", gameId)
+		}
+		names["game "+gameId] = name
+	}
+	for _, rule := range rules {
+		names["rule "+rule.Name] = rule.Challenge
+	}
+	for from, name := range names {
+		if name == "" {
+			continue
+		}
+		provider, ok := challengeProviders[name]
+		if !ok {
+			return fmt.Errorf("challenge %s unknown provider: %s", from, name)
+		}
+		conf := GConf.Challenge.Providers[name]
+		if provider.Remote() && (conf.SiteKey == "" || conf.SecretKey == "") {
+			return fmt.Errorf("challenge provider %s site key or secret key empty", name)
+		}
+	}
+	return nil
+}
+
+// ChallengeProviderName 使用的挑战类型, 依次为规则配置、项目配置、默认配置
+func ChallengeProviderName(ruleChallenge string, gameId int) string {
+	if ruleChallenge != "" {
+		return ruleChallenge
+	}
+	if name, ok := GConf.Challenge.Games[strconv.Itoa(gameId)]; ok && name != "" {
+		return name
+	}
+	if GConf.Challenge.Default != "" {
+		return GConf.Challenge.Default
+	}
+	return ChallengeDefaultProvider
+}
+
+// BuildChallenge 生成挑战并保存, 返回给客户端的数据; captchaType为触发的访问限制, lock、counter为验证通过后删除的锁定及计数
+func BuildChallenge(name, captchaType, lock, counter string) *LimitLockRetFields {
+	provider, conf := GetChallengeProvider(name)
+	id, answer, params := provider.New(conf)
+	challenge := &Challenge{Id: id, Provider: provider.Name(), Lock: lock, Counter: counter, Answer: answer}
+	if err := SaveChallenge(challenge); err != nil {
+		log.Error().Msgf("save challenge %s error: %s", id, err.Error())
+	}
+	return &LimitLockRetFields{CaptchaId: id, CaptchaType: captchaType, Provider: provider.Name(), Params: params}
+}
+
+// SaveChallenge 保存挑战, 重新生成答案时也使用
+func SaveChallenge(challenge *Challenge) error {
+	key := fmt.Sprintf(ChallengeFormat, challenge.Id)
+	pipe := RedisClient.TxPipeline()
+	pipe.HMSet(key, map[string]interface{}{
+		"provider": challenge.Provider,
+		"lock":     challenge.Lock,
+		"counter":  challenge.Counter,
+		"answer":   challenge.Answer,
+	})
+	pipe.Expire(key, ChallengeExpire*time.Second)
+	_, err := pipe.Exec()
+	return err
+}
+
+// LoadChallenge 读取挑战, 不存在时按图形验证码处理, 兼容升级前生成的验证码
+func LoadChallenge(id string) *Challenge {
+	values, _ := RedisClient.HGetAll(fmt.Sprintf(ChallengeFormat, id)).Result()
+	return challengeFromValues(id, values)
+}
+
+// TakeChallenge 读取并删除挑战, 校验时使用, 每个挑战只能校验一次
+func TakeChallenge(id string) *Challenge {
+	key := fmt.Sprintf(ChallengeFormat, id)
+	pipe := RedisClient.TxPipeline()
+	get := pipe.HGetAll(key)
+	pipe.Del(key)
+	_, _ = pipe.Exec()
+	return challengeFromValues(id, get.Val())
+}
+
+func challengeFromValues(id string, values map[string]string) *Challenge {
+	if values["provider"] == "" {
+		return &Challenge{Id: id, Provider: ChallengeDefaultProvider}
+	}
+	return &Challenge{Id: id, Provider: values["provider"], Lock: values["lock"], Counter: values["counter"], Answer: values["answer"]}
+}
+
+// LockChallenge 设置需要人机验证的锁定, 保存使用的挑战类型, 只能通过该类型的挑战解除
+func LockChallenge(key, name string, expiration time.Duration) {
+	if err := RedisClient.Set(key, name, expiration).Err(); err != nil {
+		log.Error().Msgf("lock challenge %s error: %s", key, err.Error())
+	}
+}
+
+// ChallengeLocked 是否已锁定, 返回锁定时保存的挑战类型; 升级前的锁定值为1, 按默认配置处理
+func ChallengeLocked(key string) (string, bool) {
+	name, err := RedisClient.Get(key).Result()
+	if err != nil || name == "" {
+		return "", false
+	}
+	if _, ok := challengeProviders[name]; !ok {
+		name = ChallengeProviderName("", 0)
+	}
+	return name, true
+}
+
+// UnlockChallenge 挑战验证通过后删除触发的锁定并重新计数, 挑战类型与锁定要求的不一致时不解除
+func UnlockChallenge(challenge *Challenge) *MyError {
+	if name, ok := ChallengeLocked(challenge.Lock); ok && name != challenge.Provider {
+		return &MyError{Code: ChallengeProviderMismatch, Log: fmt.Sprintf("challenge %s provider %s, lock %s requires %s", challenge.Id, challenge.Provider, challenge.Lock, name)}
+	}
+	keys := []string{}
+	for _, key := range []string{challenge.Lock, challenge.Counter} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := RedisClient.Del(keys...).Err(); err != nil {
+		log.Error().Msgf("unlock challenge %s error: %s", challenge.Id, err.Error())
+	}
+	return nil
+}
+
+// 随机的挑战id
+func challengeId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 请求第三方校验接口使用的http client
+func challengeHttpClient() *http.Client {
+	timeout := GConf.Challenge.Timeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
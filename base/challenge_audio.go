@@ -0,0 +1,44 @@
+/**
+ * @project Accounts
+ * @filename challenge_audio.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 14:40
+ * @version 1.0
+ * @description
+ * 语音验证码, dchest/captcha 的音频(wav), 供视障玩家使用
+ * 语言按请求的lang, 支持 en、ja、ru、zh, 其他使用en
+ */
+
+package base
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dchest/captcha"
+)
+
+type audioChallenge struct {
+	imageChallenge
+}
+
+func init() {
+	RegisterChallengeProvider(audioChallenge{})
+}
+
+func (c audioChallenge) Name() string {
+	return "audio"
+}
+
+func (c audioChallenge) Render(w http.ResponseWriter, challenge *Challenge, data *CaptchaImageFields, conf ChallengeProviderConf) (string, *MyError) {
+	useCaptchaStore()
+	if data.Refresh == 1 {
+		captcha.Reload(challenge.Id)
+	}
+	//zh-CN 等取语言部分
+	lang := strings.ToLower(strings.SplitN(data.Lang, "-", 2)[0])
+	w.Header().Set("Content-Type", "audio/x-wav")
+	_ = captcha.WriteAudio(w, challenge.Id, lang)
+	return "", nil
+}
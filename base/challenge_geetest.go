@@ -0,0 +1,101 @@
+/**
+ * @project Accounts
+ * @filename challenge_geetest.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 16:00
+ * @version 1.0
+ * @description
+ * 极验 GeeTest v4, 客户端使用返回的captcha_id展示
+ * 提交的code为客户端获得的结果json: {"lot_number", "captcha_output", "pass_token", "gen_time"}
+ * 二次校验签名 sign_token = HMAC-SHA256(captcha_key, lot_number)
+ */
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const GeetestDefaultVerifyUrl = "https://gcaptcha4.geetest.com/validate"
+
+type geetestChallenge struct{}
+
+func init() {
+	RegisterChallengeProvider(geetestChallenge{})
+}
+
+// 客户端提交的结果
+type geetestResult struct {
+	LotNumber     string `json:"lot_number"`
+	CaptchaOutput string `json:"captcha_output"`
+	PassToken     string `json:"pass_token"`
+	GenTime       string `json:"gen_time"`
+}
+
+// 二次校验接口的返回
+type geetestResp struct {
+	Status string `json:"status"`
+	Result string `json:"result"`
+	Reason string `json:"reason"`
+}
+
+func (c geetestChallenge) Name() string {
+	return "geetest"
+}
+
+func (c geetestChallenge) Remote() bool {
+	return true
+}
+
+func (c geetestChallenge) New(conf ChallengeProviderConf) (string, string, map[string]string) {
+	return challengeId(), "", map[string]string{"captcha_id": conf.SiteKey}
+}
+
+func (c geetestChallenge) Render(w http.ResponseWriter, challenge *Challenge, data *CaptchaImageFields, conf ChallengeProviderConf) (string, *MyError) {
+	return "", &MyError{Code: ChallengeRenderUnsupported, Log: c.Name()}
+}
+
+func (c geetestChallenge) Verify(challenge *Challenge, code, ip string, conf ChallengeProviderConf) *MyError {
+	result := &geetestResult{}
+	if err := json.Unmarshal([]byte(code), result); err != nil || result.LotNumber == "" {
+		return &MyError{Code: RequestLimitCodeError, Log: fmt.Sprintf("geetest code error: %s", code)}
+	}
+	mac := hmac.New(sha256.New, []byte(conf.SecretKey))
+	mac.Write([]byte(result.LotNumber))
+	form := url.Values{
+		"lot_number":     {result.LotNumber},
+		"captcha_output": {result.CaptchaOutput},
+		"pass_token":     {result.PassToken},
+		"gen_time":       {result.GenTime},
+		"sign_token":     {hex.EncodeToString(mac.Sum(nil))},
+	}
+	verifyUrl := conf.VerifyUrl
+	if verifyUrl == "" {
+		verifyUrl = GeetestDefaultVerifyUrl
+	}
+	resp, err := challengeHttpClient().PostForm(verifyUrl+"?captcha_id="+url.QueryEscape(conf.SiteKey), form)
+	if err != nil {
+		return &MyError{Code: ChallengeVerifyRequestError, Log: fmt.Sprintf("geetest verify error: %s", err.Error())}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return &MyError{Code: ChallengeVerifyRequestError, Log: fmt.Sprintf("geetest verify status: %d, body: %s", resp.StatusCode, body)}
+	}
+	ret := &geetestResp{}
+	if err = json.Unmarshal(body, ret); err != nil || ret.Status != "success" {
+		return &MyError{Code: ChallengeVerifyRequestError, Log: fmt.Sprintf("geetest verify response error: %s", body)}
+	}
+	if ret.Result != "success" {
+		return &MyError{Code: RequestLimitCodeError, Log: fmt.Sprintf("geetest verify fail: %s", ret.Reason)}
+	}
+	return nil
+}
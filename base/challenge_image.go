@@ -0,0 +1,55 @@
+/**
+ * @project Accounts
+ * @filename challenge_image.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 14:30
+ * @version 1.0
+ * @description
+ * 图形验证码, 基于 dchest/captcha, 默认的挑战类型
+ */
+
+package base
+
+import (
+	"net/http"
+
+	"github.com/dchest/captcha"
+)
+
+type imageChallenge struct{}
+
+func init() {
+	RegisterChallengeProvider(imageChallenge{})
+}
+
+func (c imageChallenge) Name() string {
+	return ChallengeDefaultProvider
+}
+
+func (c imageChallenge) Remote() bool {
+	return false
+}
+
+// 数字由dchest/captcha保存, 不需要单独保存答案
+func (c imageChallenge) New(conf ChallengeProviderConf) (string, string, map[string]string) {
+	return BuildCaptchaId(), "", nil
+}
+
+func (c imageChallenge) Render(w http.ResponseWriter, challenge *Challenge, data *CaptchaImageFields, conf ChallengeProviderConf) (string, *MyError) {
+	useCaptchaStore()
+	if data.Refresh == 1 {
+		captcha.Reload(challenge.Id)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_ = captcha.WriteImage(w, challenge.Id, data.Width, data.Height)
+	return "", nil
+}
+
+func (c imageChallenge) Verify(challenge *Challenge, code, ip string, conf ChallengeProviderConf) *MyError {
+	useCaptchaStore()
+	if !captcha.VerifyString(challenge.Id, code) {
+		return &MyError{Code: RequestLimitCodeError}
+	}
+	return nil
+}
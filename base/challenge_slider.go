@@ -0,0 +1,180 @@
+/**
+ * @project Accounts
+ * @filename challenge_slider.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 15:00
+ * @version 1.0
+ * @description
+ * 滑块验证码, 服务端生成背景图及拼图块, 客户端拖动拼图块到缺口位置, 提交拼图块左边的x坐标
+ * 答案保存为 x,y,背景随机种子, 刷新时重新生成; 误差在 [Challenge.Providers.slider].Tolerance 像素内通过
+ * 展示接口返回json: background、piece 为base64的png, y 为拼图块的纵坐标
+ */
+
+package base
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+)
+
+const (
+	SliderWidth            = 280 //背景图宽度
+	SliderHeight           = 150 //背景图高度
+	SliderPieceSize        = 50  //拼图块边长
+	SliderDefaultTolerance = 5
+)
+
+// 滑块验证码展示的数据
+type SliderImage struct {
+	Background string `json:"background"` //背景图, base64的png
+	Piece      string `json:"piece"`      //拼图块, base64的png
+	Y          int    `json:"y"`          //拼图块的纵坐标
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+type sliderChallenge struct{}
+
+func init() {
+	RegisterChallengeProvider(sliderChallenge{})
+}
+
+func (c sliderChallenge) Name() string {
+	return "slider"
+}
+
+func (c sliderChallenge) Remote() bool {
+	return false
+}
+
+func (c sliderChallenge) New(conf ChallengeProviderConf) (string, string, map[string]string) {
+	return challengeId(), sliderAnswer(), nil
+}
+
+func (c sliderChallenge) Render(w http.ResponseWriter, challenge *Challenge, data *CaptchaImageFields, conf ChallengeProviderConf) (string, *MyError) {
+	answer := ""
+	if data.Refresh == 1 || challenge.Answer == "" {
+		answer = sliderAnswer()
+		challenge.Answer = answer
+	}
+	x, y, seed, ok := parseSliderAnswer(challenge.Answer)
+	if !ok {
+		return "", &MyError{Code: RequestLimitCodeError, Log: fmt.Sprintf("slider answer error: %s", challenge.Answer)}
+	}
+	background, piece := sliderImages(x, y, seed)
+	body, _ := json.Marshal(&AccountResponse{Code: Success, Msg: ErrorMsg[Success], Data: &SliderImage{
+		Background: background,
+		Piece:      piece,
+		Y:          y,
+		Width:      SliderWidth,
+		Height:     SliderHeight,
+	}})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(body)
+	return answer, nil
+}
+
+func (c sliderChallenge) Verify(challenge *Challenge, code, ip string, conf ChallengeProviderConf) *MyError {
+	x, _, _, ok := parseSliderAnswer(challenge.Answer)
+	value, err := strconv.ParseFloat(code, 64)
+	if !ok || err != nil {
+		return &MyError{Code: RequestLimitCodeError, Log: fmt.Sprintf("slider code: %s", code)}
+	}
+	tolerance := conf.Tolerance
+	if tolerance <= 0 {
+		tolerance = SliderDefaultTolerance
+	}
+	if math.Abs(value-float64(x)) > float64(tolerance) {
+		return &MyError{Code: RequestLimitCodeError, Log: fmt.Sprintf("slider code: %s, x: %d", code, x)}
+	}
+	return nil
+}
+
+// [0, n) 的随机数
+func sliderRandom(n int64) int64 {
+	value, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0
+	}
+	return value.Int64()
+}
+
+// 随机的缺口位置及背景种子, 缺口不在最左边, 避免与拼图块初始位置重叠
+func sliderAnswer() string {
+	x := 2*SliderPieceSize + sliderRandom(SliderWidth-3*SliderPieceSize)
+	y := 10 + sliderRandom(SliderHeight-SliderPieceSize-20)
+	return fmt.Sprintf("%d,%d,%d", x, y, sliderRandom(math.MaxInt64))
+}
+
+func parseSliderAnswer(answer string) (x int, y int, seed int64, ok bool) {
+	_, err := fmt.Sscanf(answer, "%d,%d,%d", &x, &y, &seed)
+	return x, y, seed, err == nil
+}
+
+// 生成背景图(带缺口)及拼图块
+func sliderImages(x, y int, seed int64) (string, string) {
+	random := mrand.New(mrand.NewSource(seed))
+	background := image.NewRGBA(image.Rect(0, 0, SliderWidth, SliderHeight))
+	//渐变底色
+	from := color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+	to := color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+	for px := 0; px < SliderWidth; px++ {
+		ratio := float64(px) / SliderWidth
+		for py := 0; py < SliderHeight; py++ {
+			background.SetRGBA(px, py, color.RGBA{
+				R: uint8(float64(from.R)*(1-ratio) + float64(to.R)*ratio),
+				G: uint8(float64(from.G)*(1-ratio) + float64(to.G)*ratio),
+				B: uint8(float64(from.B)*(1-ratio) + float64(to.B)*ratio),
+				A: 255,
+			})
+		}
+	}
+	//随机色块及噪点, 增加识别缺口的难度
+	for i := 0; i < 12; i++ {
+		block := color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255}
+		bx, by, size := random.Intn(SliderWidth), random.Intn(SliderHeight), 10+random.Intn(40)
+		for px := bx; px < bx+size && px < SliderWidth; px++ {
+			for py := by; py < by+size && py < SliderHeight; py++ {
+				background.SetRGBA(px, py, block)
+			}
+		}
+	}
+	for i := 0; i < SliderWidth*SliderHeight/10; i++ {
+		background.SetRGBA(random.Intn(SliderWidth), random.Intn(SliderHeight), color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255})
+	}
+
+	//拼图块为缺口处的原图, 缺口变暗, 边缘为白色
+	piece := image.NewRGBA(image.Rect(0, 0, SliderPieceSize, SliderPieceSize))
+	for px := 0; px < SliderPieceSize; px++ {
+		for py := 0; py < SliderPieceSize; py++ {
+			origin := background.RGBAAt(x+px, y+py)
+			edge := px == 0 || py == 0 || px == SliderPieceSize-1 || py == SliderPieceSize-1
+			if edge {
+				piece.SetRGBA(px, py, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+				background.SetRGBA(x+px, y+py, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+				continue
+			}
+			piece.SetRGBA(px, py, origin)
+			background.SetRGBA(x+px, y+py, color.RGBA{R: origin.R / 3, G: origin.G / 3, B: origin.B / 3, A: 255})
+		}
+	}
+	return sliderPng(background), sliderPng(piece)
+}
+
+func sliderPng(img image.Image) string {
+	buf := &bytes.Buffer{}
+	_ = png.Encode(buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,114 @@
+/**
+ * @project Accounts
+ * @filename client_ip.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/29 15:00
+ * @version 1.0
+ * @description
+ * 客户端ip
+ * 只有直连地址为可信代理([TrustedProxy].Cidrs)时才读取 X-Forwarded-For, 从右向左跳过可信代理, 第一个不可信的地址为客户端ip
+ * 中间件ClientIpHandler解析一次后保存在请求context中, GetRealAddr直接读取
+ */
+
+package base
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 默认的客户端ip header
+const ClientIpDefaultHeader = "X-Forwarded-For"
+
+// 请求context中客户端ip的key
+type clientIpKey struct{}
+
+// 已解析的可信代理ip段
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies 设置可信代理, 支持CIDR及单个ip
+func SetTrustedProxies(cidrs []string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ipNet, err := ParseCidr(cidr)
+		if err != nil {
+			return fmt.Errorf("trusted proxy %s", err.Error())
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+// IsTrustedProxy 是否为可信代理
+func IsTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 ip、ip:port、[ipv6]:port 格式的地址
+func parseAddrIp(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.Trim(addr, "[]")
+	//去掉ipv6的zone, 如 fe80::1%eth0
+	if i := strings.Index(addr, "%"); i >= 0 {
+		addr = addr[:i]
+	}
+	return net.ParseIP(addr)
+}
+
+// ResolveClientIp 解析客户端ip, 直连地址不是可信代理时不读取header
+func ResolveClientIp(r *http.Request) net.IP {
+	remote := parseAddrIp(r.RemoteAddr)
+	if !IsTrustedProxy(remote) {
+		return remote
+	}
+	header := GConf.TrustedProxy.Header
+	if header == "" {
+		header = ClientIpDefaultHeader
+	}
+	//多个同名header按顺序拼接
+	addrs := strings.Split(strings.Join(r.Header.Values(header), ","), ",")
+	client := remote
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := parseAddrIp(addrs[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !IsTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIpHandler 解析客户端ip并保存到请求context中, 需在其他中间件之前
+func ClientIpHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ResolveClientIp(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIpKey{}, ip)))
+	})
+}
+
+// GetRealAddr 客户端ip, 优先使用ClientIpHandler已解析的
+func GetRealAddr(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIpKey{}).(net.IP); ok {
+		return ip
+	}
+	return ResolveClientIp(r)
+}
@@ -7,7 +7,7 @@
  * @version 1.0
  * @description
  * 错误码定义
- * 0 - 1212 通用
+ * 0 - 1217 通用
  * 其余开头
  * 23 注册
  * 33 登录
@@ -20,6 +20,16 @@
  * 103 注销
  * 113 撤销注销
  * 123 实名认证
+ * 133 刷新token
+ * 143 OIDC
+ * 153 登录会话
+ * 163 二次验证
+ * 173 通行密钥
+ * 183 第三方账号校验
+ * 184 苹果账号通知
+ * 185 第三方数据删除回调
+ * 186 短信发送
+ * 187 邮件发送
  */
 
 package base
@@ -50,6 +60,13 @@ const (
 	RequestLimitRegisterConfigError      = 121   //注册限制：配置错误
 	RequestLimitRegisterLockIp           = 122   //注册限制：ip已被锁定
 	RequestLimitCodeError                = 123   //图形验证码验证错误
+	RequestLimitRuleReject               = 124   //访问限制规则：请求过于频繁
+	RequestLimitRuleCaptcha              = 125   //访问限制规则：请求过于频繁, 需要图形验证码
+	RequestLimitRuleLocked               = 126   //访问限制规则：已锁定
+	RequestLimitIpDenied                 = 127   //ip在禁止访问名单中
+	ChallengeRenderUnsupported           = 128   //此挑战类型在客户端展示, 服务端不输出
+	ChallengeVerifyRequestError          = 129   //请求第三方人机验证校验接口失败
+	ChallengeProviderMismatch            = 130   //挑战类型与锁定时要求的不一致
 	VerifyCodeNotExists                  = 1201  //验证码不存在
 	VerifyCodeError                      = 1202  //验证码错误
 	DeleteVerifyCodeError                = 1204  //删除验证码出错
@@ -60,6 +77,11 @@ const (
 	UsernameFormatError                  = 1210  //用户名格式错误
 	GuestOrThirdLengthError              = 1211  //游客或第三方ID长度错误
 	GuestOrThirdFormatError              = 1212  //游客或第三方ID格式错误
+	LoginTokenRevoked                    = 1213  //登录token已失效(已撤销)
+	SessionSaveError                     = 1214  //保存登录会话失败
+	SessionRevokeError                   = 1215  //撤销登录会话失败
+	PasswordHashError                    = 1216  //密码加密失败
+	VerifyCodeCheckLimit                 = 1217  //验证码校验次数过多, 已失效, 需重新获取
 	ThirdFormatError                     = 2308  //第三方账号格式错误
 	ThirdIdParseFailure                  = 2309  //第三方id解析失败
 	ThirdIdUnsupported                   = 2310  //不支持的第三方id
@@ -100,6 +122,8 @@ const (
 	VerifyCodeInsertError                = 6387  //验证码写入失败
 	VerifyCodeUpdateFailure              = 6388  //验证码更新失败
 	VerifyCodeTypeUnknown                = 6389  //未知的短信类型
+	EmailUndeliverable                   = 6390  //邮箱无法投递, 如苹果中转邮箱已停用转发
+	TemplateRenderError                  = 6391  //邮件、短信模板渲染失败
 	BindAccountNotExists                 = 7301  //绑定账号，账号不存在
 	GetAlreadyBindInfoNotFound           = 7335  //查询已绑定账号失败，找不到
 	GetAlreadyBindThirdError             = 7336  //查询第三方绑定信息错误
@@ -131,10 +155,15 @@ const (
 	UnBindHashTxExecUpdateError          = 8342  //hash账号更新事务错误
 	UnBindAccountTxExecUpdateError       = 8343  //账号表执行更新事务错误
 	UnBindUnSupportCurrentAccount        = 8344  //不支持解绑当前账号
+	UnBindTxCommitError                  = 8345  //解绑提交事务错误
 	LoginAuthError                       = 9340  //登录校验失败
 	LoginAuthTokenParseError             = 9341  //登录Token解析失败
 	LoginTokenUidNotMatch                = 9342  //登录Token与uid不匹配
 	LoginAuthDomainNotWhiteList          = 9343  //请求域名不是白名单内
+	LoginAuthRefreshToken                = 9344  //刷新token不能用于登录校验
+	LoginAuthGameNotMatch                = 9345  //token内的game_id、platform_id与传入的不一致
+	LoginAuthUserQueryError              = 9346  //登录校验查询项目用户表错误
+	LoginAuthUserNotExists               = 9347  //登录校验项目用户不存在
 	AddDeleteApplyError                  = 10365 //添加注销申请失败
 	DeleteApplyUpdateUserStatusError     = 10366 //账号注销修改用户状态失败
 	DeleteApplyAlreadyExists             = 10367 //添加注销申请已存在
@@ -152,6 +181,98 @@ const (
 	RealNameUpdateError                  = 12303 //更新错误
 	RealNameGetAccountUidNotExists       = 12304 //根据account获取账号id不存在
 	RealNameUpdateAccountError           = 12305 //更新账号信息错误
+	RefreshTokenTypeError                = 13301 //不是刷新token
+	RefreshTokenUidNotMatch              = 13302 //刷新token内的uid与传入的uid不相等
+	RefreshTokenGameNotMatch             = 13303 //刷新token内的game_id、platform_id与传入的不一致
+	RefreshGameUserQueryError            = 13304 //刷新token查询项目用户表错误
+	RefreshGameUserScanError             = 13305 //刷新token查询项目用户表Scan时错误
+	RefreshGameUserNotExists             = 13306 //刷新token项目用户不存在
+	RefreshTokenReused                   = 13307 //刷新token重复使用, 已撤销该token族
+	RefreshTokenMarkError                = 13308 //标记刷新token已使用失败
+	OidcDisabled                         = 14301 //未启用OIDC
+	OidcClientNotExists                  = 14302 //OIDC客户端不存在或未启用
+	OidcRedirectUriError                 = 14303 //redirect_uri未登记
+	OidcResponseTypeError                = 14304 //不支持的response_type
+	OidcScopeError                       = 14305 //scope必须包含openid
+	OidcPkceError                        = 14306 //缺少code_challenge或方法不是S256
+	OidcCodeSaveError                    = 14307 //保存授权码失败
+	OidcCodeInvalid                      = 14308 //授权码无效或已过期
+	OidcCodeVerifierError                = 14309 //code_verifier校验失败
+	OidcClientAuthError                  = 14310 //客户端认证失败
+	OidcGrantTypeError                   = 14311 //不支持的grant_type
+	OidcIdTokenBuildError                = 14312 //生成id_token失败
+	OidcAccessTokenError                 = 14313 //access_token无效
+	OidcUserInfoQueryError               = 14314 //查询用户信息失败
+	OidcPendingInvalid                   = 14315 //等待二次验证的授权不存在或已过期
+	SessionTokenTypeError                = 15301 //会话管理需要使用登录token
+	SessionListError                     = 15302 //查询会话列表失败
+	SessionNotExists                     = 15303 //会话不存在或不属于当前账号
+	SessionIdEmpty                       = 15304 //session_id为空
+	TotpEncryptKeyError                  = 16301 //未配置TOTP加密key或长度不是32位
+	TotpTokenTypeError                   = 16302 //二次验证设置需要使用登录token
+	TotpAlreadyEnabled                   = 16303 //已启用二次验证
+	TotpNotEnabled                       = 16304 //未启用或未生成TOTP密钥
+	TotpCodeError                        = 16305 //验证码或恢复码错误
+	TotpCodeEmpty                        = 16306 //验证码为空
+	TotpQueryError                       = 16307 //查询二次验证信息失败
+	TotpSaveError                        = 16308 //保存二次验证信息失败
+	TotpSecretError                      = 16309 //TOTP密钥加解密失败
+	LoginSecondFactorRequired            = 16310 //需要二次验证, 使用返回的challenge_token完成登录
+	TotpChallengeSaveError               = 16311 //保存二次验证challenge失败
+	TotpChallengeInvalid                 = 16312 //challenge无效、已过期或验证失败次数过多
+	TotpChallengeGameNotMatch            = 16313 //challenge的game_id、platform_id与传入的不一致
+	PasskeyDisabled                      = 17301 //未启用通行密钥
+	PasskeyTokenTypeError                = 17302 //注册通行密钥需要使用登录token
+	PasskeyChallengeSaveError            = 17303 //保存challenge失败
+	PasskeyChallengeInvalid              = 17304 //challenge无效或已过期
+	PasskeyClientDataError               = 17305 //clientDataJSON解析失败或type、origin不正确
+	PasskeyAuthDataError                 = 17306 //authenticatorData无效, rpId或标志位不正确
+	PasskeyPublicKeyError                = 17307 //公钥解析失败或算法不支持
+	PasskeyCredentialIdError             = 17308 //凭证id无效或过长
+	PasskeyExists                        = 17309 //凭证已注册
+	PasskeySaveError                     = 17310 //保存凭证失败
+	PasskeyNotExists                     = 17311 //凭证不存在
+	PasskeyQueryError                    = 17312 //查询凭证失败
+	PasskeySignatureError                = 17313 //签名验证失败
+	PasskeySignCountError                = 17314 //签名计数异常, 凭证可能被复制
+	PasskeyGameNotMatch                  = 17315 //challenge的game_id、platform_id与传入的不一致
+	ThirdVerifyParamsError               = 18301 //third_account参数错误或缺少凭证
+	ThirdVerifyUnsupported               = 18302 //此第三方不支持服务端校验
+	ThirdVerifyRequestError              = 18303 //请求第三方校验接口失败
+	ThirdVerifyJwksError                 = 18304 //获取第三方公钥失败
+	ThirdVerifyTokenInvalid              = 18305 //第三方凭证无效或已过期
+	ThirdVerifyIdNotMatch                = 18306 //third_account中的第三方id与账号不一致
+	ThirdProviderDisabled                = 18307 //项目未开启此第三方
+	ThirdCodeExchangeError               = 18308 //授权码换取token失败
+	ThirdTokenSaveError                  = 18309 //保存第三方token失败
+	ThirdTokenEncryptKeyError            = 18310 //第三方token加密key未配置或长度不是32位
+	ThirdTokenDecryptError               = 18311 //第三方token解密失败
+	ThirdTokenNotExists                  = 18312 //没有保存的第三方token
+	AppleConsentRevoked                  = 18313 //用户已撤销苹果授权, 刷新token失效
+	ThirdTokenQueryError                 = 18314 //查询第三方token失败
+	AppleNotifyPayloadError              = 18401 //苹果通知内容解析失败
+	AppleNotifyDuplicate                 = 18402 //重复的苹果通知
+	AppleNotifyEventUnknown              = 18403 //未知的苹果通知事件
+	AppleNotifyAccountNotExists          = 18404 //通知的苹果账号不存在
+	AppleNotifyGameNotFound              = 18405 //没有与通知aud对应的项目
+	AppleNotifyUnbindError               = 18406 //撤销授权时解绑失败
+	AppleNotifySaveError                 = 18407 //保存已处理的通知失败
+	ThirdDeletionParamsError             = 18501 //回调参数错误
+	ThirdDeletionSignError               = 18502 //signed_request签名校验失败
+	ThirdDeletionAccountNotExists        = 18503 //第三方账号在项目中不存在
+	ThirdDeletionCodeInvalid             = 18504 //确认码无效
+	ThirdDeletionApplyNotExists          = 18505 //注销申请不存在
+	ThirdDeletionQueryError              = 18506 //查询注销申请失败
+	ThirdEventDuplicate                  = 18507 //重复的安全事件
+	ThirdEventUnknown                    = 18508 //未知的安全事件
+	ThirdDeletionRequestExpired          = 18509 //signed_request已过期
+	ThirdDeletionAppNotConfigured        = 18510 //项目未配置第三方应用
+	SmsNoProvider                        = 18601 //手机号没有可用的短信服务商(未匹配路由或没有模板)
+	SmsProviderConfigError               = 18602 //短信服务商配置错误
+	SmsSendError                         = 18603 //短信服务商发送失败
+	MailQueueError                       = 18701 //邮件写入发送队列或读取状态失败
+	MailNotExists                        = 18702 //邮件不存在或状态已过期
+	MailSendError                        = 18703 //SMTP发送失败
 )
 
 var ErrorMsg = map[int]string{
@@ -180,6 +301,13 @@ var ErrorMsg = map[int]string{
 	RequestLimitRegisterConfigError:      "registration limit: configuration error",
 	RequestLimitRegisterLockIp:           "registration limit: ip is locked",
 	RequestLimitCodeError:                "graphic authentication error",
+	RequestLimitRuleReject:               "too many requests, please retry later",
+	RequestLimitRuleCaptcha:              "too many requests, please verify the captcha",
+	RequestLimitRuleLocked:               "too many requests, locked",
+	RequestLimitIpDenied:                 "ip access denied",
+	ChallengeRenderUnsupported:           "challenge is rendered by client",
+	ChallengeVerifyRequestError:          "challenge verify request error",
+	ChallengeProviderMismatch:            "challenge provider mismatch",
 	VerifyCodeNotExists:                  "authentication code does not exist",
 	VerifyCodeError:                      "authentication code error",
 	DeleteVerifyCodeError:                "error deleting verification code",
@@ -191,6 +319,11 @@ var ErrorMsg = map[int]string{
 	ThirdFormatError:                     "third-party format error",
 	GuestOrThirdLengthError:              "guest or third-party id length error",
 	GuestOrThirdFormatError:              "guest or third-party id format error",
+	LoginTokenRevoked:                    "login token has been revoked",
+	SessionSaveError:                     "save login session failed",
+	SessionRevokeError:                   "revoke login session failed",
+	PasswordHashError:                    "password hash failed",
+	VerifyCodeCheckLimit:                 "too many verification attempts, please request a new code",
 	ThirdIdParseFailure:                  "registration - third party id resolution failed",
 	ThirdIdUnsupported:                   "unsupported third party id",
 	ThirdUidEmpty:                        "third-party account uid is empty",
@@ -230,6 +363,8 @@ var ErrorMsg = map[int]string{
 	VerifyCodeInsertError:                "authenticode write failure",
 	VerifyCodeUpdateFailure:              "verify code update failed",
 	VerifyCodeTypeUnknown:                "unknown sms type",
+	EmailUndeliverable:                   "email address is undeliverable",
+	TemplateRenderError:                  "template render failed",
 	BindAccountNotExists:                 "bind account, account does not exist",
 	GetAlreadyBindInfoNotFound:           "query failed for bound account, could not be found",
 	GetAlreadyBindThirdError:             "error in querying third-party binding information",
@@ -257,10 +392,15 @@ var ErrorMsg = map[int]string{
 	UnBindHashTxExecUpdateError:          "hash account update transaction error",
 	UnBindAccountTxExecUpdateError:       "account table execution update transaction error",
 	UnBindUnSupportCurrentAccount:        "unbinding the current account is not supported",
+	UnBindTxCommitError:                  "unbind commit transaction error",
 	LoginAuthError:                       "logon verification failed",
 	LoginAuthTokenParseError:             "login token resolution failure",
 	LoginTokenUidNotMatch:                "login token does not match uid",
 	LoginAuthDomainNotWhiteList:          "request domain name is not on the whitelist",
+	LoginAuthRefreshToken:                "refresh token cannot be used for login auth",
+	LoginAuthGameNotMatch:                "token game_id or platform_id does not match",
+	LoginAuthUserQueryError:              "login auth, error querying project user table",
+	LoginAuthUserNotExists:               "login auth, project user does not exist",
 	AddDeleteApplyError:                  "add logoff request failed",
 	DeleteApplyUpdateUserStatusError:     "account logoff failed to modify user status",
 	DeleteApplyAlreadyExists:             "add logoff request already exists",
@@ -278,4 +418,96 @@ var ErrorMsg = map[int]string{
 	RealNameUpdateError:                  "update error",
 	RealNameGetAccountUidNotExists:       "getting account id from account does not exist",
 	RealNameUpdateAccountError:           "update account information error",
+	RefreshTokenTypeError:                "token is not a refresh token",
+	RefreshTokenUidNotMatch:              "refresh token uid is not equal to incoming uid",
+	RefreshTokenGameNotMatch:             "refresh token game id or platform id mismatch",
+	RefreshGameUserQueryError:            "refresh token, error querying project user table",
+	RefreshGameUserScanError:             "refresh token, error querying project user table scan",
+	RefreshGameUserNotExists:             "refresh token, project user does not exist",
+	RefreshTokenReused:                   "refresh token has already been used",
+	RefreshTokenMarkError:                "failed to mark refresh token as used",
+	OidcDisabled:                         "oidc provider is disabled",
+	OidcClientNotExists:                  "oidc client does not exist or is disabled",
+	OidcRedirectUriError:                 "redirect_uri is not registered",
+	OidcResponseTypeError:                "unsupported response_type",
+	OidcScopeError:                       "scope must contain openid",
+	OidcPkceError:                        "code_challenge is required and method must be S256",
+	OidcCodeSaveError:                    "failed to save authorization code",
+	OidcCodeInvalid:                      "authorization code is invalid or expired",
+	OidcCodeVerifierError:                "code_verifier does not match",
+	OidcClientAuthError:                  "client authentication failed",
+	OidcGrantTypeError:                   "unsupported grant_type",
+	OidcIdTokenBuildError:                "failed to build id_token",
+	OidcAccessTokenError:                 "access token is invalid",
+	OidcUserInfoQueryError:               "failed to query user info",
+	OidcPendingInvalid:                   "pending authorization is invalid or expired",
+	SessionTokenTypeError:                "session management requires a login token",
+	SessionListError:                     "failed to list sessions",
+	SessionNotExists:                     "session does not exist or does not belong to this account",
+	SessionIdEmpty:                       "session_id is empty",
+	TotpEncryptKeyError:                  "totp encrypt key is not configured",
+	TotpTokenTypeError:                   "two-factor settings require a login token",
+	TotpAlreadyEnabled:                   "two-factor authentication is already enabled",
+	TotpNotEnabled:                       "two-factor authentication is not enabled",
+	TotpCodeError:                        "two-factor code error",
+	TotpCodeEmpty:                        "two-factor code is empty",
+	TotpQueryError:                       "query two-factor info failed",
+	TotpSaveError:                        "save two-factor info failed",
+	TotpSecretError:                      "totp secret encrypt or decrypt failed",
+	LoginSecondFactorRequired:            "second factor required",
+	TotpChallengeSaveError:               "save second factor challenge failed",
+	TotpChallengeInvalid:                 "second factor challenge is invalid or expired",
+	TotpChallengeGameNotMatch:            "second factor challenge game_id or platform_id mismatch",
+	PasskeyDisabled:                      "passkey is disabled",
+	PasskeyTokenTypeError:                "passkey registration requires a login token",
+	PasskeyChallengeSaveError:            "save webauthn challenge failed",
+	PasskeyChallengeInvalid:              "webauthn challenge is invalid or expired",
+	PasskeyClientDataError:               "webauthn client data error",
+	PasskeyAuthDataError:                 "webauthn authenticator data error",
+	PasskeyPublicKeyError:                "passkey public key error",
+	PasskeyCredentialIdError:             "passkey credential id error",
+	PasskeyExists:                        "passkey already registered",
+	PasskeySaveError:                     "save passkey failed",
+	PasskeyNotExists:                     "passkey does not exist",
+	PasskeyQueryError:                    "query passkey failed",
+	PasskeySignatureError:                "passkey signature verification failed",
+	PasskeySignCountError:                "passkey sign count error, credential may be cloned",
+	PasskeyGameNotMatch:                  "webauthn challenge game_id or platform_id mismatch",
+	ThirdVerifyParamsError:               "third account credential is missing or malformed",
+	ThirdVerifyUnsupported:               "third party does not support server-side verification",
+	ThirdVerifyRequestError:              "request third party verification failed",
+	ThirdVerifyJwksError:                 "fetch third party public keys failed",
+	ThirdVerifyTokenInvalid:              "third party credential is invalid or expired",
+	ThirdVerifyIdNotMatch:                "third account id does not match the account",
+	ThirdProviderDisabled:                "third party is not enabled for this game",
+	ThirdCodeExchangeError:               "exchange third party authorization code failed",
+	ThirdTokenSaveError:                  "save third party token failed",
+	ThirdTokenEncryptKeyError:            "third party token encrypt key invalid",
+	ThirdTokenDecryptError:               "decrypt third party token failed",
+	ThirdTokenNotExists:                  "third party token not exists",
+	AppleConsentRevoked:                  "apple authorization has been revoked",
+	ThirdTokenQueryError:                 "query third party token failed",
+	AppleNotifyPayloadError:              "apple notification payload is malformed",
+	AppleNotifyDuplicate:                 "apple notification already processed",
+	AppleNotifyEventUnknown:              "unknown apple notification event",
+	AppleNotifyAccountNotExists:          "apple account in notification does not exist",
+	AppleNotifyGameNotFound:              "no game matches the apple notification audience",
+	AppleNotifyUnbindError:               "unbind apple account failed",
+	AppleNotifySaveError:                 "save apple notification failed",
+	ThirdDeletionParamsError:             "data deletion callback params error",
+	ThirdDeletionSignError:               "signed request verification failed",
+	ThirdDeletionAccountNotExists:        "third account does not exist in this game",
+	ThirdDeletionCodeInvalid:             "confirmation code is invalid",
+	ThirdDeletionApplyNotExists:          "deletion request does not exist",
+	ThirdDeletionQueryError:              "query deletion request failed",
+	ThirdEventDuplicate:                  "security event already processed",
+	ThirdEventUnknown:                    "unknown security event",
+	ThirdDeletionRequestExpired:          "signed request has expired",
+	ThirdDeletionAppNotConfigured:        "third app of this game is not configured",
+	SmsNoProvider:                        "no sms provider available for this mobile",
+	SmsProviderConfigError:               "sms provider configuration error",
+	SmsSendError:                         "send sms failed",
+	MailQueueError:                       "mail queue error",
+	MailNotExists:                        "mail not exists or expired",
+	MailSendError:                        "send mail failed",
 }
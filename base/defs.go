@@ -12,6 +12,7 @@
 package base
 
 import (
+	"accounts/limiter"
 	"database/sql"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis"
@@ -46,6 +47,9 @@ var GameUserSlaveDbMap sync.Map
 // Redis对象
 var RedisClient *redis.Client
 
+// 滑动窗口限流, 使用RedisClient
+var RateLimiter *limiter.Limiter
+
 // 内存中存储信息
 var MemoryStoreInfo sync.Map
 
@@ -59,6 +63,9 @@ var RegisterDataLog zerolog.Logger
 // 输出到控制台+日志文件
 var MultipleLog zerolog.Logger
 
+// 安全事件日志, 如刷新token重复使用
+var SecurityLog zerolog.Logger
+
 // 账号类型
 const (
 	//没值时传入的默认字符串
@@ -68,9 +75,7 @@ const (
 	AppIdTypeSdk    = 1
 	AppIdTypeClient = 2
 	AppIdTypeServer = 3
-
-	//密码盐长度
-	PasswordSaltLength = 16
+	AppIdTypeOidc   = 4 //OIDC客户端, 如官网、论坛
 
 	//主账号库数量及表数量
 	MainAccountDbNumber    = 3
@@ -90,6 +95,7 @@ const (
 	AccountUsername int = 3
 	AccountGuest    int = 4
 	AccountThird    int = 5
+	AccountPasskey  int = 6 //通行密钥, 不能直接用于注册、登录接口
 
 	//生成 access token类型
 	TokenTypeAccess int = 1
@@ -113,6 +119,13 @@ const (
 	InWhiteList    = 1 //在白名单内
 
 	AppleValidateCodeUrl = "https://appleid.apple.com/auth/token"
+	AppleRevokeUrl       = "https://appleid.apple.com/auth/revoke"
+	WeixinApiBaseUrl     = "https://api.weixin.qq.com"
+	QQApiBaseUrl         = "https://graph.qq.com"
+	TwilioApiBaseUrl     = "https://api.twilio.com"
+
+	AppleConsentCheckInterval = 86400 //苹果刷新token默认检查间隔, 秒
+	AppleConsentCheckLimit    = 500   //每次检查苹果授权时每张表读取的数量
 
 	//密码盐字符串
 	PasswordSaltChar = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ~!@#$%^&*()_{}:<>?"
@@ -141,6 +154,7 @@ const (
 	UserDeleteConfigTable = "user_delete_config"
 	WhiteUserListTable    = "white_user_list"
 	HolidayTable          = "holiday"
+	IpAccessListTable     = "ip_access_list"
 
 	GameUserSlaveDb  = 1
 	GameUserMasterDb = 2
@@ -154,11 +168,18 @@ const (
 	CodeTypeBindAccount    = 3
 	CodeTypeUnBindAccount  = 4
 	CodeTypeLogin          = 5
-	//验证码格式，%d 为以上类型，%s 为账号
-	CodeFormat = "_account_code_%d_%s"
+	//验证码格式，依次为 game_id, app_id, 以上类型, 账号; 验证码只能在发送时的项目和应用中使用
+	CodeFormat = "_account_code_%d_%d_%d_%s"
+	//验证码hash字段, 验证码及已校验次数
+	CodeFieldValue  = "code"
+	CodeFieldChecks = "checks"
+	//每个验证码的默认最大校验次数, [Base].CodeCheckMax 未配置或不大于0时使用
+	CodeDefaultCheckMax = 5
 
 	//header 内项目大区字符串
 	HeaderGamePlatform = "game-platform"
+	//header 内设备类型, 登录接口没有device_type参数时使用, 1:ios，2:android，3:pc/h5，4:其他
+	HeaderDeviceType = "device-type"
 	//日期时间格式
 	DateTimeFormat = "2006-01-02 15:04:05"
 	DateFormat     = "2006-01-02"
@@ -168,11 +189,112 @@ const (
 	CaptchaFormat = "_account_captcha_%s"
 
 	//访问限制
-	LimitIpLocKey      = "_account_limit_lock_ip_" //ip 锁key
+	LimitIpLocKey      = "_account_limit_lock_ip_" //ip 锁key前缀, 加上game_id_ip, 值为需要的挑战类型
 	LimitIpKey         = "_account_limit_ip_"      //累加的ip key
 	LimitRegisterIpKey = "_account_limit_ril_"     //注册ip锁key
 	LimitLoginIpKey    = "_account_limit_li_"      //登录ip锁key
 	LimitCodeIpKey     = "_account_limit_vcil_"    //验证码ip锁key
+
+	//访问限制规则, 依次为 规则名称, 计数维度的值
+	LimitRuleFormat        = "_account_limit_rule_%s_%s"       //计数key
+	LimitRuleLockFormat    = "_account_limit_rule_lock_%s_%s"  //lock锁定key
+	LimitRuleCaptchaFormat = "_account_limit_rule_captcha_%s_" //captcha锁定key前缀, 加上规则计数的值, 挑战验证通过后删除
+	LimitActionReject      = "reject"
+	LimitActionCaptcha     = "captcha"
+	LimitActionLock        = "lock"
+	//访问限制规则的计数维度
+	LimitKeyIp       = "ip"
+	LimitKeyAccount  = "account"
+	LimitKeyAppId    = "app_id"
+	LimitKeyGameId   = "game_id"
+	LimitKeyDeviceId = "device_id"
+	LimitKeyAsn      = "asn"     //GeoIP文件中的ASN
+	LimitKeyCountry  = "country" //GeoIP文件中的国家码
+	//设备id header, 未传时使用请求中的device_id
+	HeaderDeviceId = "Device-Id"
+
+	//登录会话
+	SessionFormat      = "_account_session_%s"       //会话key, %s 为token的jti
+	UserSessionsFormat = "_account_user_sessions_%d" //主账号下所有会话的jti集合
+	RevokeBeforeFormat = "_account_revoke_before_%d" //主账号撤销全部会话的时间点
+	TokenFamilyFormat  = "_account_token_family_%s"  //同一次登录派生的所有token的jti集合, %s 为族id
+	RefreshUsedFormat  = "_account_refresh_used_%s"  //已使用的刷新token, %s 为jti, 没有jti时为 h_token的sha256
+	SessionInfoFormat  = "_account_session_info_%s"  //登录会话信息(设备、ip等), %s 为族id
+	UserFamiliesFormat = "_account_user_families_%d" //主账号下所有登录会话的族id集合
+	//项目用户状态缓存, 校验token时使用, 禁用后最迟在缓存过期时撤销会话
+	UserStatusFormat       = "_account_user_status_%d_%d_%d" //项目_大区_主账号uid
+	UserStatusCacheExpires = 60                              //秒
+
+	//token签名密钥环在内存中的key
+	JwtKeyRingKey = "_account_jwt_key_ring"
+
+	//token签名密钥状态
+	JwtKeyStatusActive  = "active"
+	JwtKeyStatusVerify  = "verify"
+	JwtKeyStatusRetired = "retired"
+
+	//OIDC授权码key, %s 为授权码
+	OidcCodeFormat = "_account_oidc_code_%s"
+	//OIDC登录等待二次验证时保存的授权参数, %s 为challenge token
+	OidcPendingFormat = "_account_oidc_pending_%s"
+
+	//二次验证
+	TotpChallengeFormat         = "_account_totp_challenge_%s"          //登录二次验证的challenge, %s 为challenge token
+	TotpChallengeAttemptsFormat = "_account_totp_challenge_attempts_%s" //challenge尝试次数, 校验前增加
+	TotpUsedFormat              = "_account_totp_used_%d_%d"            //已使用的TOTP时间步, 防止同一验证码重复使用
+	TotpChallengeMaxAttempts    = 5                                     //challenge最多尝试次数, 超过则失效
+	RecoveryCodeNumber          = 10                                    //恢复码数量
+
+	//通行密钥
+	PasskeyAccountPrefix    = "pk_"                            //hash表中通行密钥的账号前缀, 后接base64url编码的凭证id
+	WebauthnChallengeFormat = "_account_webauthn_challenge_%s" //注册、登录的challenge
+	WebauthnTypeCreate      = "webauthn.create"
+	WebauthnTypeGet         = "webauthn.get"
+
+	//苹果服务端通知
+	AppleNotificationFormat  = "_account_apple_notification_%s" //已处理的通知, %s 为jti, 防止重放
+	AppleNotificationExpires = 86400                            //已处理通知(含Google安全事件)的保存时间, 秒
+	EmailUndeliverableKey    = "_account_email_undeliverable"   //无法投递的邮箱集合
+	AppleEventEmailDisabled  = "email-disabled"
+	AppleEventEmailEnabled   = "email-enabled"
+	AppleEventConsentRevoked = "consent-revoked"
+	AppleEventAccountDelete  = "account-delete"
+
+	//Facebook数据删除回调signed_request的有效期, 秒, 超过issued_at此时长的不处理, 防止重放
+	FacebookSignedRequestExpires = 300
+
+	//Google跨账号保护(RISC)安全事件
+	GoogleRiscEventFormat     = "_account_google_risc_%s" //已处理的事件, %s 为jti
+	GoogleRiscTokensRevoked   = "https://schemas.openid.net/secevent/oauth/event-type/tokens-revoked"
+	GoogleRiscSessionsRevoked = "https://schemas.openid.net/secevent/risc/event-type/sessions-revoked"
+	GoogleRiscAccountDisabled = "https://schemas.openid.net/secevent/risc/event-type/account-disabled"
+	GoogleRiscAccountPurged   = "https://schemas.openid.net/secevent/risc/event-type/account-purged"
+	GoogleRiscVerification    = "https://schemas.openid.net/secevent/risc/event-type/verification"
+
+	//邮件发送队列
+	MailStreamKey            = "_account_mail_stream" //待发送邮件的stream, 内容为mail_id
+	MailStreamGroup          = "account_mail"         //发送协程的消费组
+	MailMessageFormat        = "_account_mail_%s"     //邮件内容及发送状态, %s 为mail_id
+	MailDefaultSender        = "default"              //默认发件身份, [MailConfig] 中的配置
+	MailDefaultPoolSize      = 2
+	MailDefaultIdleTimeout   = 30 //秒
+	MailDefaultWorkers       = 2
+	MailDefaultMaxAttempts   = 5
+	MailDefaultRetryInterval = 10    //秒
+	MailMaxRetryInterval     = 3600  //秒, 重试间隔最大值
+	MailDefaultStatusExpires = 86400 //秒
+	MailReadBlock            = 5     //秒, 读取stream的阻塞时间
+	MailClaimIdle            = 120   //秒, 发送中的邮件超过此时间未确认时, 视为发送协程异常中断, 重新认领
+	MailReclaimCount         = 100   //每次检查的pending数量
+	MailStatusQueued         = "queued"
+	MailStatusSending        = "sending"
+	MailStatusRetrying       = "retrying"
+	MailStatusSent           = "sent"
+	MailStatusFailed         = "failed"
+
+	//TOTP状态, 0 未启用
+	TotpStatusPending = 1 //已生成密钥, 待确认
+	TotpStatusEnabled = 2 //已启用
 )
 
 // 验证码类型
@@ -191,26 +313,7 @@ var AccountType = map[int]string{
 	AccountUsername: "username",
 	AccountGuest:    "guest",
 	AccountThird:    "third",
-}
-
-// 支持的第三方账号id
-var ThirdIds = map[int]string{
-	ThirdFacebook:  "Facebook",
-	ThirdGoogle:    "Google",
-	ThirdTwitter:   "Twitter",
-	ThirdYoutube:   "Youtube",
-	ThirdInstagram: "Instagram",
-	ThirdApple:     "Apple",
-	ThirdWhatsapp:  "Whatsapp",
-	ThirdSkype:     "Skype",
-	ThirdLinkedln:  "Linkedln",
-	ThirdLine:      "Line",
-	ThirdVK:        "VK",
-	ThirdWeixin:    "Weixin",
-	ThirdReddit:    "Reddit",
-	ThirdWeibo:     "Weibo",
-	ThirdQQ:        "QQ",
-	ThirdTiktok:    "Tiktok",
+	AccountPasskey:  "passkey", //账号表无此字段, 凭证存储在passkey表, hash表中为 pk_凭证id
 }
 
 // 日志钩子结构，添加日志信息
@@ -234,12 +337,89 @@ type AccountConf struct {
 	MysqlGameUserMasterList map[string]MysqlConfig
 	MysqlGameUserSlaveList  map[string]MysqlConfig
 	AliSmsConfig            AlibabaSms
+	Sms                     SmsConf
 	Base                    Base
 	HttpTimeout             HttpTimeout
 	RefreshTime             RefreshTime
 	MysqlTimeout            MysqlTimeout
 	MailConfig              MailConfig
+	Template                TemplateConf
 	RequestLimitRule        ReqLimitRule
+	JwtKeyRing              JwtKeyRingConf
+	Oidc                    OidcConf
+	Totp                    TotpConf
+	Webauthn                WebauthnConf
+	Third                   ThirdConf
+	TrustedProxy            TrustedProxyConf
+	Challenge               ChallengeConf
+}
+
+// OIDC provider配置
+type OidcConf struct {
+	Enabled        bool
+	Issuer         string //签发者, 如 https://account.example.com, 与discovery中的issuer一致
+	LoginPage      string //登录页地址, /oauth/authorize 会带上原始参数跳转到此页
+	CodeExpires    int64  //授权码有效期, 秒
+	IdTokenExpires int64  //id_token有效期, 秒
+}
+
+// 二次验证配置
+type TotpConf struct {
+	EncryptKey       string //TOTP密钥的加密key, 32位, AES-256-GCM
+	Issuer           string //认证器App中显示的名称
+	ChallengeExpires int64  //登录二次验证challenge有效期, 秒
+}
+
+// 通行密钥(WebAuthn)配置
+type WebauthnConf struct {
+	Enabled          bool
+	RpId             string   //依赖方id, 一般为主域名, 如 example.com, 注册后不能更改
+	RpName           string   //依赖方名称, 浏览器中展示
+	Origins          []string //允许的来源, 如 https://account.example.com
+	Timeout          int64    //challenge有效期, 秒
+	UserVerification bool     //是否要求用户验证(指纹、PIN等)
+}
+
+// 第三方平台配置
+type ThirdConf struct {
+	Verify      *bool                        //是否开启服务端校验, 开启后注册、登录、绑定第三方账号需要传入凭证, 不配置为开启
+	Timeout     int64                        //请求第三方接口超时, 秒
+	JwksExpires int64                        //第三方公钥缓存时间, 秒
+	Providers   map[string]ThirdProviderConf //key: 第三方名称, 如 Google
+	//数据删除进度查询地址, 返回给Facebook数据删除回调, 如 https://account.example.com/third/deletionStatus
+	DeletionStatusUrl string
+	TokenEncryptKey   string //32位, 保存的第三方token的加密key(AES-256-GCM)
+	//苹果刷新token的检查间隔, 秒, 不配置为一天(苹果限制每天最多校验一次)
+	AppleConsentCheckInterval int64
+}
+
+// 单个第三方平台配置, 地址可配置, 测试时可指向本地服务
+type ThirdProviderConf struct {
+	Games     []string //可以使用的项目及大区, 如 ["16", "18-1"], 为空则所有项目可用
+	JwksUrl   string   //id token验证公钥地址
+	Issuers   []string //id token的签发者
+	ClientIds []string //id token的aud, 各端(ios/android/web)的客户端id
+	TokenUrl  string   //token接口地址, 如Facebook的debug_token, Apple的auth/token
+	RevokeUrl string   //撤销授权地址
+	AppId     string   //access token校验使用的应用id
+	AppSecret string   //access token校验使用的应用密钥
+	BaseUrl   string   //接口域名, 如微信 https://api.weixin.qq.com, 为空使用默认
+	//授权码换取token时的回调地址, QQ需要与申请时一致
+	RedirectUri string
+	//各项目使用的应用, key格式同Games, 未配置的项目使用AppId、AppSecret
+	GameApps map[string]ThirdAppConf
+}
+
+// 第三方应用, 同一开放平台下的多个应用unionid相同
+type ThirdAppConf struct {
+	AppId     string
+	AppSecret string
+}
+
+// token签名密钥环配置
+type JwtKeyRingConf struct {
+	Path        string //密钥目录, 每个密钥一个.json文件, 为空则继续使用ServerKey(HS256)签名
+	AcceptHs256 bool   //迁移期内是否仍接受ServerKey(HS256)签名的token
 }
 
 // 请求限制规则
@@ -249,10 +429,28 @@ type ReqLimitRule struct {
 	WhiteListMap          map[string]int
 	LoginAuthWhiteList    []string `validate:"required"`
 	LoginAuthWhiteListMap map[string]int
-	Ip                    []int `validate:"required"`
+	Ip                    []int //公用ip限制, 不配置时不限制, 可改用Rules
 	Login                 []int `validate:"required"`
 	VerifyCode            []int `validate:"required"`
 	Register              []int `validate:"required"`
+	Rules                 []LimitRuleConf
+	Ipv6Prefix            int      //IPv6按前缀合并计数的长度, 默认64
+	AllowCidrs            []string //不受ip相关访问限制的ip段, 也可在ip_access_list表中维护
+	DenyCidrs             []string //禁止访问的ip段, 也可在ip_access_list表中维护
+	GeoIpFile             string   //GeoIP文件, 规则按asn、country计数时使用
+}
+
+// 接口访问限制规则, 由中间件RateLimitHandler执行
+type LimitRuleConf struct {
+	Name     string   //规则名称, 唯一, 用于计数key
+	Routes   []string //接口路径, 以*结尾为前缀匹配, 如 /user/*
+	Keys     []string //计数维度: ip, account, app_id, game_id, device_id, asn, country, 多个为组合
+	Window   int      //秒, 滑动窗口
+	Limit    int      //窗口内允许的次数
+	Action   string   //超过后: reject 拒绝, captcha 需要图形验证码, lock 锁定LockTime秒
+	LockTime int      //秒, captcha、lock的锁定时间, 不配置使用Window
+	//captcha使用的挑战类型: image、audio、slider、hcaptcha、turnstile、geetest, 不配置按项目或默认
+	Challenge string
 }
 
 // RedisConf Redis配置结构体
@@ -289,6 +487,29 @@ type Base struct {
 	AutoIncrementUid    int64  `validate:"required"`
 }
 
+// 人机验证(挑战)配置
+type ChallengeConf struct {
+	Default   string                           //默认的挑战类型, 不配置为image
+	Games     map[string]string                //key: game_id, 项目使用的挑战类型
+	Timeout   int64                            //请求第三方校验接口超时, 秒, 默认5
+	Providers map[string]ChallengeProviderConf //key: 挑战类型, 如 turnstile
+}
+
+// 单个挑战类型配置, 地址可配置, 测试时可指向本地服务
+type ChallengeProviderConf struct {
+	SiteKey   string //hCaptcha、Turnstile的site key, GeeTest的captcha_id, 返回给客户端
+	SecretKey string //hCaptcha、Turnstile的secret, GeeTest的captcha_key
+	VerifyUrl string //校验接口地址, 为空使用默认
+	Tolerance int    //滑块允许的误差, 像素, 默认5
+}
+
+// 可信代理配置, 直连地址为可信代理时才读取客户端ip header
+type TrustedProxyConf struct {
+	Cidrs         []string //可信代理的ip段, 如 10.0.0.0/8, 单个ip可不带掩码
+	Header        string   //客户端ip header, 默认 X-Forwarded-For
+	ProxyProtocol bool     //监听是否解析PROXY protocol, 四层负载均衡转发时使用
+}
+
 type HttpTimeout struct {
 	ReadTimeout  int `validate:"required"`
 	WriteTimeout int `validate:"required"`
@@ -296,10 +517,14 @@ type HttpTimeout struct {
 }
 
 type RefreshTime struct {
-	AppKeyRefreshTime     int `validate:"required"`
-	GameConfigRefreshTime int `validate:"required"`
-	UserDeleteRefreshTime int `validate:"required"`
-	HolidayRefreshTime    int `validate:"required"`
+	AppKeyRefreshTime       int `validate:"required"`
+	GameConfigRefreshTime   int `validate:"required"`
+	UserDeleteRefreshTime   int `validate:"required"`
+	HolidayRefreshTime      int `validate:"required"`
+	JwtKeyRefreshTime       int //token签名密钥环刷新时间, 不配置则与AppKeyRefreshTime一致
+	AppleConsentRefreshTime int //检查苹果授权是否撤销的定时时间, 不配置则不检查
+	TemplateRefreshTime     int //邮件、短信模板加载到内存的定时时间, 不配置则与GameConfigRefreshTime一致
+	IpAccessRefreshTime     int //ip访问名单、GeoIP文件刷新的定时时间, 不配置则与GameConfigRefreshTime一致
 }
 
 type MysqlTimeout struct {
@@ -315,6 +540,38 @@ type AlibabaSms struct {
 	SecretKey string `validate:"required"`
 }
 
+// 短信服务商配置, 未配置时使用阿里云短信
+type SmsConf struct {
+	Timeout          int64                      //请求服务商接口超时, 秒
+	DefaultProviders []string                   //未匹配路由规则时使用的服务商, 按顺序发送失败时切换
+	Routes           []SmsRouteRule             //按国家码选择服务商
+	Providers        map[string]SmsProviderConf //key: 服务商名称, 如 Aliyun、Twilio
+}
+
+// 短信路由规则
+type SmsRouteRule struct {
+	Prefixes  []string //E.164国家码, 不带+, 如 ["86"]、["1", "44"]
+	Providers []string //使用的服务商, 按顺序发送失败时切换
+}
+
+// 单个短信服务商配置, 地址可配置, 测试时可指向本地服务
+type SmsProviderConf struct {
+	BaseUrl   string //接口地址, 为空使用默认
+	RegionId  string //阿里云区域
+	AccessId  string //阿里云AccessKey ID; Twilio Account SID
+	SecretKey string //阿里云AccessKey Secret; Twilio Auth Token
+	From      string //Twilio发送号码或Messaging Service SID
+	//模板参数, 值为Go模板, 变量见TemplateVars; 为空时阿里云为 {"code"}, Twilio为 {"1"}
+	Params map[string]string
+}
+
+// 邮件、短信模板配置
+type TemplateConf struct {
+	DefaultLang string              //请求的语言及回退语言都没有模板时使用, 默认en
+	Fallback    map[string][]string //语言回退顺序, 如 zh-TW = ["zh-CN", "en"]
+	GameNames   map[string]string   //模板中的项目名称, key: 16 代表项目16的所有大区, 16-1 代表项目16的大区1
+}
+
 type MysqlConfig struct {
 	Host        string `validate:"required"`
 	User        string `validate:"required"`
@@ -335,11 +592,45 @@ type AccountResponse struct {
 
 // 邮件配置信息
 type MailConfig struct {
-	Hostname string `validate:"required"`
-	Port     int    `validate:"required"`
-	Username string `validate:"required"`
-	Password string `validate:"required"`
-	Charset  string `validate:"required"`
+	Hostname      string `validate:"required"`
+	Port          int    `validate:"required"`
+	Username      string `validate:"required"`
+	Password      string `validate:"required"`
+	Charset       string `validate:"required"`
+	From          string //发件人, 如 "XGame <noreply@example.com>", 为空使用Username
+	PoolSize      int    //每个发件身份的SMTP连接数
+	IdleTimeout   int64  //秒, 空闲连接超过此时间后重新连接
+	Workers       int    //发送协程数
+	MaxAttempts   int    //最多发送次数, 达到后状态为failed
+	RetryInterval int64  //秒, 第一次重试间隔, 之后每次翻倍
+	StatusExpires int64  //秒, 邮件发送状态保存时间
+	//各项目的发件身份, key: 16 代表项目16的所有大区, 16-1 代表项目16的大区1, 未配置的项目使用以上默认
+	Senders map[string]MailSender
+}
+
+// 发件身份
+type MailSender struct {
+	Hostname string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// 队列中的邮件及发送状态
+type MailMessage struct {
+	Id          string `json:"mail_id"`
+	GameId      int    `json:"game_id"`
+	PlatformId  int    `json:"platform_id"`
+	To          string `json:"to"`
+	Subject     string `json:"subject"`
+	Content     string `json:"content"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	Error       string `json:"error"`
+	NextTime    int64  `json:"next_time"` //下次重试时间
+	CreatedTime int64  `json:"created_time"`
+	UpdatedTime int64  `json:"updated_time"`
 }
 
 // 邮件模板
@@ -350,46 +641,57 @@ type MailTpl struct {
 	Content string
 }
 
+// ip访问名单
+type IpAccess struct {
+	Cidr       string
+	Type       int   //1: 允许, 2: 禁止
+	ExpireTime int64 //过期时间, 0不过期
+}
+
 // 短信模板模板
 type SmsTpl struct {
-	Type   string
-	LangId string
-	Title  string
-	SmsId  string
+	Type     string
+	LangId   string
+	Title    string
+	SmsId    string
+	Provider string //短信服务商, 每个服务商的模板id不同
 }
 
 // AppIdConfig SDK/服务器/客户端调用接口签名时所用的app_id, secret key等信息
 type AppIdConfig struct {
-	GameId     int    `json:"game_id" validate:"required"`
-	AppId      int64  `json:"app_id" validate:"required"`
-	SecretKey  string `json:"secret_key" validate:"required"`
-	Name       string `json:"name" validate:"required"`
-	Type       int    `json:"type" validate:"required"` //1 为SDK, 2 为服务器， 3 为客户端
-	Enabled    int    `json:"enabled" validate:"required"`
-	PlatformId int64  `json:"platform_id" validate:"required"`
+	GameId       int      `json:"game_id" validate:"required"`
+	AppId        int64    `json:"app_id" validate:"required"`
+	SecretKey    string   `json:"secret_key" validate:"required"`
+	Name         string   `json:"name" validate:"required"`
+	Type         int      `json:"type" validate:"required"` //1 为SDK, 2 为服务器， 3 为客户端
+	Enabled      int      `json:"enabled" validate:"required"`
+	PlatformId   int64    `json:"platform_id" validate:"required"`
+	RedirectUris []string `json:"redirect_uris"` //OIDC客户端登记的回调地址, 必须完全一致
 }
 
 // 用户注册协议
 type RegisterFields struct {
-	Account    string `json:"account" validate:"required"` //第三方账号拼接：如facebook账号，拼接为 1000_abc123
-	Code       string `json:"code" validate:"required"`    //手机号注册支持
-	Password   string `json:"password" validate:"required"`
-	DeviceType int    `json:"device_type" validate:"required"`
-	Type       int    `json:"type" validate:"min=1,max=5"`
-	Lang       string `json:"lang" validate:"required"`
-	ChannelId  int    `json:"channel_id" validate:"required"`
-	DataExt    string `json:"data_ext" validate:"required"`
+	Account      string `json:"account" validate:"required"` //第三方账号拼接：如facebook账号，拼接为 1000_abc123
+	Code         string `json:"code" validate:"required"`    //手机号注册支持
+	Password     string `json:"password" validate:"required"`
+	DeviceType   int    `json:"device_type" validate:"required"`
+	Type         int    `json:"type" validate:"min=1,max=5"`
+	Lang         string `json:"lang" validate:"required"`
+	ChannelId    int    `json:"channel_id" validate:"required"`
+	DataExt      string `json:"data_ext" validate:"required"`
+	ThirdAccount string `json:"third_account"` //第三方账号凭证, ThirdAccount的json, 非第三方账号不传
 	CommonFields
 }
 
 // 登录协议
 type LoginFields struct {
-	Account   string `json:"account" validate:"required"`
-	Code      string `json:"code" validate:"required"`
-	Password  string `json:"password" validate:"required"`
-	Type      int    `json:"type" validate:"min=1,max=5"`
-	ChannelId int    `json:"channel_id" validate:"required"`
-	DataExt   string `json:"data_ext" validate:"required"`
+	Account      string `json:"account" validate:"required"`
+	Code         string `json:"code" validate:"required"`
+	Password     string `json:"password" validate:"required"`
+	Type         int    `json:"type" validate:"min=1,max=5"`
+	ChannelId    int    `json:"channel_id" validate:"required"`
+	DataExt      string `json:"data_ext" validate:"required"`
+	ThirdAccount string `json:"third_account"` //第三方账号凭证, ThirdAccount的json, 非第三方账号不传
 	CommonFields
 }
 
@@ -401,6 +703,17 @@ type ThirdAccount struct {
 	ThirdEmail        string `json:"third_email"`
 	AccessToken       string `json:"access_token"`
 	AuthorizationCode string `json:"authorization_code"` //苹果AuthorizationCode
+	IdToken           string `json:"id_token"`           //Google id token, 苹果identity token
+}
+
+// 授权码换取的第三方token, 微信、QQ等
+type ThirdTokenInfo struct {
+	AppId        string
+	OpenId       string //应用下的用户id
+	UnionId      string //开放平台下的用户id, 未绑定开放平台时为空
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64 //access token有效期, 秒
 }
 
 // 登录和注册成功返回字段
@@ -443,6 +756,11 @@ type CommonFields struct {
 	Sign       string `json:"sign" validate:"required"`
 }
 
+// GetCommonFields 请求的公用字段, 用于在接口校验签名之前选择挑战类型
+func (c CommonFields) GetCommonFields() CommonFields {
+	return c
+}
+
 // 登录token数据信息结构
 type CustomClaims struct {
 	GameId     int
@@ -450,7 +768,8 @@ type CustomClaims struct {
 	ChannelId  int
 	Uid        int64
 	LoginTime  int64
-	TokenType  int //1 为刷新token, 2 为访问token(创建订单)
+	TokenType  int    //1 为刷新token, 2 为访问token(创建订单)
+	FamilyId   string //token族id, 同一次登录及其后刷新得到的token相同
 	jwt.StandardClaims
 }
 
@@ -473,6 +792,8 @@ type AccountQueryFields struct {
 	CardId   string
 	Name     string
 	Type     int
+	//TOTP状态, 0 未启用, 1 待确认, 2 已启用
+	TotpStatus int
 }
 
 // 项目用户信息
@@ -495,6 +816,25 @@ type VerifyCodeFields struct {
 	CommonFields
 }
 
+// 发送验证码返回, 邮件方式返回mail_id
+type SendCodeReturnFields struct {
+	MailId string `json:"mail_id,omitempty"`
+}
+
+// 查询邮件发送状态
+type MailStatusFields struct {
+	MailId string `json:"mail_id" validate:"required"`
+	CommonFields
+}
+
+// 邮件发送状态返回
+type MailStatusReturnFields struct {
+	MailId      string `json:"mail_id"`
+	Status      string `json:"status"`   //queued: 等待发送, sending: 发送中, retrying: 发送失败等待重试, sent: 已发送, failed: 发送失败
+	Attempts    int    `json:"attempts"` //已发送次数
+	UpdatedTime int64  `json:"updated_time"`
+}
+
 // 忘记密码-重置密码
 type ForgetPasswordFields struct {
 	Account  string `json:"account" validate:"required"`
@@ -516,13 +856,14 @@ type ChangePasswordFields struct {
 
 // 绑定账号
 type BindAccountFields struct {
-	Uid         int64  `json:"uid" validate:"required"`
-	Code        string `json:"code" validate:"required"`         //验证码， 邮箱或手机号需要验证码，第三方 为-1
-	Account     string `json:"account" validate:"required"`      //当前账号， 用于hash
-	Password    string `json:"password" validate:"required"`     //邮箱或手机号绑定时，设置的密码，第三方可以为-1,不处理
-	BindAccount string `json:"bind_account" validate:"required"` //要绑定的账号, 第三方的的信息也包含在内
-	Type        int    `json:"type" validate:"oneof=1 2 5"`      //要绑定的账号类型，如游客绑定手机号，则传入手机号的类型 2
-	LoginToken  string `json:"token" validate:"required"`        //登录token, 用于验证uid是否正确
+	Uid          int64  `json:"uid" validate:"required"`
+	Code         string `json:"code" validate:"required"`         //验证码， 邮箱或手机号需要验证码，第三方 为-1
+	Account      string `json:"account" validate:"required"`      //当前账号， 用于hash
+	Password     string `json:"password" validate:"required"`     //邮箱或手机号绑定时，设置的密码，第三方可以为-1,不处理
+	BindAccount  string `json:"bind_account" validate:"required"` //要绑定的账号, 第三方的的信息也包含在内
+	Type         int    `json:"type" validate:"oneof=1 2 5"`      //要绑定的账号类型，如游客绑定手机号，则传入手机号的类型 2
+	LoginToken   string `json:"token" validate:"required"`        //登录token, 用于验证uid是否正确
+	ThirdAccount string `json:"third_account"`                    //绑定第三方账号时的凭证, ThirdAccount的json
 	CommonFields
 }
 
@@ -544,6 +885,255 @@ type LoginAuthFields struct {
 	CommonFields
 }
 
+// 登录会话信息, 一次登录(注册)及其后刷新得到的token为一个会话
+type SessionInfo struct {
+	SessionId      string `json:"session_id"` //即token族id
+	GameId         int    `json:"game_id"`
+	PlatformId     int    `json:"platform_id"`
+	ChannelId      int    `json:"channel_id"`
+	DeviceType     int    `json:"device_type"` //1:ios，2:android，3:pc/h5，4:其他, 0 未知
+	Ip             string `json:"ip"`
+	UserAgent      string `json:"user_agent"`
+	CreatedTime    int64  `json:"created_time"`     //登录时间
+	LastActiveTime int64  `json:"last_active_time"` //最后刷新token的时间
+	Current        bool   `json:"current"`          //是否为当前请求使用的会话
+}
+
+// 会话列表请求参数
+type SessionListFields struct {
+	Uid   int64  `json:"uid" validate:"required"`
+	Token string `json:"token" validate:"required"` //登录token
+	CommonFields
+}
+
+// 撤销会话请求参数
+type RevokeSessionFields struct {
+	Uid       int64  `json:"uid" validate:"required"`
+	Token     string `json:"token" validate:"required"` //登录token
+	SessionId string `json:"session_id"`                //要撤销的会话, others 为1时不传
+	Others    int    `json:"others"`                    //1: 撤销当前会话以外的所有会话
+	CommonFields
+}
+
+// TOTP启用、确认、关闭请求参数
+type TotpFields struct {
+	Uid   int64  `json:"uid" validate:"required"`
+	Token string `json:"token" validate:"required"` //登录token
+	Code  string `json:"code"`                      //认证器App中的6位验证码, 关闭时也可使用恢复码, 生成密钥时不传
+	CommonFields
+}
+
+// 生成TOTP密钥返回
+type TotpEnrollRespFields struct {
+	Secret     string `json:"secret"`      //base32编码的密钥, 供手动输入
+	OtpauthUri string `json:"otpauth_uri"` //otpauth://totp/... 供生成二维码
+}
+
+// 确认启用TOTP返回
+type TotpConfirmRespFields struct {
+	RecoveryCodes []string `json:"recovery_codes"` //一次性恢复码, 仅返回这一次
+}
+
+// 登录需要二次验证时返回的数据
+type SecondFactorFields struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+}
+
+// 登录二次验证请求参数
+type TotpLoginFields struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"` //6位验证码或恢复码
+	CommonFields
+}
+
+// 登录二次验证challenge内容, 密码等已验证通过, 验证码通过后签发token
+type TotpChallengeInfo struct {
+	MainUid    int64             `json:"main_uid"`
+	GameId     int               `json:"game_id"`
+	PlatformId int               `json:"platform_id"`
+	ChannelId  int               `json:"channel_id"`
+	LoginRet   LoginReturnFields `json:"login_ret"`
+	Session    *SessionInfo      `json:"session"`
+}
+
+// 通行密钥注册选项请求参数
+type PasskeyOptionsFields struct {
+	Uid   int64  `json:"uid" validate:"required"`
+	Token string `json:"token" validate:"required"` //登录token
+	CommonFields
+}
+
+// 通行密钥注册请求参数, 均为浏览器 PublicKeyCredential 中的内容, 二进制使用base64url编码
+type PasskeyRegisterFields struct {
+	Uid                int64  `json:"uid" validate:"required"`
+	Token              string `json:"token" validate:"required"`                //登录token
+	ClientDataJson     string `json:"client_data_json" validate:"required"`     //response.clientDataJSON
+	AuthenticatorData  string `json:"authenticator_data" validate:"required"`   //response.getAuthenticatorData()
+	PublicKey          string `json:"public_key" validate:"required"`           //response.getPublicKey(), SPKI格式
+	PublicKeyAlgorithm int    `json:"public_key_algorithm" validate:"required"` //response.getPublicKeyAlgorithm(), -7 ES256, -257 RS256
+	Name               string `json:"name" validate:"max=64"`                   //凭证名称, 如 iPhone
+	CommonFields
+}
+
+// 通行密钥注册返回
+type PasskeyRegisterRespFields struct {
+	CredentialId string `json:"credential_id"`
+}
+
+// 通行密钥登录选项请求参数
+type PasskeyLoginOptionsFields struct {
+	CommonFields
+}
+
+// 通行密钥登录请求参数
+type PasskeyLoginFields struct {
+	CredentialId      string `json:"credential_id" validate:"required"` //rawId
+	ClientDataJson    string `json:"client_data_json" validate:"required"`
+	AuthenticatorData string `json:"authenticator_data" validate:"required"`
+	Signature         string `json:"signature" validate:"required"`
+	ChannelId         int    `json:"channel_id" validate:"required"`
+	CommonFields
+}
+
+// 苹果服务端通知, payload 为苹果签名的jwt
+type AppleNotificationFields struct {
+	Payload string `json:"payload" validate:"required"`
+}
+
+// 苹果通知中的事件, 在jwt的events中
+type AppleNotificationEvent struct {
+	Type      string `json:"type"`
+	Sub       string `json:"sub"`
+	Email     string `json:"email"`
+	EventTime int64  `json:"event_time"`
+	ClientId  string `json:"-"` //jwt的aud, 即项目的苹果客户端id
+	EventKey  string `json:"-"` //已处理标记的key, 处理失败时删除, 苹果重试时可再次处理
+}
+
+// Facebook数据删除回调的signed_request内容
+type FacebookSignedRequest struct {
+	Algorithm string `json:"algorithm"`
+	IssuedAt  int64  `json:"issued_at"`
+	UserId    string `json:"user_id"` //应用范围的用户id
+}
+
+// Facebook数据删除回调返回, 格式由Facebook规定
+type FacebookDeletionRespFields struct {
+	Url              string `json:"url"`
+	ConfirmationCode string `json:"confirmation_code"`
+}
+
+// 数据删除进度
+type ThirdDeletionStatusFields struct {
+	ConfirmationCode string `json:"confirmation_code"`
+	Status           int    `json:"status"` //注销申请状态, 见ApplyStatus
+	ApplyTime        int64  `json:"apply_time"`
+	ExecuteTime      int64  `json:"execute_time"` //冷静期结束执行注销的时间
+}
+
+// Google安全事件, 取自jwt的events
+type GoogleRiscEvent struct {
+	Type   string //事件类型, 如 GoogleRiscTokensRevoked
+	Sub    string //Google用户id
+	Reason string //account-disabled 的原因, 如 hijacking
+	//已处理标记的key, 处理失败时删除, Google重试时可再次处理
+	EventKey string `json:"-"`
+}
+
+// 第三方授权码登录协议, 微信、QQ移动端SDK返回的code
+type ThirdCodeLoginFields struct {
+	ThirdId    int    `json:"third_id" validate:"required"`
+	AuthCode   string `json:"auth_code" validate:"required"`
+	DeviceType int    `json:"device_type" validate:"required"`
+	Lang       string `json:"lang" validate:"required"`
+	ChannelId  int    `json:"channel_id" validate:"required"`
+	DataExt    string `json:"data_ext" validate:"required"`
+	CommonFields
+}
+
+// WebAuthn challenge内容
+type WebauthnChallengeInfo struct {
+	Type       string `json:"type"`     //webauthn.create 或 webauthn.get
+	MainUid    int64  `json:"main_uid"` //注册时为当前账号, 登录时为0
+	GameId     int    `json:"game_id"`
+	PlatformId int    `json:"platform_id"`
+}
+
+// clientDataJSON
+type WebauthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData解析结果
+type WebauthnAuthData struct {
+	RpIdHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialId []byte //仅注册时有
+	PublicKey    []byte //仅注册时有, 凭证公钥(COSE)转换的SPKI格式
+	Algorithm    int    //仅注册时有, 凭证公钥的算法
+}
+
+// 通行密钥凭证
+type PasskeyCredential struct {
+	CredentialId string
+	MainUid      int64
+	PublicKey    []byte //SPKI DER
+	Algorithm    int
+	SignCount    uint32
+}
+
+// 通行密钥注册选项, 对应 PublicKeyCredentialCreationOptions
+type PasskeyCreationOptions struct {
+	Challenge              string                   `json:"challenge"`
+	Rp                     map[string]string        `json:"rp"`
+	User                   map[string]string        `json:"user"`
+	PubKeyCredParams       []map[string]interface{} `json:"pubKeyCredParams"`
+	Timeout                int64                    `json:"timeout"`
+	Attestation            string                   `json:"attestation"`
+	ExcludeCredentials     []map[string]string      `json:"excludeCredentials"`
+	AuthenticatorSelection map[string]string        `json:"authenticatorSelection"`
+}
+
+// 通行密钥登录选项, 对应 PublicKeyCredentialRequestOptions
+type PasskeyRequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RpId             string `json:"rpId"`
+	Timeout          int64  `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+// 服务器登录校验v2返回, 参考token introspection
+type LoginAuthV2RespFields struct {
+	Active    bool                   `json:"active"`           //token是否有效且用户状态正常
+	ErrorCode int                    `json:"error_code"`       //无效时的原因, 见错误码
+	Status    int                    `json:"status"`           //项目用户当前状态, 1：正常, 2：被禁用，3：注销中，4：已注销, 未查询时为0
+	Claims    *LoginAuthClaimsFields `json:"claims,omitempty"` //token内的信息, 解析失败时不返回
+}
+
+// 登录token内的信息
+type LoginAuthClaimsFields struct {
+	GameId     int    `json:"game_id"`
+	PlatformId int    `json:"platform_id"`
+	ChannelId  int    `json:"channel_id"`
+	Uid        int64  `json:"uid"`
+	LoginTime  int64  `json:"login_time"`
+	TokenType  int    `json:"token_type"`
+	Jti        string `json:"jti"`
+	IssuedAt   int64  `json:"iat"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// 刷新token
+type RefreshTokenFields struct {
+	Uid          int64  `json:"uid" validate:"required"`
+	RefreshToken string `json:"refresh_token" validate:"required"` //登录、注册时返回的刷新token
+	CommonFields
+}
+
 // 白名单参数
 type WhiteListFields struct {
 	Uid int64 `json:"uid" validate:"required"`
@@ -575,7 +1165,9 @@ type UndoLogoutFields struct {
 
 // 账号注销Ext结构
 type DeleteAccountExt struct {
-	AppleRefreshToken string `json:"apple_refresh_token"`
+	AppleRefreshToken string `json:"apple_refresh_token,omitempty"` //旧数据, 苹果的刷新token
+	ThirdId           int    `json:"third_id,omitempty"`            //注销时需要撤销授权的第三方
+	RefreshToken      string `json:"refresh_token,omitempty"`       //第三方刷新token
 }
 
 // 游戏配置信息
@@ -623,12 +1215,139 @@ type UserRealNameAuthReqFields struct {
 	CommonFields
 }
 
+// token签名密钥文件
+type JwtKeyConfig struct {
+	Kid        string `json:"kid"`         //密钥id, 写入token header的kid
+	Alg        string `json:"alg"`         //RS256 或 ES256
+	Status     string `json:"status"`      //active: 签名+验证, verify: 仅验证, retired: 已停用
+	CreatedAt  int64  `json:"created_at"`  //创建时间, 多个active时使用最新的签名
+	PrivateKey string `json:"private_key"` //PEM格式私钥, active必填
+	PublicKey  string `json:"public_key"`  //PEM格式公钥, 没有私钥时必填
+}
+
+// JWKS单个公钥
+type JwkFields struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS返回
+type JwksFields struct {
+	Keys []JwkFields `json:"keys"`
+}
+
+// OIDC discovery
+type OidcDiscoveryFields struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OIDC授权请求参数, 登录页提交账号凭证
+type OidcAuthorizeFields struct {
+	ClientId            string `json:"client_id" validate:"required"`
+	RedirectUri         string `json:"redirect_uri" validate:"required"`
+	ResponseType        string `json:"response_type" validate:"required"` //仅支持code
+	Scope               string `json:"scope" validate:"required"`         //必须包含openid
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge" validate:"required"`
+	CodeChallengeMethod string `json:"code_challenge_method" validate:"required"` //仅支持S256
+	Account             string `json:"account" validate:"required"`
+	Code                string `json:"code" validate:"required"`     //验证码, 没有传-1
+	Password            string `json:"password" validate:"required"` //密码, md5后, 没有传-1
+	Type                int    `json:"type" validate:"min=1,max=3"`  //账号类型，1:email，2:手机号，3:用户名
+}
+
+// OIDC授权返回, 登录页跳转到redirect_uri
+type OidcAuthorizeRespFields struct {
+	RedirectUri string `json:"redirect_uri"`
+}
+
+// OIDC登录页提交二次验证码
+type OidcAuthorizeTotpFields struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"` //6位验证码或恢复码
+}
+
+// OIDC登录等待二次验证时保存的授权参数
+type OidcPendingInfo struct {
+	ClientId      string `json:"client_id"`
+	RedirectUri   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state"`
+	Nonce         string `json:"nonce"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// OIDC授权码记录的信息
+type OidcCodeInfo struct {
+	ClientId      string            `json:"client_id"`
+	RedirectUri   string            `json:"redirect_uri"`
+	Scope         string            `json:"scope"`
+	Nonce         string            `json:"nonce"`
+	CodeChallenge string            `json:"code_challenge"`
+	MainUid       int64             `json:"main_uid"`
+	Email         string            `json:"email"`
+	AuthTime      int64             `json:"auth_time"`
+	Tokens        LoginTokensFields `json:"tokens"`
+}
+
+// OIDC id_token
+type OidcIdTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	Email    string `json:"email,omitempty"`
+	jwt.StandardClaims
+}
+
+// OIDC token接口返回
+type OidcTokenRespFields struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OIDC userinfo返回
+type OidcUserInfoFields struct {
+	Sub   string `json:"sub"` //主账号uid
+	Uid   int64  `json:"uid"` //项目用户uid
+	Email string `json:"email,omitempty"`
+}
+
+// OAuth2错误返回
+type OauthErrorFields struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
 // 图形验证码请求参数
 type CaptchaImageFields struct {
 	Id      string `json:"id"  validate:"required"`
 	Width   int    `json:"width"  validate:"required"`  //不指定宽度传-1，使用默认宽度 240
 	Height  int    `json:"height" validate:"required"`  //不指定高度传-1，使用默认高度 80
 	Refresh int    `json:"refresh" validate:"required"` //是否刷新，1 是
+	Lang    string `json:"lang"`                        //语音验证码的语言, 如 zh-CN, 默认en
 	CommonFields
 }
 
@@ -642,8 +1361,10 @@ type CaptchaVerifyFields struct {
 
 // 触发限制访问时返回的数据格式
 type LimitLockRetFields struct {
-	CaptchaId   string `json:"captcha_id" validate:"required"`
-	CaptchaType string `json:"type" validate:"required"`
+	CaptchaId   string            `json:"captcha_id" validate:"required"`
+	CaptchaType string            `json:"type" validate:"required"`
+	Provider    string            `json:"provider"`         //挑战类型, 客户端按类型展示
+	Params      map[string]string `json:"params,omitempty"` //第三方挑战的参数, 如 site_key
 }
 
 // 根据账号 uid hash表、库结构
@@ -651,6 +1372,8 @@ type DbTable struct {
 	AccountMasterDb          *sql.DB
 	AccountSlaveDb           *sql.DB
 	AccountTable             string
+	PasskeyTable             string
+	ThirdTokenTable          string
 	GameUserMasterDb         *sql.DB
 	GameUserSlaveDb          *sql.DB
 	GameUserTable            string
@@ -0,0 +1,138 @@
+/**
+ * @project Accounts
+ * @filename geoip.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 11:00
+ * @version 1.0
+ * @description
+ * 本地GeoIP数据库, 用于访问限制规则按ASN、国家计数, 配置见 [RequestLimitRule].GeoIpFile
+ * 文件格式同 iptoasn.com 的 ip2asn-combined.tsv, 每行: 起始ip 结束ip ASN 国家码 描述, tab或逗号分隔, #开头为注释
+ * 定时检查文件修改时间, 有变化时重新加载; 加载失败时保留已加载的数据
+ */
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const GeoIpMemoryKey = "_account_geoip"
+
+type geoIpRange struct {
+	start   net.IP
+	end     net.IP
+	asn     string
+	country string
+}
+
+type geoIpDb struct {
+	file    string
+	modTime time.Time
+	ranges  []*geoIpRange //按起始ip排序
+}
+
+// LoadGeoIp 加载GeoIP文件, 替换内存中的数据
+func LoadGeoIp(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("geoip file %s error: %s", file, err.Error())
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("geoip file %s error: %s", file, err.Error())
+	}
+	defer f.Close()
+
+	ranges := []*geoIpRange{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		sep := ","
+		if strings.Contains(text, "\t") {
+			sep = "\t"
+		}
+		fields := strings.Split(text, sep)
+		if len(fields) < 4 {
+			return fmt.Errorf("geoip file %s line %d fields error", file, line)
+		}
+		start, end := net.ParseIP(strings.TrimSpace(fields[0])), net.ParseIP(strings.TrimSpace(fields[1]))
+		if start == nil || end == nil || bytes.Compare(start.To16(), end.To16()) > 0 {
+			return fmt.Errorf("geoip file %s line %d ip range error", file, line)
+		}
+		ranges = append(ranges, &geoIpRange{
+			start:   start.To16(),
+			end:     end.To16(),
+			asn:     geoIpValue(fields[2], "0"),
+			country: geoIpValue(fields[3], "None"),
+		})
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("geoip file %s read error: %s", file, err.Error())
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+	MemoryStoreInfo.Store(GeoIpMemoryKey, &geoIpDb{file: file, modTime: info.ModTime(), ranges: ranges})
+	return nil
+}
+
+// 未分配的地址段ASN为0、国家为None, 按未知处理
+func geoIpValue(value, unknown string) string {
+	value = strings.TrimSpace(value)
+	if value == unknown {
+		return ""
+	}
+	return value
+}
+
+// RefreshGeoIp 文件有修改时重新加载
+func RefreshGeoIp() {
+	file := GConf.RequestLimitRule.GeoIpFile
+	if file == "" {
+		return
+	}
+	if value, ok := MemoryStoreInfo.Load(GeoIpMemoryKey); ok {
+		db := value.(*geoIpDb)
+		info, err := os.Stat(file)
+		if err == nil && db.file == file && info.ModTime().Equal(db.modTime) {
+			return
+		}
+	}
+	if err := LoadGeoIp(file); err != nil {
+		log.Error().Msg(err.Error())
+		return
+	}
+	log.Info().Msgf("RefreshGeoIp, file: %s", file)
+}
+
+// LookupGeoIp 查询ip的ASN及国家码, 未加载或未找到时为空
+func LookupGeoIp(ip net.IP) (asn string, country string) {
+	value, ok := MemoryStoreInfo.Load(GeoIpMemoryKey)
+	if !ok || ip == nil {
+		return "", ""
+	}
+	ranges := value.(*geoIpDb).ranges
+	ip = ip.To16()
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].start, ip) > 0
+	})
+	if i == 0 || bytes.Compare(ip, ranges[i-1].end) > 0 {
+		return "", ""
+	}
+	return ranges[i-1].asn, ranges[i-1].country
+}
@@ -12,6 +12,7 @@
 package base
 
 import (
+	"accounts/limiter"
 	"context"
 	"flag"
 	"fmt"
@@ -52,7 +53,7 @@ func initConfig() {
 	confFile := flag.String("conf", "../config-file-example.toml", "the config file path")
 	host := flag.String("host", "", "set host")
 	id := flag.Int64("id", 8720, "set id")
-	buildSql := flag.String("buildDdl", "", "build table sql, value mainUser or gameUser")
+	buildSql := flag.String("buildDdl", "", "build table sql, value mainUser, gameUser, passwordMigrate, totpMigrate, passkeyMigrate or thirdTokenMigrate")
 	flag.Parse()
 	ConfFile = *confFile
 	GConf.Server.Host = *host
@@ -69,6 +70,16 @@ func initConfig() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("fail to load conf file")
 	}
+
+	//验证码最大校验次数不大于0时所有验证码都会校验失败, 使用默认值
+	if GConf.Base.CodeCheckMax <= 0 {
+		log.Warn().Msgf("CodeCheckMax %d invalid, use default %d", GConf.Base.CodeCheckMax, CodeDefaultCheckMax)
+		GConf.Base.CodeCheckMax = CodeDefaultCheckMax
+	}
+	//关闭第三方凭证校验时直接信任客户端传入的uid, 可冒用他人账号
+	if !ThirdVerifyEnabled() {
+		log.Warn().Msg("!!! [Third] Verify = false, third account credentials are NOT verified, anyone can log in with any third uid, never use it in production !!!")
+	}
 }
 
 // 初始化日志配置
@@ -105,6 +116,9 @@ func initLogs() {
 	accountLog := NewFileWriter(GConf.Server.LogRoot, "account", false)
 	log.Logger = zerolog.New(accountLog).With().Timestamp().Logger()
 
+	//安全事件日志
+	SecurityLog = zerolog.New(NewFileWriter(GConf.Server.LogRoot, "security", false)).With().Timestamp().Logger()
+
 	//输出到控制台+日志文件的日志
 	multi := zerolog.MultiLevelWriter(accountLog, os.Stdout)
 	MultipleLog = zerolog.New(multi).With().Timestamp().Logger()
@@ -124,6 +138,7 @@ func initRedis() {
 		MinIdleConns: GConf.RedisConfig.MinIdle,
 		MaxConnAge:   time.Duration(GConf.RedisConfig.MaxLifetime) * time.Second,
 	})
+	RateLimiter = limiter.New(RedisClient)
 
 	// 需要使用context库
 	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -211,6 +226,20 @@ func initMysql() {
 
 // 其它初始化
 func initOther() {
+	//可信代理配置错误时不启动
+	if err := SetTrustedProxies(GConf.TrustedProxy.Cidrs); err != nil {
+		MultipleLog.Fatal().Msgf("trusted proxy error: %s", err.Error())
+	}
+	//PROXY头只解析可信代理的连接, 没有可信代理时PROXY protocol不生效, 不启动
+	if GConf.TrustedProxy.ProxyProtocol && len(GConf.TrustedProxy.Cidrs) == 0 {
+		MultipleLog.Fatal().Msg("trusted proxy error: ProxyProtocol is enabled but Cidrs is empty")
+	}
+
+	//ip访问名单、GeoIP文件错误时不启动
+	if err := InitIpAccess(); err != nil {
+		MultipleLog.Fatal().Msgf("ip access error: %s", err.Error())
+	}
+
 	if GConf.RequestLimitRule.Enabled {
 		//将白名单list写入到map, 方便比较
 		GConf.RequestLimitRule.WhiteListMap = make(map[string]int)
@@ -218,6 +247,14 @@ func initOther() {
 			GConf.RequestLimitRule.WhiteListMap[v] = 1
 		}
 
+		//访问限制规则配置错误时不启动
+		if err := CheckLimitRules(GConf.RequestLimitRule.Rules); err != nil {
+			MultipleLog.Fatal().Msgf("request limit rules error: %s", err.Error())
+		}
+		if err := CheckChallengeConf(GConf.RequestLimitRule.Rules); err != nil {
+			MultipleLog.Fatal().Msgf("challenge error: %s", err.Error())
+		}
+
 		//将服务器登录校验白名单写入map
 		GConf.RequestLimitRule.LoginAuthWhiteListMap = make(map[string]int)
 		for _, v := range GConf.RequestLimitRule.LoginAuthWhiteList {
@@ -229,8 +266,24 @@ func initOther() {
 // 生成主账号、hash、项目用户 库、表,
 // 依据 mainUserTpl.sql生成主账号、hash库表，命令： go run main.go --buildDdl mainUser
 // 依据 gameUserTpl.sql、gameUserDeleteTpl.sql 生成项目用户库表，命令： go run main.go --buildDdl gameUser-16-1 #16-1：16 代表项目，1 代表大区
+// 依据 main_user_password_migrate_tpl.sql 生成已有主账号表的密码字段变更，命令： go run main.go --buildDdl passwordMigrate
+// 依据 main_user_totp_migrate_tpl.sql 生成已有主账号表的二次验证字段，命令： go run main.go --buildDdl totpMigrate
+// 依据 main_user_passkey_migrate_tpl.sql 生成已有主账号库的通行密钥表，命令： go run main.go --buildDdl passkeyMigrate
+// 依据 main_user_third_token_migrate_tpl.sql 生成已有主账号库的第三方token表，命令： go run main.go --buildDdl thirdTokenMigrate
 func buildDdl(table string) {
 	fmt.Printf("buildDdl params: %s\n", table)
+	if table == "passwordMigrate" {
+		buildMainUserMigrate("password")
+	}
+	if table == "totpMigrate" {
+		buildMainUserMigrate("totp")
+	}
+	if table == "passkeyMigrate" {
+		buildMainUserMigrate("passkey")
+	}
+	if table == "thirdTokenMigrate" {
+		buildMainUserMigrate("third_token")
+	}
 	if table != "mainUser" {
 		buildGameUser(table)
 	}
@@ -247,7 +300,7 @@ func buildDdl(table string) {
 		dbFile := dbName + ".sql"
 		mainUserDdl := ""
 		for tableId := 1; tableId <= tbNumber; tableId++ {
-			mainUserDdl += fmt.Sprintf(tableDdl, tableId, tableId)
+			mainUserDdl += fmt.Sprintf(tableDdl, tableId, tableId, tableId, tableId)
 		}
 		err := os.WriteFile(dbFile, []byte(ddl+mainUserDdl), os.ModePerm)
 		if err != nil {
@@ -260,6 +313,37 @@ func buildDdl(table string) {
 	os.Exit(1)
 }
 
+// 生成已有主账号表的变更, 依据 main_user_{name}_migrate_tpl.sql
+// password: 密码由md5改为argon2id, 字段需加长
+// totp: 增加二次验证字段
+// passkey: 增加通行密钥表
+// third_token: 增加第三方token表
+func buildMainUserMigrate(name string) {
+	tplFile := fmt.Sprintf("main_user_%s_migrate_tpl.sql", name)
+	content, err := os.ReadFile("./sql/" + tplFile)
+	if err != nil {
+		fmt.Printf("read %s error: %s", tplFile, err.Error())
+		os.Exit(-1)
+	}
+	tableDdl := string(content)
+
+	for dbId := 1; dbId <= MainAccountDbNumber; dbId++ {
+		dbName := fmt.Sprintf("account_info_%d", dbId)
+		ddl := fmt.Sprintf("use %s;\n", dbName)
+		for tableId := 1; tableId <= MainAccountTableNumber; tableId++ {
+			ddl += fmt.Sprintf(tableDdl, tableId)
+		}
+		err = os.WriteFile(fmt.Sprintf("%s_%s_migrate.sql", dbName, name), []byte(ddl), os.ModePerm)
+		if err != nil {
+			fmt.Printf("write %s error: %s", dbName, err.Error())
+			os.Exit(-1)
+		}
+	}
+
+	fmt.Printf("\nbuild %s migrate ddl success :)\n", name)
+	os.Exit(1)
+}
+
 // 生成项目用户表、申请删除表
 func buildGameUser(param string) {
 	params := strings.Split(param, "-")
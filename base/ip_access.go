@@ -0,0 +1,186 @@
+/**
+ * @project Accounts
+ * @filename ip_access.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/30 10:00
+ * @version 1.0
+ * @description
+ * ip访问名单及访问限制计数使用的ip
+ * 允许名单(allow)内的ip不受ip相关的访问限制; 禁止名单(deny)内的ip由IpAccessHandler中间件直接拒绝, 在访问限制计数之前, 同时在两个名单中时按禁止处理
+ * 名单来自配置 [RequestLimitRule].AllowCidrs、DenyCidrs 及 ip_access_list 表, 表中记录定时刷新, 可设置过期时间
+ * IPv6按前缀长度([RequestLimitRule].Ipv6Prefix, 默认64)合并计数, 同一前缀下的地址共用限制
+ */
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	IpAccessAllow = 1 //允许, 不受ip相关的访问限制
+	IpAccessDeny  = 2 //禁止访问
+
+	IpAccessMemoryKey      = "_account_ip_access"
+	LimitIpv6DefaultPrefix = 64
+)
+
+type ipAccessEntry struct {
+	ipNet      *net.IPNet
+	expireTime int64 //过期时间, 0不过期
+}
+
+type ipAccessList struct {
+	allow []*ipAccessEntry
+	deny  []*ipAccessEntry
+}
+
+// ParseCidr 解析CIDR, 单个ip按 /32、/128 处理
+func ParseCidr(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("%s is not ip or cidr", cidr)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%s parse error: %s", cidr, err.Error())
+	}
+	return ipNet, nil
+}
+
+// InitIpAccess 检查ip限制配置, 加载配置中的名单及GeoIP文件, 启动时调用
+func InitIpAccess() error {
+	prefix := GConf.RequestLimitRule.Ipv6Prefix
+	if prefix < 0 || prefix > 128 {
+		return fmt.Errorf("ipv6 prefix %d out of range", prefix)
+	}
+	if errs := StoreIpAccessList(nil); len(errs) > 0 {
+		return errs[0]
+	}
+	if GConf.RequestLimitRule.GeoIpFile != "" {
+		return LoadGeoIp(GConf.RequestLimitRule.GeoIpFile)
+	}
+	return nil
+}
+
+// StoreIpAccessList 合并配置及表中的名单, 替换内存中的名单, 返回解析失败的记录
+func StoreIpAccessList(records []*IpAccess) []error {
+	list := &ipAccessList{}
+	errs := []error{}
+	add := func(cidr string, accessType int, expireTime int64) {
+		ipNet, err := ParseCidr(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ip access %s", err.Error()))
+			return
+		}
+		entry := &ipAccessEntry{ipNet: ipNet, expireTime: expireTime}
+		switch accessType {
+		case IpAccessAllow:
+			list.allow = append(list.allow, entry)
+		case IpAccessDeny:
+			list.deny = append(list.deny, entry)
+		default:
+			errs = append(errs, fmt.Errorf("ip access %s unknown type: %d", cidr, accessType))
+		}
+	}
+	for _, cidr := range GConf.RequestLimitRule.AllowCidrs {
+		add(cidr, IpAccessAllow, 0)
+	}
+	for _, cidr := range GConf.RequestLimitRule.DenyCidrs {
+		add(cidr, IpAccessDeny, 0)
+	}
+	for _, record := range records {
+		add(record.Cidr, record.Type, record.ExpireTime)
+	}
+	MemoryStoreInfo.Store(IpAccessMemoryKey, list)
+	return errs
+}
+
+// 名单中是否有未过期的ip段包含此ip
+func ipAccessMatch(entries []*ipAccessEntry, ip net.IP) bool {
+	now := time.Now().Unix()
+	for _, entry := range entries {
+		if entry.expireTime > 0 && entry.expireTime <= now {
+			continue
+		}
+		if entry.ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 内存中的名单, 未加载时为空
+func loadIpAccessList() *ipAccessList {
+	value, _ := MemoryStoreInfo.Load(IpAccessMemoryKey)
+	list, ok := value.(*ipAccessList)
+	if !ok {
+		return &ipAccessList{}
+	}
+	return list
+}
+
+// CheckIpAccess 禁止名单内的ip返回错误
+func CheckIpAccess(ip string) *MyError {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+	if ipAccessMatch(loadIpAccessList().deny, parsed) {
+		return &MyError{Code: RequestLimitIpDenied, Log: fmt.Sprintf("ip %s denied", ip)}
+	}
+	return nil
+}
+
+// IpAccessHandler 禁止名单中间件, 所有接口生效, 需在ClientIpHandler之后、RateLimitHandler之前
+func IpAccessHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetRealAddr(r).String()
+		if myErr := CheckIpAccess(ip); myErr != nil {
+			ResponseFail(w, myErr, hlog.FromRequest(r).Hook(RequestHook{IP: ip, HeaderGamePlatform: r.Header.Get(HeaderGamePlatform)}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsIpExempt 是否在允许名单内且不在禁止名单内, 不受ip相关的访问限制
+func IsIpExempt(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	list := loadIpAccessList()
+	return ipAccessMatch(list.allow, parsed) && !ipAccessMatch(list.deny, parsed)
+}
+
+// LimitIpBucket 访问限制计数使用的ip, IPv6按前缀合并, 如 2001:db8:1:2::/64
+func LimitIpBucket(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+	prefix := GConf.RequestLimitRule.Ipv6Prefix
+	if prefix <= 0 {
+		prefix = LimitIpv6DefaultPrefix
+	}
+	if prefix >= 128 {
+		return parsed.String()
+	}
+	return fmt.Sprintf("%s/%d", parsed.Mask(net.CIDRMask(prefix, 128)).String(), prefix)
+}
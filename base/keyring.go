@@ -0,0 +1,267 @@
+/**
+ * @project Accounts
+ * @filename keyring.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/12 14:30
+ * @version 1.0
+ * @description
+ * token签名密钥环
+ * 支持RS256/ES256, token header中带kid, 游戏服务器通过 /.well-known/jwks.json 获取公钥离线验证
+ * 密钥状态: active 签名+验证, verify 仅验证(轮换后旧密钥), retired 停用(不加载)
+ */
+
+package base
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/zerolog/log"
+)
+
+// 单个签名密钥
+type jwtKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+	createdAt  int64
+}
+
+// 密钥环
+type jwtKeyRing struct {
+	active *jwtKey            //当前签名使用的密钥
+	keys   map[string]*jwtKey //可用于验证的密钥, key: kid
+}
+
+// RefreshJwtKeyRing 加载密钥目录, 未配置目录则继续使用ServerKey签名
+func RefreshJwtKeyRing(isFirst bool) {
+	if GConf.JwtKeyRing.Path == "" {
+		return
+	}
+	ring, err := loadJwtKeyRing(GConf.JwtKeyRing.Path)
+	if err != nil {
+		//启动时加载有错误则停止, 定时刷新时保留旧的密钥环
+		if isFirst {
+			MultipleLog.Fatal().Err(err).Msgf("load jwt key ring error: %s", err.Error())
+			return
+		}
+		log.Error().Err(err).Msgf("load jwt key ring error: %s", err.Error())
+		return
+	}
+	MemoryStoreInfo.Store(JwtKeyRingKey, ring)
+	log.Info().Str("active_kid", ring.active.kid).Int("keys", len(ring.keys)).Msg("load jwt key ring success")
+}
+
+// 读取目录下所有.json密钥文件
+func loadJwtKeyRing(path string) (*jwtKeyRing, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &jwtKeyRing{keys: map[string]*jwtKey{}}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(strings.ToLower(name), ".json") {
+			continue
+		}
+		content, err := os.ReadFile(path + "/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("read %s error: %s", name, err.Error())
+		}
+		conf := JwtKeyConfig{}
+		err = json.Unmarshal(content, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s error: %s", name, err.Error())
+		}
+		if conf.Status == JwtKeyStatusRetired {
+			continue
+		}
+		key, err := parseJwtKey(conf)
+		if err != nil {
+			return nil, fmt.Errorf("key %s error: %s", name, err.Error())
+		}
+		if _, ok := ring.keys[key.kid]; ok {
+			return nil, fmt.Errorf("duplicate kid %s", key.kid)
+		}
+		ring.keys[key.kid] = key
+
+		//多个active时使用最新的签名
+		if conf.Status == JwtKeyStatusActive && (ring.active == nil || key.createdAt > ring.active.createdAt) {
+			ring.active = key
+		}
+	}
+	if ring.active == nil {
+		return nil, errors.New("no active key")
+	}
+	return ring, nil
+}
+
+// 解析单个密钥
+func parseJwtKey(conf JwtKeyConfig) (*jwtKey, error) {
+	if conf.Kid == "" {
+		return nil, errors.New("kid empty")
+	}
+	if conf.Status != JwtKeyStatusActive && conf.Status != JwtKeyStatusVerify {
+		return nil, fmt.Errorf("status %s error", conf.Status)
+	}
+	key := &jwtKey{kid: conf.Kid, createdAt: conf.CreatedAt}
+
+	if conf.PrivateKey != "" {
+		privateKey, err := parsePrivateKeyPem(conf.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey = privateKey
+		key.publicKey = privateKey.Public()
+	} else if conf.Status == JwtKeyStatusActive {
+		return nil, errors.New("active key without private_key")
+	} else {
+		block, _ := pem.Decode([]byte(conf.PublicKey))
+		if block == nil {
+			return nil, errors.New("public_key must be PEM encoded")
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.publicKey = publicKey
+	}
+
+	switch conf.Alg {
+	case jwt.SigningMethodRS256.Alg():
+		if _, ok := key.publicKey.(*rsa.PublicKey); !ok {
+			return nil, errors.New("RS256 requires a RSA key")
+		}
+		key.method = jwt.SigningMethodRS256
+	case jwt.SigningMethodES256.Alg():
+		publicKey, ok := key.publicKey.(*ecdsa.PublicKey)
+		if !ok || publicKey.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		key.method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("alg %s not supported", conf.Alg)
+	}
+	return key, nil
+}
+
+// 解析PEM私钥, 支持PKCS8、PKCS1(RSA)、SEC1(EC)
+func parsePrivateKeyPem(content string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("private_key must be PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("private_key type not supported")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("private_key parse error")
+}
+
+// 获取内存中的密钥环, 未配置返回nil
+func getJwtKeyRing() *jwtKeyRing {
+	value, ok := MemoryStoreInfo.Load(JwtKeyRingKey)
+	if !ok {
+		return nil
+	}
+	ring, _ := value.(*jwtKeyRing)
+	return ring
+}
+
+// 签名token, 有密钥环时用active密钥并在header中写入kid, 否则用ServerKey(HS256)
+func signLoginToken(claims CustomClaims) (string, error) {
+	ring := getJwtKeyRing()
+	if ring == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(GConf.Base.ServerKey))
+	}
+	token := jwt.NewWithClaims(ring.active.method, claims)
+	token.Header["kid"] = ring.active.kid
+	return token.SignedString(ring.active.privateKey)
+}
+
+// 按token header中的alg和kid返回验证密钥
+func loginTokenVerifyKey(token *jwt.Token) (interface{}, error) {
+	ring := getJwtKeyRing()
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		//启用密钥环后, 仅在迁移期内接受HS256
+		if ring != nil && !GConf.JwtKeyRing.AcceptHs256 {
+			return nil, errors.New("HS256 token no longer accepted")
+		}
+		return []byte(GConf.Base.ServerKey), nil
+	}
+	if ring == nil {
+		return nil, fmt.Errorf("alg %s not accepted without key ring", token.Method.Alg())
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("kid %s not found", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("kid %s alg %s, token alg %s", kid, key.method.Alg(), token.Method.Alg())
+	}
+	return key.publicKey, nil
+}
+
+// GetJwks 公开的验证公钥, 包含active和verify状态的密钥
+func GetJwks() JwksFields {
+	jwks := JwksFields{Keys: []JwkFields{}}
+	ring := getJwtKeyRing()
+	if ring == nil {
+		return jwks
+	}
+
+	for _, key := range ring.keys {
+		jwk := JwkFields{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = publicKey.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	//按kid排序, 保证返回顺序稳定
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
@@ -7,18 +7,44 @@
  * @version 1.0
  * @description
  * 恶意访问限制
- * 当达到一段数量后，返回图形验证码id, 验证成功后可以继续访问
+ * 当达到一段数量后，返回挑战(图形验证码等, 见challenge.go)id, 验证成功后可以继续访问
+ * 计数使用滑动窗口(limiter), 检查并累加为一次原子操作
+ * ip按LimitIpBucket合并计数, 允许名单内的ip不受ip相关的限制
  */
 
 package base
 
 import (
+	"accounts/limiter"
 	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-// ip 限制
-func LimitIp(ip, urlPath string) *MyError {
+// 滑动窗口累加1次, Redis出错时记录日志并放行
+func limitAllow(key string, limit int, window int) *limiter.Result {
+	ret, err := RateLimiter.Allow(key, int64(limit), time.Duration(window)*time.Second)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return nil
+	}
+	return ret
+}
+
+// 滑动窗口剩余次数, 不累加
+func limitPeek(key string, limit int, window int) *limiter.Result {
+	ret, err := RateLimiter.Peek(key, int64(limit), time.Duration(window)*time.Second)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return nil
+	}
+	return ret
+}
+
+// ip 限制, 按项目选择触发后的挑战类型, gameId为签名校验通过的项目, 否则为0
+func LimitIp(ip, urlPath string, gameId int) *MyError {
 	if !GConf.RequestLimitRule.Enabled {
 		return nil
 	}
@@ -26,37 +52,38 @@ func LimitIp(ip, urlPath string) *MyError {
 	if _, ok := GConf.RequestLimitRule.WhiteListMap[urlPath]; ok {
 		return nil
 	}
+	if IsIpExempt(ip) {
+		return nil
+	}
 
-	lockKey := LimitIpLocKey + ip // li
-	val, _ := RedisClient.Get(lockKey).Int()
-	if val == 1 {
-		ret := &LimitLockRetFields{
-			CaptchaId:   BuildCaptchaId(),
-			CaptchaType: LimitIpKey,
-		}
+	//锁定按项目区分, 使用锁定时保存的挑战类型
+	ip = LimitIpBucket(ip)
+	lockKey := LimitIpLocKey + strconv.Itoa(gameId) + "_" + ip
+	if provider, ok := ChallengeLocked(lockKey); ok {
+		ret := BuildChallenge(provider, LimitIpKey, lockKey, LimitIpKey+ip)
 		return &MyError{Code: RequestLimitRuleIpTrigger, Data: ret}
 	}
 
 	conf := GConf.RequestLimitRule.Ip
+	if len(conf) == 0 {
+		return nil
+	}
 	if len(conf) != 3 {
 		return &MyError{Code: RequestLimitIpConfigError}
 	}
 
-	expire := time.Duration(conf[0]) * time.Second
-	limit := int64(conf[1])
-	lockTime := time.Duration(conf[2]) * time.Second
-
-	key := LimitIpKey + ip
-	result := RedisClient.SetNX(key, 1, expire)
-	if result.Val() {
+	//窗口内达到次数后锁定
+	ret := limitAllow(LimitIpKey+ip, conf[1], conf[0])
+	if ret == nil || ret.Remaining > 0 {
 		return nil
 	}
-
-	value := RedisClient.Incr(key).Val()
-	if value >= limit {
-		RedisClient.Set(lockKey, 1, lockTime)
+	provider := ChallengeProviderName("", gameId)
+	LockChallenge(lockKey, provider, time.Duration(conf[2])*time.Second)
+	//次数已用完时, 未锁定项目的请求也需要验证
+	if !ret.Allowed {
+		data := BuildChallenge(provider, LimitIpKey, lockKey, LimitIpKey+ip)
+		return &MyError{Code: RequestLimitRuleIpTrigger, Data: data}
 	}
-
 	return nil
 }
 
@@ -66,7 +93,11 @@ func LimitLogin(ip string) *MyError {
 		return nil
 	}
 
-	key := LimitLoginIpKey + ip //li: login ip
+	if IsIpExempt(ip) {
+		return nil
+	}
+
+	key := LimitLoginIpKey + LimitIpBucket(ip) //li: login ip
 	value, _ := RedisClient.Get(key).Int()
 	if value == 1 {
 		return &MyError{Code: RequestLimitLoginIpLock}
@@ -84,21 +115,15 @@ func LimitLoginIncr(ip, account string) *MyError {
 	if len(conf) != 3 {
 		return &MyError{Code: RequestLimitLoginConfigError}
 	}
-	allowTime := time.Duration(conf[0]) * time.Second
-	limit := int64(conf[1])
-	lockTime := time.Duration(conf[2]) * time.Second
-
-	accountKey := fmt.Sprintf("_account_limit_l_%s", account) //l: login
-	lockKey := LimitLoginIpKey + ip                           //li: login ip
-	result := RedisClient.SetNX(accountKey, 1, allowTime)
-
-	//如果是第一次 则直接返回正常
-	if result.Val() {
+	if IsIpExempt(ip) {
 		return nil
 	}
-	val := RedisClient.Incr(accountKey).Val()
-	if val >= limit {
-		RedisClient.Set(lockKey, 1, lockTime)
+
+	accountKey := fmt.Sprintf("_account_limit_l_%s", account) //l: login
+	lockKey := LimitLoginIpKey + LimitIpBucket(ip)            //li: login ip
+	ret := limitAllow(accountKey, conf[1], conf[0])
+	if ret != nil && ret.Remaining <= 0 {
+		RedisClient.Set(lockKey, 1, time.Duration(conf[2])*time.Second)
 	}
 
 	return nil
@@ -113,23 +138,18 @@ func LimitVerifyCodeIncr(ip, account string) *MyError {
 	if len(conf) != 3 {
 		return &MyError{Code: RequestLimitVerifyCodeConfigError}
 	}
-	allowTime := time.Duration(conf[0]) * time.Second
-	allowNumber := int64(conf[2])
 	accountKey := fmt.Sprintf("_account_limit_vc_%s", account) //vc: verify code
-	ipKey := fmt.Sprintf("_account_limit_vci_%s", ip)          //vci: verify code ip
-
-	//锁账号
-	accountRes := RedisClient.SetNX(accountKey, 1, allowTime)
-	ipRes := RedisClient.SetNX(ipKey, 1, allowTime)
-	if !accountRes.Val() {
-		RedisClient.Incr(accountKey)
+	limitAllow(accountKey, conf[1], conf[0])
+	if IsIpExempt(ip) {
+		return nil
 	}
-	if !ipRes.Val() {
-		val := RedisClient.Incr(ipKey).Val()
-		if val >= allowNumber {
-			lockKey := LimitCodeIpKey + ip //vcil: verify code ip lock
-			RedisClient.Set(lockKey, 1, allowTime)
-		}
+
+	ip = LimitIpBucket(ip)
+	ipKey := fmt.Sprintf("_account_limit_vci_%s", ip) //vci: verify code ip
+	ret := limitAllow(ipKey, conf[2], conf[0])
+	if ret != nil && ret.Remaining <= 0 {
+		lockKey := LimitCodeIpKey + ip //vcil: verify code ip lock
+		RedisClient.Set(lockKey, 1, time.Duration(conf[0])*time.Second)
 	}
 	return nil
 }
@@ -143,15 +163,17 @@ func LimitVerifyCode(ip, account string) *MyError {
 	if len(conf) != 3 {
 		return &MyError{Code: RequestLimitVerifyCodeConfigError}
 	}
-	allowNumber := conf[1]
 
 	accountKey := fmt.Sprintf("_account_limit_vc_%s", account) //vc: verify code
-	accountLimitVal, _ := RedisClient.Get(accountKey).Int()
-	if accountLimitVal >= allowNumber {
-		return &MyError{Code: RequestLimitVerifyCodeAccount}
+	ret := limitPeek(accountKey, conf[1], conf[0])
+	if ret != nil && !ret.Allowed {
+		return &MyError{Code: RequestLimitVerifyCodeAccount, Log: fmt.Sprintf("account %s retry after %s", account, ret.RetryAfter)}
 	}
 
-	ipLockKey := LimitCodeIpKey + ip //vcil: verify code ip lock
+	if IsIpExempt(ip) {
+		return nil
+	}
+	ipLockKey := LimitCodeIpKey + LimitIpBucket(ip) //vcil: verify code ip lock
 	ipLimitVal, _ := RedisClient.Get(ipLockKey).Int()
 	if ipLimitVal == 1 {
 		return &MyError{Code: RequestLimitVerifyCodeLockIp}
@@ -165,7 +187,10 @@ func LimitRegister(ip string) *MyError {
 	if !GConf.RequestLimitRule.Enabled {
 		return nil
 	}
-	lockKey := LimitRegisterIpKey + ip //ril = limit register ip lock
+	if IsIpExempt(ip) {
+		return nil
+	}
+	lockKey := LimitRegisterIpKey + LimitIpBucket(ip) //ril = limit register ip lock
 	val, _ := RedisClient.Get(lockKey).Int()
 	if val == 1 {
 		return &MyError{Code: RequestLimitRegisterLockIp}
@@ -182,19 +207,16 @@ func LimitRegisterIncr(ip string) *MyError {
 	if len(conf) != 3 {
 		return &MyError{Code: RequestLimitRegisterConfigError}
 	}
-	limitTime := time.Duration(conf[0]) * time.Second
-	limitNumber := int64(conf[1])
-	lockTime := time.Duration(conf[2]) * time.Second
+	if IsIpExempt(ip) {
+		return nil
+	}
 
+	ip = LimitIpBucket(ip)
 	key := fmt.Sprintf("_account_limit_ri_%s", ip) //lri = register ip
 	lockKey := LimitRegisterIpKey + ip             //ril = register ip lock
-
-	res := RedisClient.SetNX(key, 1, limitTime)
-	if !res.Val() {
-		val := RedisClient.Incr(key).Val()
-		if val >= limitNumber {
-			RedisClient.Set(lockKey, 1, lockTime)
-		}
+	ret := limitAllow(key, conf[1], conf[0])
+	if ret != nil && ret.Remaining <= 0 {
+		RedisClient.Set(lockKey, 1, time.Duration(conf[2])*time.Second)
 	}
 
 	return nil
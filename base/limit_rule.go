@@ -0,0 +1,273 @@
+/**
+ * @project Accounts
+ * @filename limit_rule.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/29 10:00
+ * @version 1.0
+ * @description
+ * 接口访问限制规则, 配置见 [[RequestLimitRule.Rules]]
+ * 中间件RateLimitHandler按接口路径匹配规则, 按计数维度(ip、账号、app_id、项目、设备、ASN、国家)累加, 超过后拒绝、要求图形验证码或锁定
+ * ip维度按LimitIpBucket合并; 允许名单内的ip不使用含ip、asn、country维度的规则
+ * 返回header: X-RateLimit-Limit、X-RateLimit-Remaining、X-RateLimit-Reset(秒), 被限制时返回http状态码429并带 Retry-After(秒)
+ */
+
+package base
+
+import (
+	"accounts/limiter"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// CheckLimitRules 检查访问限制规则配置, 启动时调用
+func CheckLimitRules(rules []LimitRuleConf) error {
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("limit rule %d name empty or duplicate: %s", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Routes) == 0 || len(rule.Keys) == 0 || rule.Window <= 0 || rule.Limit <= 0 {
+			return fmt.Errorf("limit rule %s routes, keys, window or limit empty", rule.Name)
+		}
+		for _, key := range rule.Keys {
+			switch key {
+			case LimitKeyIp, LimitKeyAccount, LimitKeyAppId, LimitKeyGameId, LimitKeyDeviceId, LimitKeyAsn, LimitKeyCountry:
+			default:
+				return fmt.Errorf("limit rule %s unknown key: %s", rule.Name, key)
+			}
+		}
+		switch rule.Action {
+		case LimitActionReject, LimitActionCaptcha, LimitActionLock:
+		default:
+			return fmt.Errorf("limit rule %s unknown action: %s", rule.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+// 接口路径是否匹配规则, 以*结尾为前缀匹配
+func limitRuleMatch(rule *LimitRuleConf, urlPath string) bool {
+	for _, route := range rule.Routes {
+		if strings.HasSuffix(route, "*") {
+			if strings.HasPrefix(urlPath, strings.TrimSuffix(route, "*")) {
+				return true
+			}
+		} else if route == urlPath {
+			return true
+		}
+	}
+	return false
+}
+
+// 规则是否使用ip相关的计数维度
+func limitRuleByIp(rule *LimitRuleConf) bool {
+	for _, key := range rule.Keys {
+		if key == LimitKeyIp || key == LimitKeyAsn || key == LimitKeyCountry {
+			return true
+		}
+	}
+	return false
+}
+
+// 规则是否需要读取请求内容, 按项目选择挑战类型时也需要
+func limitRuleNeedBody(rules []*LimitRuleConf) bool {
+	for _, rule := range rules {
+		if rule.Action == LimitActionCaptcha && rule.Challenge == "" && len(GConf.Challenge.Games) > 0 {
+			return true
+		}
+		for _, key := range rule.Keys {
+			if key != LimitKeyIp && key != LimitKeyAsn && key != LimitKeyCountry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 读取请求中的计数维度, 读取后还原请求内容, 不影响后续处理
+func limitRequestFields(r *http.Request, ip net.IP, needBody bool) map[string]string {
+	fields := map[string]string{LimitKeyIp: LimitIpBucket(ip.String())}
+	asn, country := LookupGeoIp(ip)
+	if asn != "" {
+		fields[LimitKeyAsn] = asn
+	}
+	if country != "" {
+		fields[LimitKeyCountry] = country
+	}
+	if deviceId := r.Header.Get(HeaderDeviceId); deviceId != "" {
+		fields[LimitKeyDeviceId] = deviceId
+	}
+	if !needBody || r.Body == nil {
+		return fields
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fields
+	}
+	data := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if decoder.Decode(&data) != nil {
+		return fields
+	}
+	for _, key := range []string{LimitKeyAccount, LimitKeyAppId, LimitKeyGameId, LimitKeyDeviceId} {
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		switch value := data[key].(type) {
+		case string:
+			if value != "" {
+				fields[key] = value
+			}
+		case json.Number:
+			fields[key] = value.String()
+		}
+	}
+	return fields
+}
+
+// 规则计数维度的值, 缺少任一维度时不使用此规则
+func limitRuleKey(rule *LimitRuleConf, fields map[string]string) (string, bool) {
+	values := make([]string, 0, len(rule.Keys))
+	for _, key := range rule.Keys {
+		value, ok := fields[key]
+		if !ok {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "_"), true
+}
+
+// 规则的锁定时间
+func limitRuleLockTime(rule *LimitRuleConf) time.Duration {
+	if rule.LockTime > 0 {
+		return time.Duration(rule.LockTime) * time.Second
+	}
+	return time.Duration(rule.Window) * time.Second
+}
+
+// 向上取整的秒数
+func limitSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// 返回剩余次数header
+func setLimitHeader(w http.ResponseWriter, ret *limiter.Result) {
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(ret.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(ret.Remaining, 10))
+	w.Header().Set("X-RateLimit-Reset", limitSeconds(ret.ResetAfter))
+}
+
+// 规则触发的挑战并锁定, 规则未配置挑战类型时按请求的项目选择, 锁定期间使用同一类型; 验证通过后删除captchaKey并重新计数
+func limitRuleChallenge(rule *LimitRuleConf, fields map[string]string, captchaKey, counterKey string, lockTime time.Duration) *LimitLockRetFields {
+	gameId, _ := strconv.Atoi(fields[LimitKeyGameId])
+	provider := ChallengeProviderName(rule.Challenge, gameId)
+	LockChallenge(captchaKey, provider, lockTime)
+	return BuildChallenge(provider, fmt.Sprintf(LimitRuleCaptchaFormat, rule.Name), captchaKey, counterKey)
+}
+
+// 检查并累加一条规则, 超过时按规则处理, 返回计数结果、错误及需要等待的时间
+func checkLimitRule(rule *LimitRuleConf, fields map[string]string) (*limiter.Result, *MyError, time.Duration) {
+	value, ok := limitRuleKey(rule, fields)
+	if !ok {
+		return nil, nil, 0
+	}
+	lockKey := fmt.Sprintf(LimitRuleLockFormat, rule.Name, value)
+	captchaKey := fmt.Sprintf(LimitRuleCaptchaFormat, rule.Name) + value
+	if rule.Challenge == "" && !stringInList(LimitKeyGameId, rule.Keys) {
+		//按项目选择挑战类型时锁定也按项目区分, 其他项目的挑战不能解除
+		captchaKey += "_" + fields[LimitKeyGameId]
+	}
+	counterKey := fmt.Sprintf(LimitRuleFormat, rule.Name, value)
+	switch rule.Action {
+	case LimitActionLock:
+		if ttl := RedisClient.TTL(lockKey).Val(); ttl > 0 {
+			return nil, &MyError{Code: RequestLimitRuleLocked, Log: fmt.Sprintf("limit rule %s locked: %s", rule.Name, value)}, ttl
+		}
+	case LimitActionCaptcha:
+		if provider, locked := ChallengeLocked(captchaKey); locked {
+			ret := BuildChallenge(provider, fmt.Sprintf(LimitRuleCaptchaFormat, rule.Name), captchaKey, counterKey)
+			return nil, &MyError{Code: RequestLimitRuleCaptcha, Data: ret, Log: fmt.Sprintf("limit rule %s captcha: %s", rule.Name, value)}, 0
+		}
+	}
+
+	ret := limitAllow(counterKey, rule.Limit, rule.Window)
+	if ret == nil || ret.Allowed {
+		return ret, nil, 0
+	}
+
+	logMsg := fmt.Sprintf("limit rule %s exceeded: %s, count: %d", rule.Name, value, ret.Count)
+	switch rule.Action {
+	case LimitActionLock:
+		//锁定后重新计数
+		RedisClient.Set(lockKey, 1, limitRuleLockTime(rule))
+		RateLimiter.Reset(counterKey)
+		return ret, &MyError{Code: RequestLimitRuleLocked, Log: logMsg}, limitRuleLockTime(rule)
+	case LimitActionCaptcha:
+		//挑战验证通过后删除锁定并重新计数
+		data := limitRuleChallenge(rule, fields, captchaKey, counterKey, limitRuleLockTime(rule))
+		return ret, &MyError{Code: RequestLimitRuleCaptcha, Data: data, Log: logMsg}, 0
+	}
+	return ret, &MyError{Code: RequestLimitRuleReject, Log: logMsg}, ret.RetryAfter
+}
+
+// RateLimitHandler 访问限制规则中间件
+func RateLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !GConf.RequestLimitRule.Enabled || len(GConf.RequestLimitRule.Rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		realIp := GetRealAddr(r)
+		ip := realIp.String()
+		exempt := IsIpExempt(ip)
+		rules := []*LimitRuleConf{}
+		for i := range GConf.RequestLimitRule.Rules {
+			rule := &GConf.RequestLimitRule.Rules[i]
+			if limitRuleMatch(rule, r.URL.Path) && !(exempt && limitRuleByIp(rule)) {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fields := limitRequestFields(r, realIp, limitRuleNeedBody(rules))
+		var minRet *limiter.Result
+		for _, rule := range rules {
+			ret, myErr, retryAfter := checkLimitRule(rule, fields)
+			//多条规则时返回剩余次数最少的
+			if ret != nil && (minRet == nil || ret.Remaining < minRet.Remaining) {
+				minRet = ret
+				setLimitHeader(w, ret)
+			}
+			if myErr == nil {
+				continue
+			}
+			//需要等待时返回429, 需要人机验证时客户端按错误码处理
+			status := http.StatusOK
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", limitSeconds(retryAfter))
+				status = http.StatusTooManyRequests
+			}
+			ResponseFailStatus(w, status, myErr, hlog.FromRequest(r).Hook(RequestHook{IP: ip, HeaderGamePlatform: r.Header.Get(HeaderGamePlatform)}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -6,29 +6,409 @@
  * @datetime 2023/2/10 10:22
  * @version 1.0
  * @description
- * 邮件发送
+ * 邮件发送, 及无法投递邮箱的标记(如苹果中转邮箱停用转发)
+ * 邮件先写入Redis stream队列, 由发送协程使用SMTP连接池发送, 服务重启不丢失
+ * 发送失败的邮件保留在消费组的pending中, 到重试时间后重新认领发送, 重试间隔指数增长
+ * 每封邮件的状态保存在Redis中, 客户端可通过 mail_id 查询; 邮件内容只保存到发送成功或失败
+ * 每个项目可以配置不同的发件身份, 见 [MailConfig.Senders]
  */
 
 package base
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/gomail.v2"
 )
 
-// 发送邮件
-func SendMail(mailConfig *MailConfig, mailTpl *MailTpl, toEmail string, logger *zerolog.Logger) {
+// 邮件连接池, key: 发件身份
+var mailPools sync.Map
+
+// 一个发件身份的SMTP连接池, 连接数不超过PoolSize
+type mailPool struct {
+	dialer *gomail.Dialer
+	from   string
+	idle   chan *mailConn
+	limit  chan struct{}
+}
+
+// 复用的SMTP连接
+type mailConn struct {
+	sender   gomail.SendCloser
+	lastUsed time.Time
+}
+
+// 发件身份的配置, 依次查找 Senders 中的 项目-大区、项目, 未配置时使用默认
+func MailSenderConfig(gameId, platformId int) (string, MailSender) {
+	for _, key := range []string{fmt.Sprintf("%d-%d", gameId, platformId), strconv.Itoa(gameId)} {
+		if sender, ok := GConf.MailConfig.Senders[key]; ok {
+			return key, sender
+		}
+	}
+	return MailDefaultSender, MailSender{
+		Hostname: GConf.MailConfig.Hostname,
+		Port:     GConf.MailConfig.Port,
+		Username: GConf.MailConfig.Username,
+		Password: GConf.MailConfig.Password,
+		From:     GConf.MailConfig.From,
+	}
+}
+
+// 发件身份的连接池, 第一次使用时创建
+func getMailPool(gameId, platformId int) *mailPool {
+	key, sender := MailSenderConfig(gameId, platformId)
+	if pool, ok := mailPools.Load(key); ok {
+		return pool.(*mailPool)
+	}
+	poolSize := GConf.MailConfig.PoolSize
+	if poolSize <= 0 {
+		poolSize = MailDefaultPoolSize
+	}
+	from := sender.From
+	if from == "" {
+		from = sender.Username
+	}
+	pool := &mailPool{
+		dialer: gomail.NewDialer(sender.Hostname, sender.Port, sender.Username, sender.Password),
+		from:   from,
+		idle:   make(chan *mailConn, poolSize),
+		limit:  make(chan struct{}, poolSize),
+	}
+	actual, _ := mailPools.LoadOrStore(key, pool)
+	return actual.(*mailPool)
+}
+
+// 取一个连接, 没有空闲连接时新建, 超过空闲时间的连接关闭后新建
+func (p *mailPool) get() (*mailConn, error) {
+	p.limit <- struct{}{}
+	idleTimeout := time.Duration(GConf.MailConfig.IdleTimeout) * time.Second
+	if idleTimeout <= 0 {
+		idleTimeout = MailDefaultIdleTimeout * time.Second
+	}
+	for {
+		select {
+		case conn := <-p.idle:
+			if time.Since(conn.lastUsed) < idleTimeout {
+				return conn, nil
+			}
+			conn.sender.Close()
+		default:
+			sender, err := p.dialer.Dial()
+			if err != nil {
+				<-p.limit
+				return nil, err
+			}
+			return &mailConn{sender: sender}, nil
+		}
+	}
+}
+
+// 归还连接, 发送出错的连接关闭不再使用
+func (p *mailPool) put(conn *mailConn, sendErr error) {
+	defer func() { <-p.limit }()
+	if sendErr != nil {
+		conn.sender.Close()
+		return
+	}
+	conn.lastUsed = time.Now()
+	select {
+	case p.idle <- conn:
+	default:
+		conn.sender.Close()
+	}
+}
+
+// SendMailMessage 使用项目发件身份的连接池发送一封邮件
+func SendMailMessage(msg *MailMessage) *MyError {
+	pool := getMailPool(msg.GameId, msg.PlatformId)
 	mail := gomail.NewMessage()
-	mail.SetHeader("From", mailConfig.Username)
-	mail.SetHeader("To", toEmail)
-	mail.SetHeader("Subject", mailTpl.Title)
-	mail.SetBody("text/html", mailTpl.Content)
-	ret := gomail.NewDialer(mailConfig.Hostname, mailConfig.Port, mailConfig.Username, mailConfig.Password)
-	err := ret.DialAndSend(mail)
+	mail.SetHeader("From", pool.from)
+	mail.SetHeader("To", msg.To)
+	mail.SetHeader("Subject", msg.Subject)
+	mail.SetBody("text/html", msg.Content)
+
+	conn, err := pool.get()
+	if err != nil {
+		return &MyError{Code: MailSendError, Log: fmt.Sprintf("dial smtp %s error: %s", pool.dialer.Host, err.Error())}
+	}
+	err = gomail.Send(conn.sender, mail)
+	pool.put(conn, err)
+	if err != nil {
+		return &MyError{Code: MailSendError, Log: fmt.Sprintf("send mail to %s error: %s", msg.To, err.Error())}
+	}
+	return nil
+}
+
+// MailRetryDelay 第attempts次失败后的重试间隔, 每次翻倍, 不超过MailMaxRetryInterval
+func MailRetryDelay(attempts int) int64 {
+	interval := GConf.MailConfig.RetryInterval
+	if interval <= 0 {
+		interval = MailDefaultRetryInterval
+	}
+	for i := 1; i < attempts && interval < MailMaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > MailMaxRetryInterval {
+		interval = MailMaxRetryInterval
+	}
+	return interval
+}
+
+// QueueMail 邮件写入发送队列, 返回mail_id, 用于查询发送状态
+func QueueMail(mailTpl *MailTpl, toEmail string, gameId, platformId int) (string, *MyError) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", &MyError{Code: MailQueueError, Log: "read mail id error: " + err.Error()}
+	}
+	currTime := GetTime()
+	msg := &MailMessage{
+		Id:          hex.EncodeToString(b),
+		GameId:      gameId,
+		PlatformId:  platformId,
+		To:          toEmail,
+		Subject:     mailTpl.Title,
+		Content:     mailTpl.Content,
+		Status:      MailStatusQueued,
+		CreatedTime: currTime,
+		UpdatedTime: currTime,
+	}
+	myErr := saveMailMessage(msg)
+	if myErr != nil {
+		return "", myErr
+	}
+	err = RedisClient.XAdd(&redis.XAddArgs{
+		Stream: MailStreamKey,
+		Values: map[string]interface{}{"mail_id": msg.Id},
+	}).Err()
+	if err != nil {
+		return "", &MyError{Code: MailQueueError, Log: fmt.Sprintf("add mail %s to stream error: %s", msg.Id, err.Error())}
+	}
+	return msg.Id, nil
+}
 
+// GetMailMessage 查询邮件及发送状态
+func GetMailMessage(mailId string) (*MailMessage, *MyError) {
+	content, err := RedisClient.Get(fmt.Sprintf(MailMessageFormat, mailId)).Result()
 	if err != nil {
-		logger.Error().Msgf("send %s to %s mail error: %s", mailTpl.Title, toEmail, err.Error())
+		if err == redis.Nil {
+			return nil, &MyError{Code: MailNotExists, Log: "mail id: " + mailId}
+		}
+		return nil, &MyError{Code: MailQueueError, Log: fmt.Sprintf("get mail %s error: %s", mailId, err.Error())}
+	}
+	msg := &MailMessage{}
+	err = json.Unmarshal([]byte(content), msg)
+	if err != nil {
+		return nil, &MyError{Code: MailNotExists, Log: fmt.Sprintf("mail %s unmarshal error: %s", mailId, err.Error())}
+	}
+	return msg, nil
+}
+
+// 保存邮件及状态, 超过StatusExpires后删除; 发送成功或失败后不再需要内容, 只保留状态, 避免验证码留在Redis中
+func saveMailMessage(msg *MailMessage) *MyError {
+	expires := GConf.MailConfig.StatusExpires
+	if expires <= 0 {
+		expires = MailDefaultStatusExpires
+	}
+	if msg.Status == MailStatusSent || msg.Status == MailStatusFailed {
+		msg.Content = ""
+	}
+	content, _ := json.Marshal(msg)
+	err := RedisClient.Set(fmt.Sprintf(MailMessageFormat, msg.Id), content, time.Duration(expires)*time.Second).Err()
+	if err != nil {
+		return &MyError{Code: MailQueueError, Log: fmt.Sprintf("save mail %s error: %s", msg.Id, err.Error())}
+	}
+	return nil
+}
+
+// StartMailQueue 启动邮件发送协程, 及认领重试、异常中断邮件的协程
+func StartMailQueue() {
+	err := RedisClient.XGroupCreateMkStream(MailStreamKey, MailStreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		MultipleLog.Fatal().Msgf("create mail stream group error: %s", err.Error())
+	}
+	hostname, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	workers := GConf.MailConfig.Workers
+	if workers <= 0 {
+		workers = MailDefaultWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go mailWorker(fmt.Sprintf("%s-%d", consumer, i))
+	}
+	go mailReclaimer(consumer + "-reclaim")
+}
+
+// 读取新邮件发送
+func mailWorker(consumer string) {
+	for {
+		streams, err := RedisClient.XReadGroup(&redis.XReadGroupArgs{
+			Group:    MailStreamGroup,
+			Consumer: consumer,
+			Streams:  []string{MailStreamKey, ">"},
+			Count:    1,
+			Block:    MailReadBlock * time.Second,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil {
+				log.Error().Msgf("mail worker %s read stream error: %s", consumer, err.Error())
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				processMail(message)
+			}
+		}
+	}
+}
+
+// 定时认领pending中的邮件: 到重试时间的, 及发送协程异常中断(超过MailClaimIdle未确认)的
+func mailReclaimer(consumer string) {
+	for range time.Tick(time.Second) {
+		pending, err := RedisClient.XPendingExt(&redis.XPendingExtArgs{
+			Stream: MailStreamKey,
+			Group:  MailStreamGroup,
+			Start:  "-",
+			End:    "+",
+			Count:  MailReclaimCount,
+		}).Result()
+		if err != nil {
+			log.Error().Msgf("mail reclaimer pending error: %s", err.Error())
+			continue
+		}
+		currTime := GetTime()
+		for _, entry := range pending {
+			minIdle := time.Duration(MailClaimIdle) * time.Second
+			mailId, _ := RedisClient.XRangeN(MailStreamKey, entry.Id, entry.Id, 1).Result()
+			if len(mailId) == 0 {
+				RedisClient.XAck(MailStreamKey, MailStreamGroup, entry.Id)
+				continue
+			}
+			msg, myErr := GetMailMessage(fmt.Sprint(mailId[0].Values["mail_id"]))
+			if myErr == nil && msg.Status == MailStatusRetrying {
+				if msg.NextTime > currTime {
+					continue
+				}
+				minIdle = time.Second
+			}
+			if entry.Idle < minIdle {
+				continue
+			}
+			//同时只有一个服务认领成功
+			messages, err := RedisClient.XClaim(&redis.XClaimArgs{
+				Stream:   MailStreamKey,
+				Group:    MailStreamGroup,
+				Consumer: consumer,
+				MinIdle:  minIdle,
+				Messages: []string{entry.Id},
+			}).Result()
+			if err != nil {
+				log.Error().Msgf("mail reclaimer claim %s error: %s", entry.Id, err.Error())
+				continue
+			}
+			for _, message := range messages {
+				processMail(message)
+			}
+		}
+	}
+}
+
+// 发送一封队列中的邮件, 成功或达到最大次数后确认并从stream删除, 否则留在pending中等待重试
+func processMail(message redis.XMessage) {
+	mailId := fmt.Sprint(message.Values["mail_id"])
+	logger := log.With().Str("mail_id", mailId).Logger()
+	msg, myErr := GetMailMessage(mailId)
+	if myErr != nil {
+		logger.Error().Msgf("mail message error: %s", myErr.Log)
+		finishMail(message.ID)
 		return
 	}
-	logger.Info().Msgf("send %s to %s mail success", mailTpl.Title, toEmail)
+	if msg.Status == MailStatusSent || msg.Status == MailStatusFailed {
+		finishMail(message.ID)
+		return
+	}
+
+	msg.Status = MailStatusSending
+	msg.Attempts++
+	msg.UpdatedTime = GetTime()
+	saveMailMessage(msg)
+	myErr = SendMailMessage(msg)
+	msg.UpdatedTime = GetTime()
+	if myErr == nil {
+		msg.Status = MailStatusSent
+		msg.Error = ""
+		saveMailMessage(msg)
+		finishMail(message.ID)
+		logMail(&logger, msg, "send mail success")
+		return
+	}
+
+	msg.Error = myErr.Log
+	maxAttempts := GConf.MailConfig.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = MailDefaultMaxAttempts
+	}
+	if msg.Attempts >= maxAttempts {
+		msg.Status = MailStatusFailed
+		saveMailMessage(msg)
+		finishMail(message.ID)
+		logMail(&logger, msg, "send mail failed")
+		return
+	}
+	msg.Status = MailStatusRetrying
+	msg.NextTime = msg.UpdatedTime + MailRetryDelay(msg.Attempts)
+	saveMailMessage(msg)
+	logMail(&logger, msg, "send mail error, retry later")
+}
+
+// 确认并删除stream中的邮件
+func finishMail(streamId string) {
+	RedisClient.XAck(MailStreamKey, MailStreamGroup, streamId)
+	RedisClient.XDel(MailStreamKey, streamId)
+}
+
+func logMail(logger *zerolog.Logger, msg *MailMessage, info string) {
+	logger.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("status", msg.Status).
+		Int("attempts", msg.Attempts).
+		Str("error", msg.Error).
+		Msg(info)
+}
+
+// SetEmailUndeliverable 标记邮箱是否无法投递
+func SetEmailUndeliverable(email string, undeliverable bool) *MyError {
+	var err error
+	if undeliverable {
+		err = RedisClient.SAdd(EmailUndeliverableKey, email).Err()
+	} else {
+		err = RedisClient.SRem(EmailUndeliverableKey, email).Err()
+	}
+	if err != nil {
+		return &MyError{Code: AppleNotifySaveError, Log: fmt.Sprintf("set email %s undeliverable %t error: %s", email, undeliverable, err.Error())}
+	}
+	return nil
+}
+
+// CheckEmailDeliverable 检查邮箱是否可以投递, 查询失败时按可投递处理
+func CheckEmailDeliverable(email string) *MyError {
+	undeliverable, _ := RedisClient.SIsMember(EmailUndeliverableKey, email).Result()
+	if undeliverable {
+		return &MyError{Code: EmailUndeliverable, Log: "email undeliverable: " + email}
+	}
+	return nil
 }
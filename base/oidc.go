@@ -0,0 +1,211 @@
+/**
+ * @project Accounts
+ * @filename oidc.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/14 10:40
+ * @version 1.0
+ * @description
+ * OIDC provider
+ * 客户端登记在appid目录中(type=4), client_id为app_id, client_secret为secret_key
+ * 仅支持授权码模式, 必须使用PKCE(S256), id_token使用密钥环中的active密钥签名, sub为主账号uid
+ */
+
+package base
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// GetOidcClient 获取OIDC客户端
+func GetOidcClient(clientId string) (AppIdConfig, *MyError) {
+	if !GConf.Oidc.Enabled {
+		return AppIdConfig{}, &MyError{Code: OidcDisabled}
+	}
+	appId, err := strconv.ParseInt(clientId, 10, 64)
+	if err != nil {
+		return AppIdConfig{}, &MyError{Code: OidcClientNotExists, Log: fmt.Sprintf("client_id %s error: %s", clientId, err.Error())}
+	}
+	client, myErr := getAppIdInfo(appId, AppIdTypeOidc)
+	if myErr != nil {
+		return client, &MyError{Code: OidcClientNotExists, Log: myErr.Log}
+	}
+	if client.Enabled != 1 {
+		return client, &MyError{Code: OidcClientNotExists, Log: fmt.Sprintf("client_id %s disabled", clientId)}
+	}
+	return client, nil
+}
+
+// CheckOidcRedirectUri redirect_uri必须与登记的完全一致
+func CheckOidcRedirectUri(client AppIdConfig, redirectUri string) *MyError {
+	for _, uri := range client.RedirectUris {
+		if uri == redirectUri {
+			return nil
+		}
+	}
+	return &MyError{Code: OidcRedirectUriError, Log: fmt.Sprintf("client_id %d, redirect_uri %s", client.AppId, redirectUri)}
+}
+
+// CheckOidcAuthorizeParams 检查授权请求参数, 调用前需先检查client和redirect_uri
+func CheckOidcAuthorizeParams(responseType, scope, codeChallenge, codeChallengeMethod string) *MyError {
+	if responseType != "code" {
+		return &MyError{Code: OidcResponseTypeError, Log: "response_type: " + responseType}
+	}
+	hasOpenid := false
+	for _, v := range strings.Fields(scope) {
+		if v == "openid" {
+			hasOpenid = true
+			break
+		}
+	}
+	if !hasOpenid {
+		return &MyError{Code: OidcScopeError, Log: "scope: " + scope}
+	}
+	if codeChallenge == "" || codeChallengeMethod != "S256" {
+		return &MyError{Code: OidcPkceError, Log: fmt.Sprintf("code_challenge: %s, method: %s", codeChallenge, codeChallengeMethod)}
+	}
+	return nil
+}
+
+// CheckOidcClientSecret 客户端认证
+func CheckOidcClientSecret(client AppIdConfig, clientSecret string) *MyError {
+	if subtle.ConstantTimeCompare([]byte(client.SecretKey), []byte(clientSecret)) != 1 {
+		return &MyError{Code: OidcClientAuthError, Log: fmt.Sprintf("client_id %d secret error", client.AppId)}
+	}
+	return nil
+}
+
+// CheckPkce code_verifier校验, BASE64URL(SHA256(code_verifier)) == code_challenge
+func CheckPkce(codeVerifier, codeChallenge string) *MyError {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if codeVerifier == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(codeChallenge)) != 1 {
+		return &MyError{Code: OidcCodeVerifierError, Log: fmt.Sprintf("code_challenge %s, verifier challenge %s", codeChallenge, challenge)}
+	}
+	return nil
+}
+
+// SaveOidcCode 生成授权码
+func SaveOidcCode(info *OidcCodeInfo) (string, *MyError) {
+	code := BuildTokenId()
+	content, _ := json.Marshal(info)
+	err := RedisClient.Set(fmt.Sprintf(OidcCodeFormat, code), content, time.Duration(GConf.Oidc.CodeExpires)*time.Second).Err()
+	if err != nil {
+		return "", &MyError{Code: OidcCodeSaveError, Log: fmt.Sprintf("save oidc code error: %s", err.Error())}
+	}
+	return code, nil
+}
+
+// SaveOidcPending 登录需要二次验证时保存授权参数, 与challenge同时过期
+func SaveOidcPending(token string, info *OidcPendingInfo) *MyError {
+	content, _ := json.Marshal(info)
+	err := RedisClient.Set(fmt.Sprintf(OidcPendingFormat, token), content, time.Duration(GConf.Totp.ChallengeExpires)*time.Second).Err()
+	if err != nil {
+		return &MyError{Code: OidcCodeSaveError, Log: fmt.Sprintf("save oidc pending %s error: %s", token, err.Error())}
+	}
+	return nil
+}
+
+// GetOidcPending 获取等待二次验证的授权参数, 验证码错误时可重试, 签发授权码后再删除
+func GetOidcPending(token string) (*OidcPendingInfo, *MyError) {
+	content, err := RedisClient.Get(fmt.Sprintf(OidcPendingFormat, token)).Bytes()
+	if err != nil {
+		return nil, &MyError{Code: OidcPendingInvalid, Log: fmt.Sprintf("get oidc pending %s error: %s", token, err.Error())}
+	}
+	info := &OidcPendingInfo{}
+	err = json.Unmarshal(content, info)
+	if err != nil {
+		return nil, &MyError{Code: OidcPendingInvalid, Log: fmt.Sprintf("oidc pending %s parse error: %s", token, err.Error())}
+	}
+	return info, nil
+}
+
+// DeleteOidcPending 删除等待二次验证的授权参数
+func DeleteOidcPending(token string) {
+	RedisClient.Del(fmt.Sprintf(OidcPendingFormat, token))
+}
+
+// TakeOidcCode 取出授权码, 授权码只能使用一次
+func TakeOidcCode(code string) (*OidcCodeInfo, *MyError) {
+	key := fmt.Sprintf(OidcCodeFormat, code)
+	pipe := RedisClient.TxPipeline()
+	getCmd := pipe.Get(key)
+	pipe.Del(key)
+	_, err := pipe.Exec()
+	if err != nil {
+		return nil, &MyError{Code: OidcCodeInvalid, Log: fmt.Sprintf("take oidc code error: %s", err.Error())}
+	}
+
+	info := &OidcCodeInfo{}
+	err = json.Unmarshal([]byte(getCmd.Val()), info)
+	if err != nil {
+		return nil, &MyError{Code: OidcCodeInvalid, Log: fmt.Sprintf("oidc code parse error: %s", err.Error())}
+	}
+	return info, nil
+}
+
+// BuildIdToken 生成id_token, 必须配置密钥环
+func BuildIdToken(clientId string, mainUid int64, nonce string, authTime int64, email string) (string, *MyError) {
+	ring := getJwtKeyRing()
+	if ring == nil {
+		return "", &MyError{Code: OidcIdTokenBuildError, Log: "jwt key ring not configured"}
+	}
+
+	currTime := GetTime()
+	claims := OidcIdTokenClaims{
+		Nonce:    nonce,
+		AuthTime: authTime,
+		Email:    email,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    GConf.Oidc.Issuer,
+			Subject:   strconv.FormatInt(mainUid, 10),
+			Audience:  clientId,
+			IssuedAt:  currTime,
+			ExpiresAt: currTime + GConf.Oidc.IdTokenExpires,
+		},
+	}
+	token := jwt.NewWithClaims(ring.active.method, claims)
+	token.Header["kid"] = ring.active.kid
+	idToken, err := token.SignedString(ring.active.privateKey)
+	if err != nil {
+		return "", &MyError{Code: OidcIdTokenBuildError, Log: "sign id token error: " + err.Error()}
+	}
+	return idToken, nil
+}
+
+// GetOidcDiscovery discovery文档
+func GetOidcDiscovery() (*OidcDiscoveryFields, error) {
+	if !GConf.Oidc.Enabled {
+		return nil, errors.New("oidc disabled")
+	}
+	algs := []string{}
+	if ring := getJwtKeyRing(); ring != nil {
+		algs = append(algs, ring.active.method.Alg())
+	}
+	issuer := strings.TrimRight(GConf.Oidc.Issuer, "/")
+	return &OidcDiscoveryFields{
+		Issuer:                            GConf.Oidc.Issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  algs,
+		ScopesSupported:                   []string{"openid", "email"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email"},
+	}, nil
+}
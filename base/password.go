@@ -0,0 +1,100 @@
+/**
+ * @project Accounts
+ * @filename password.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/18 10:30
+ * @version 1.0
+ * @description
+ * 密码存储, 使用argon2id, 存储格式(PHC): $argon2id$v=19$m=65536,t=3,p=2$盐$hash, 盐及参数都在其中, salt字段不再使用
+ * 兼容旧的 md5(password + salt) 格式, 登录成功后升级为argon2id
+ * 每次计算占用64MiB内存, 同时计算的数量不超过CPU核数, 突发登录时排队等待, 内存占用有上限
+ */
+
+package base
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id 参数
+const (
+	argon2Memory      = 64 * 1024 //KiB
+	argon2Iterations  = 3
+	argon2Parallelism = 2
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+	argon2Prefix      = "$argon2id$"
+	argon2MaxMemory   = 4 * argon2Memory //校验时参数的内存上限, 超过的hash不计算
+)
+
+// 同时计算argon2的数量
+var argon2Limiter = make(chan struct{}, runtime.NumCPU())
+
+// 限制并发的argon2id计算
+func argon2IDKey(password, salt []byte, iterations, memory uint32, parallelism uint8, keyLength uint32) []byte {
+	argon2Limiter <- struct{}{}
+	defer func() { <-argon2Limiter }()
+	return argon2.IDKey(password, salt, iterations, memory, parallelism, keyLength)
+}
+
+// HashPassword 生成密码hash
+func HashPassword(password string) (string, *MyError) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", &MyError{Code: PasswordHashError, Log: "read password salt error: " + err.Error()}
+	}
+	key := argon2IDKey([]byte(password), salt, argon2Iterations, argon2Memory, argon2Parallelism, argon2KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, argon2Memory, argon2Iterations, argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword 校验密码, salt 仅旧格式使用
+// needRehash 为true时表示密码正确但存储格式或参数已过时, 需要重新生成hash
+func VerifyPassword(password, encoded, salt string) (match bool, needRehash bool) {
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		legacy := Md5Sum([]byte(password + salt))
+		match = encoded != "" && subtle.ConstantTimeCompare([]byte(legacy), []byte(encoded)) == 1
+		return match, match
+	}
+
+	//$argon2id$v=19$m=65536,t=3,p=2$salt$hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	var version int
+	var memory, iterations uint32
+	var parallelism uint8
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, false
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism)
+	if err != nil || memory > argon2MaxMemory || iterations == 0 || parallelism == 0 {
+		return false, false
+	}
+	saltBytes, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false
+	}
+
+	otherKey := argon2IDKey([]byte(password), saltBytes, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false
+	}
+	needRehash = memory != argon2Memory || iterations != argon2Iterations || parallelism != argon2Parallelism || len(key) != argon2KeyLength
+	return true, needRehash
+}
@@ -0,0 +1,170 @@
+/**
+ * @project Accounts
+ * @filename proxy_protocol.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/29 16:00
+ * @version 1.0
+ * @description
+ * PROXY protocol(v1、v2), 四层负载均衡转发时获取客户端地址
+ * 只解析可信代理([TrustedProxy].Cidrs)的连接, 可信代理的连接必须带PROXY头; 其他连接按原样处理
+ */
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 读取PROXY头的超时时间
+const ProxyProtocolTimeout = 5 * time.Second
+
+// v2头的签名
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// NewProxyProtocolListener 解析PROXY头的listener
+func NewProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyProtocolListener{Listener: l}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// 第一次读取或获取地址时解析PROXY头, 不阻塞Accept
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+		if !IsTrustedProxy(parseAddrIp(c.remoteAddr.String())) {
+			return
+		}
+		_ = c.Conn.SetReadDeadline(time.Now().Add(ProxyProtocolTimeout))
+		addr, err := readProxyHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = fmt.Errorf("proxy protocol from %s: %s", c.remoteAddr.String(), err.Error())
+			return
+		}
+		//LOCAL、UNKNOWN 时使用连接地址
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remoteAddr
+}
+
+// 读取PROXY头, 返回客户端地址
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	sig, err := reader.Peek(len(proxyProtocolV2Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyProtocolV2Sig) {
+		return readProxyHeaderV2(reader)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+	return nil, errors.New("proxy protocol header missing")
+}
+
+// v1: PROXY TCP4 源ip 目标ip 源端口 目标端口\r\n, 最长107字节
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, 107)
+	for len(line) < 107 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1 header too long")
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol v1 header error: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("proxy protocol v1 address error: %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// v2: 签名(12) 版本及命令(1) 协议族(1) 地址长度(2) 地址
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol v2 version error: %d", header[12]>>4)
+	}
+	addrs := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, addrs); err != nil {
+		return nil, err
+	}
+	//LOCAL, 如负载均衡的健康检查
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 1: //IPv4
+		if len(addrs) < 12 {
+			return nil, errors.New("proxy protocol v2 ipv4 address too short")
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:4]), Port: int(binary.BigEndian.Uint16(addrs[8:10]))}, nil
+	case 2: //IPv6
+		if len(addrs) < 36 {
+			return nil, errors.New("proxy protocol v2 ipv6 address too short")
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:16]), Port: int(binary.BigEndian.Uint16(addrs[32:34]))}, nil
+	}
+	return nil, nil
+}
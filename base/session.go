@@ -0,0 +1,292 @@
+/**
+ * @project Accounts
+ * @filename session.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/10 10:20
+ * @version 1.0
+ * @description
+ * 登录会话
+ * 每个签发的token以jti为key记录在Redis中，会话不存在即视为token已撤销
+ * 同一次登录及其后刷新得到的token属于同一个族(FamilyId)，刷新token只能使用一次，重复使用则撤销整个族
+ * 每个族即一个登录会话，记录设备、ip等信息，用户可查看并撤销其他设备上的会话
+ */
+
+package base
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SaveSession 记录token会话
+func SaveSession(jti string, familyId string, mainUid int64, expireTime int64) *MyError {
+	expire := time.Duration(expireTime-GetTime()) * time.Second
+	if expire <= 0 {
+		return nil
+	}
+	sessionsKey := fmt.Sprintf(UserSessionsFormat, mainUid)
+
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(fmt.Sprintf(SessionFormat, jti), mainUid, expire)
+	pipe.SAdd(sessionsKey, jti)
+	pipe.Expire(sessionsKey, time.Duration(GConf.Base.RefreshTokenExpires)*time.Second)
+	if familyId != "" {
+		familyKey := fmt.Sprintf(TokenFamilyFormat, familyId)
+		pipe.SAdd(familyKey, jti)
+		pipe.Expire(familyKey, time.Duration(GConf.Base.RefreshTokenExpires)*time.Second)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		return &MyError{Code: SessionSaveError, Log: fmt.Sprintf("save session %s, main uid: %d, error: %s", jti, mainUid, err.Error())}
+	}
+	return nil
+}
+
+// CheckSession 检查token是否已被撤销
+// 没有jti的token为上线会话功能之前签发的，只能按主账号的撤销时间点判断
+func CheckSession(claims *CustomClaims) *MyError {
+	if claims.Id == "" {
+		mainUid := GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+		revokeTime, _ := RedisClient.Get(fmt.Sprintf(RevokeBeforeFormat, mainUid)).Int64()
+		if revokeTime > 0 && claims.LoginTime < revokeTime {
+			return &MyError{Code: LoginTokenRevoked, Log: fmt.Sprintf("token without jti, uid: %d, login time: %d, revoke time: %d", claims.Uid, claims.LoginTime, revokeTime)}
+		}
+		return nil
+	}
+
+	exists, err := RedisClient.Exists(fmt.Sprintf(SessionFormat, claims.Id)).Result()
+	if err != nil {
+		return &MyError{Code: LoginTokenRevoked, Log: fmt.Sprintf("check session %s error: %s", claims.Id, err.Error())}
+	}
+	if exists == 0 {
+		return &MyError{Code: LoginTokenRevoked, Log: fmt.Sprintf("session %s revoked, uid: %d", claims.Id, claims.Uid)}
+	}
+	return nil
+}
+
+// RevokeSession 撤销单个会话
+func RevokeSession(jti string) *MyError {
+	key := fmt.Sprintf(SessionFormat, jti)
+	mainUid, _ := RedisClient.Get(key).Int64()
+	err := RedisClient.Del(key).Err()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("revoke session %s error: %s", jti, err.Error())}
+	}
+	if mainUid > 0 {
+		RedisClient.SRem(fmt.Sprintf(UserSessionsFormat, mainUid), jti)
+	}
+	return nil
+}
+
+// RevokeAllSessions 撤销主账号下的所有会话, 如修改密码、忘记密码、账号被禁用
+func RevokeAllSessions(mainUid int64) *MyError {
+	sessionsKey := fmt.Sprintf(UserSessionsFormat, mainUid)
+	jtis, err := RedisClient.SMembers(sessionsKey).Result()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("get main uid %d sessions error: %s", mainUid, err.Error())}
+	}
+
+	familiesKey := fmt.Sprintf(UserFamiliesFormat, mainUid)
+	familyIds, _ := RedisClient.SMembers(familiesKey).Result()
+
+	keys := []string{sessionsKey, familiesKey}
+	for _, jti := range jtis {
+		keys = append(keys, fmt.Sprintf(SessionFormat, jti))
+	}
+	for _, familyId := range familyIds {
+		keys = append(keys, fmt.Sprintf(TokenFamilyFormat, familyId), fmt.Sprintf(SessionInfoFormat, familyId))
+	}
+
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(keys...)
+	pipe.Set(fmt.Sprintf(RevokeBeforeFormat, mainUid), GetTime(), time.Duration(GConf.Base.RefreshTokenExpires)*time.Second)
+	_, err = pipe.Exec()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("revoke main uid %d sessions error: %s", mainUid, err.Error())}
+	}
+	return nil
+}
+
+// RevokeTokenFamily 撤销token族内的所有会话
+func RevokeTokenFamily(familyId string) *MyError {
+	familyKey := fmt.Sprintf(TokenFamilyFormat, familyId)
+	jtis, err := RedisClient.SMembers(familyKey).Result()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("get family %s sessions error: %s", familyId, err.Error())}
+	}
+
+	keys := []string{familyKey, fmt.Sprintf(SessionInfoFormat, familyId)}
+	for _, jti := range jtis {
+		keys = append(keys, fmt.Sprintf(SessionFormat, jti))
+	}
+	err = RedisClient.Del(keys...).Err()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("revoke family %s sessions error: %s", familyId, err.Error())}
+	}
+	return nil
+}
+
+// 刷新token的使用标记key, 没有jti的刷新token为上线会话功能之前签发的, 按token内容的hash标记
+func refreshUsedKey(claims *CustomClaims, token string) string {
+	id := claims.Id
+	if id == "" {
+		sum := sha256.Sum256([]byte(token))
+		id = "h_" + hex.EncodeToString(sum[:])
+	}
+	return fmt.Sprintf(RefreshUsedFormat, id)
+}
+
+// UseRefreshToken 标记刷新token已使用, 重复使用时撤销整个token族并记录安全事件
+// 标记后生成新token失败时需调用ReleaseRefreshToken, 否则客户端重试会被当作重复使用
+func UseRefreshToken(claims *CustomClaims, token string, ip string) *MyError {
+	expire := time.Duration(claims.ExpiresAt-GetTime()) * time.Second
+	if expire <= 0 {
+		expire = time.Second
+	}
+	first, err := RedisClient.SetNX(refreshUsedKey(claims, token), GetTime(), expire).Result()
+	if err != nil {
+		return &MyError{Code: RefreshTokenMarkError, Log: fmt.Sprintf("mark refresh token %s used error: %s", claims.Id, err.Error())}
+	}
+	if first {
+		return nil
+	}
+
+	//重复使用, 刷新token可能已泄露, 撤销整个族; 没有族及jti的只记录
+	var revokeErr *MyError
+	if claims.FamilyId != "" {
+		revokeErr = RevokeTokenFamily(claims.FamilyId)
+	} else if claims.Id != "" {
+		revokeErr = RevokeSession(claims.Id)
+	}
+	event := SecurityLog.Warn().
+		Str("event", "refresh_token_reuse").
+		Int64("uid", claims.Uid).
+		Int("game_id", claims.GameId).
+		Int("platform_id", claims.PlatformId).
+		Str("jti", claims.Id).
+		Str("family_id", claims.FamilyId).
+		Str("ip", ip)
+	if revokeErr != nil {
+		event = event.Str("revoke_error", revokeErr.Log)
+	}
+	event.Msg("refresh token reused, token family revoked")
+
+	return &MyError{Code: RefreshTokenReused, Log: fmt.Sprintf("refresh token %s reused, family: %s", claims.Id, claims.FamilyId)}
+}
+
+// ReleaseRefreshToken 删除刷新token的使用标记, 标记后未能签发新token时调用, 客户端可重试
+func ReleaseRefreshToken(claims *CustomClaims, token string) {
+	if err := RedisClient.Del(refreshUsedKey(claims, token)).Err(); err != nil {
+		log.Error().Msgf("release refresh token %s error: %s", claims.Id, err.Error())
+	}
+}
+
+// SaveSessionInfo 记录登录会话信息
+func SaveSessionInfo(mainUid int64, info *SessionInfo) *MyError {
+	content, _ := json.Marshal(info)
+	expire := time.Duration(GConf.Base.RefreshTokenExpires) * time.Second
+	familiesKey := fmt.Sprintf(UserFamiliesFormat, mainUid)
+
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(fmt.Sprintf(SessionInfoFormat, info.SessionId), content, expire)
+	pipe.SAdd(familiesKey, info.SessionId)
+	pipe.Expire(familiesKey, expire)
+	_, err := pipe.Exec()
+	if err != nil {
+		return &MyError{Code: SessionSaveError, Log: fmt.Sprintf("save session info %s, main uid: %d, error: %s", info.SessionId, mainUid, err.Error())}
+	}
+	return nil
+}
+
+// TouchSessionInfo 刷新token时更新会话最后活跃时间, 有效期随新的刷新token延长
+func TouchSessionInfo(familyId string) {
+	key := fmt.Sprintf(SessionInfoFormat, familyId)
+	content, err := RedisClient.Get(key).Bytes()
+	if err != nil {
+		return
+	}
+	info := &SessionInfo{}
+	if json.Unmarshal(content, info) != nil {
+		return
+	}
+	info.LastActiveTime = GetTime()
+	content, _ = json.Marshal(info)
+	RedisClient.Set(key, content, time.Duration(GConf.Base.RefreshTokenExpires)*time.Second)
+}
+
+// ListSessions 主账号下的所有登录会话, 按最后活跃时间倒序
+func ListSessions(mainUid int64, currentFamilyId string) ([]SessionInfo, *MyError) {
+	familiesKey := fmt.Sprintf(UserFamiliesFormat, mainUid)
+	familyIds, err := RedisClient.SMembers(familiesKey).Result()
+	if err != nil {
+		return nil, &MyError{Code: SessionListError, Log: fmt.Sprintf("get main uid %d families error: %s", mainUid, err.Error())}
+	}
+
+	sessions := []SessionInfo{}
+	for _, familyId := range familyIds {
+		content, err := RedisClient.Get(fmt.Sprintf(SessionInfoFormat, familyId)).Bytes()
+		if err != nil {
+			//已过期或已撤销
+			RedisClient.SRem(familiesKey, familyId)
+			continue
+		}
+		info := SessionInfo{}
+		if json.Unmarshal(content, &info) != nil {
+			continue
+		}
+		info.Current = familyId == currentFamilyId
+		sessions = append(sessions, info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastActiveTime > sessions[j].LastActiveTime
+	})
+	return sessions, nil
+}
+
+// RevokeUserSession 撤销主账号下指定的登录会话
+func RevokeUserSession(mainUid int64, familyId string) *MyError {
+	familiesKey := fmt.Sprintf(UserFamiliesFormat, mainUid)
+	isMember, err := RedisClient.SIsMember(familiesKey, familyId).Result()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("check main uid %d family %s error: %s", mainUid, familyId, err.Error())}
+	}
+	if !isMember {
+		return &MyError{Code: SessionNotExists, Log: fmt.Sprintf("main uid %d family %s not exists", mainUid, familyId)}
+	}
+
+	revokeErr := RevokeTokenFamily(familyId)
+	if revokeErr != nil {
+		return revokeErr
+	}
+	RedisClient.SRem(familiesKey, familyId)
+	return nil
+}
+
+// RevokeOtherSessions 撤销主账号下当前会话以外的所有登录会话
+func RevokeOtherSessions(mainUid int64, currentFamilyId string) *MyError {
+	familiesKey := fmt.Sprintf(UserFamiliesFormat, mainUid)
+	familyIds, err := RedisClient.SMembers(familiesKey).Result()
+	if err != nil {
+		return &MyError{Code: SessionRevokeError, Log: fmt.Sprintf("get main uid %d families error: %s", mainUid, err.Error())}
+	}
+
+	for _, familyId := range familyIds {
+		if familyId == currentFamilyId {
+			continue
+		}
+		revokeErr := RevokeTokenFamily(familyId)
+		if revokeErr != nil {
+			return revokeErr
+		}
+		RedisClient.SRem(familiesKey, familyId)
+	}
+	return nil
+}
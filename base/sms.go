@@ -7,33 +7,158 @@
  * @version 1.0
  * @description
  * 短信发送
+ * 每个服务商实现SmsSender, 一个服务商一个文件(sms_名称.go), 在init中注册
+ * 按手机号国家码(E.164)匹配 [[Sms.Routes]] 选择服务商, 发送失败时依次切换到下一个服务商
+ * 服务商模板参数按 [Sms.Providers.名称.Params] 渲染, 见 template.go
  */
 
 package base
 
 import (
-	"github.com/aliyun/alibaba-cloud-sdk-go/services/dysmsapi"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/rs/zerolog"
 )
 
-// 阿里短信发送
-func AlibabaSmsSend(mobile string, smsTplId string, title string, code string, logger *zerolog.Logger) {
-	client, err := dysmsapi.NewClientWithAccessKey(GConf.AliSmsConfig.RegionId, GConf.AliSmsConfig.AccessId, GConf.AliSmsConfig.SecretKey)
-	if err != nil {
-		logger.Error().Msgf("ali sms new client error: %s", err.Error())
-		return
-	}
-	request := dysmsapi.CreateSendSmsRequest()
-	request.Scheme = "https"
-	request.PhoneNumbers = mobile
-	request.SignName = title
-	request.TemplateCode = smsTplId
-	request.TemplateParam = code
-	request.OutId = mobile
-	response, err := client.SendSms(request)
-	if err != nil {
-		logger.Error().Msgf("ali sms %s send to %s error: %s", smsTplId, mobile, err.Error())
-		return
-	}
-	logger.Info().Msgf("ali sms %s send to %s result: %#v", smsTplId, mobile, response)
+// 未配置 [Sms] 时使用阿里云短信, 配置为 [AliSmsConfig]
+const SmsDefaultProvider = "Aliyun"
+
+// SmsSender 短信服务商, 每个服务商一个文件, 在init中调用RegisterSmsSender注册
+type SmsSender interface {
+	// Name 服务商名称, 与配置 [Sms.Providers.名称]、sms_tpl 表的 provider 一致
+	Name() string
+	// DefaultParams 未配置Params时的模板参数
+	DefaultParams() map[string]string
+	// Send 发送短信, 服务商受理成功返回空
+	Send(msg *SmsMessage, conf SmsProviderConf) *MyError
+}
+
+// 待发送的短信
+type SmsMessage struct {
+	Mobile     string            //带国家码的手机号, 不带+, 如 8613800000000
+	TemplateId string            //服务商的模板id, 来自sms_tpl表
+	SignName   string            //短信签名, sms_tpl表的title
+	Params     map[string]string //已渲染的模板参数
+}
+
+// 已注册的短信服务商, key: 服务商名称
+var smsSenders = map[string]SmsSender{}
+
+// RegisterSmsSender 注册短信服务商, 名称重复时后注册的覆盖
+func RegisterSmsSender(sender SmsSender) {
+	smsSenders[sender.Name()] = sender
+}
+
+// GetSmsSender 按名称获取短信服务商
+func GetSmsSender(name string) (SmsSender, bool) {
+	sender, ok := smsSenders[name]
+	return sender, ok
+}
+
+// 服务商的配置, 阿里云未在 [Sms.Providers] 中配置时使用 [AliSmsConfig]
+func smsProviderConfig(name string) (SmsProviderConf, bool) {
+	conf, ok := GConf.Sms.Providers[name]
+	if !ok && name == SmsDefaultProvider && GConf.AliSmsConfig.AccessId != "" {
+		return SmsProviderConf{
+			RegionId:  GConf.AliSmsConfig.RegionId,
+			AccessId:  GConf.AliSmsConfig.AccessId,
+			SecretKey: GConf.AliSmsConfig.SecretKey,
+		}, true
+	}
+	return conf, ok
+}
+
+// SmsRouteProviders 手机号按国家码匹配的服务商, 多条规则匹配时使用最长的国家码, 未匹配时使用DefaultProviders
+func SmsRouteProviders(mobile string) []string {
+	mobile = strings.TrimPrefix(mobile, "+")
+	matchLen := 0
+	var providers []string
+	for _, route := range GConf.Sms.Routes {
+		for _, prefix := range route.Prefixes {
+			prefix = strings.TrimPrefix(prefix, "+")
+			if len(prefix) > matchLen && strings.HasPrefix(mobile, prefix) {
+				matchLen = len(prefix)
+				providers = route.Providers
+			}
+		}
+	}
+	if matchLen > 0 {
+		return providers
+	}
+	if len(GConf.Sms.DefaultProviders) > 0 {
+		return GConf.Sms.DefaultProviders
+	}
+	return []string{SmsDefaultProvider}
+}
+
+// SmsRoute 手机号可以使用的服务商, 按发送顺序; 跳过未注册、未配置及没有模板的服务商
+// templates key为服务商名称
+func SmsRoute(mobile string, templates map[string]*SmsTpl) ([]string, *MyError) {
+	providers := []string{}
+	for _, name := range SmsRouteProviders(mobile) {
+		if _, ok := GetSmsSender(name); !ok {
+			continue
+		}
+		if _, ok := smsProviderConfig(name); !ok {
+			continue
+		}
+		if _, ok := templates[name]; !ok {
+			continue
+		}
+		providers = append(providers, name)
+	}
+	if len(providers) == 0 {
+		names := make([]string, 0, len(templates))
+		for name := range templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, &MyError{Code: SmsNoProvider, Log: fmt.Sprintf("mobile %s, route providers: %v, template providers: %v", mobile, SmsRouteProviders(mobile), names)}
+	}
+	return providers, nil
+}
+
+// SendSms 依次使用服务商发送验证码短信, 失败时切换到下一个, 全部失败返回最后一个错误
+func SendSms(mobile string, vars *TemplateVars, providers []string, templates map[string]*SmsTpl, logger *zerolog.Logger) *MyError {
+	var myErr *MyError
+	for _, name := range providers {
+		sender, _ := GetSmsSender(name)
+		conf, _ := smsProviderConfig(name)
+		tpl := templates[name]
+		params := conf.Params
+		if len(params) == 0 {
+			params = sender.DefaultParams()
+		}
+		msg := &SmsMessage{
+			Mobile:     strings.TrimPrefix(mobile, "+"),
+			TemplateId: tpl.SmsId,
+			SignName:   tpl.Title,
+		}
+		msg.Params, myErr = RenderSmsParams(params, vars)
+		if myErr == nil {
+			myErr = sender.Send(msg, conf)
+		}
+		if myErr == nil {
+			logger.Info().Msgf("sms %s template %s send to %s success", name, tpl.SmsId, mobile)
+			return nil
+		}
+		logger.Error().Msgf("sms %s template %s send to %s error: %s", name, tpl.SmsId, mobile, myErr.Log)
+	}
+	if myErr == nil {
+		myErr = &MyError{Code: SmsNoProvider, Log: "mobile: " + mobile}
+	}
+	return myErr
+}
+
+// 请求短信服务商接口使用的http client
+func smsHttpClient() *http.Client {
+	timeout := GConf.Sms.Timeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
 }
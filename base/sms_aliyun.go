@@ -0,0 +1,69 @@
+/**
+ * @project Accounts
+ * @filename sms_aliyun.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/27 10:00
+ * @version 1.0
+ * @description
+ * 阿里云短信, 默认模板参数为 {"code":"验证码"}
+ * BaseUrl 为空时使用SDK的默认地址
+ */
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/dysmsapi"
+)
+
+type aliyunSmsSender struct{}
+
+func init() {
+	RegisterSmsSender(aliyunSmsSender{})
+}
+
+func (s aliyunSmsSender) Name() string {
+	return SmsDefaultProvider
+}
+
+func (s aliyunSmsSender) DefaultParams() map[string]string {
+	return map[string]string{"code": "{{.Code}}"}
+}
+
+// Send 调用SendSms, 返回的Code不是OK时为发送失败
+func (s aliyunSmsSender) Send(msg *SmsMessage, conf SmsProviderConf) *MyError {
+	client, err := dysmsapi.NewClientWithAccessKey(conf.RegionId, conf.AccessId, conf.SecretKey)
+	if err != nil {
+		return &MyError{Code: SmsProviderConfigError, Log: "aliyun sms new client error: " + err.Error()}
+	}
+	request := dysmsapi.CreateSendSmsRequest()
+	request.Scheme = "https"
+	if conf.BaseUrl != "" {
+		baseUrl, err := url.Parse(conf.BaseUrl)
+		if err != nil {
+			return &MyError{Code: SmsProviderConfigError, Log: "aliyun sms base url error: " + err.Error()}
+		}
+		request.Scheme = baseUrl.Scheme
+		request.Domain = baseUrl.Host
+	}
+	request.SetConnectTimeout(smsHttpClient().Timeout)
+	request.SetReadTimeout(smsHttpClient().Timeout)
+	request.PhoneNumbers = msg.Mobile
+	request.SignName = msg.SignName
+	request.TemplateCode = msg.TemplateId
+	params, _ := json.Marshal(msg.Params)
+	request.TemplateParam = string(params)
+	request.OutId = msg.Mobile
+	response, err := client.SendSms(request)
+	if err != nil {
+		return &MyError{Code: SmsSendError, Log: "aliyun sms send error: " + err.Error()}
+	}
+	if response.Code != "OK" {
+		return &MyError{Code: SmsSendError, Log: fmt.Sprintf("aliyun sms code: %s, message: %s, request id: %s", response.Code, response.Message, response.RequestId)}
+	}
+	return nil
+}
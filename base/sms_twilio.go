@@ -0,0 +1,89 @@
+/**
+ * @project Accounts
+ * @filename sms_twilio.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/27 10:30
+ * @version 1.0
+ * @description
+ * Twilio短信, 海外手机号使用
+ * sms_tpl 的 sms_id 为 Content Template SID(HX开头), 默认模板变量 {{1}} 为验证码
+ * AccessId 为 Account SID, SecretKey 为 Auth Token, From 为发送号码或 Messaging Service SID(MG开头)
+ */
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type twilioSmsSender struct{}
+
+func init() {
+	RegisterSmsSender(twilioSmsSender{})
+}
+
+func (s twilioSmsSender) Name() string {
+	return "Twilio"
+}
+
+func (s twilioSmsSender) DefaultParams() map[string]string {
+	return map[string]string{"1": "{{.Code}}"}
+}
+
+// Twilio接口返回, 失败时code不为0
+type twilioResult struct {
+	Sid     string `json:"sid"`
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Send 调用 Messages 接口, 返回201为已受理
+func (s twilioSmsSender) Send(msg *SmsMessage, conf SmsProviderConf) *MyError {
+	if conf.AccessId == "" || conf.SecretKey == "" || conf.From == "" {
+		return &MyError{Code: SmsProviderConfigError, Log: "twilio account sid, auth token or from empty"}
+	}
+	baseUrl := conf.BaseUrl
+	if baseUrl == "" {
+		baseUrl = TwilioApiBaseUrl
+	}
+	variables, _ := json.Marshal(msg.Params)
+	form := url.Values{}
+	form.Set("To", "+"+msg.Mobile)
+	if strings.HasPrefix(conf.From, "MG") {
+		form.Set("MessagingServiceSid", conf.From)
+	} else {
+		form.Set("From", conf.From)
+	}
+	form.Set("ContentSid", msg.TemplateId)
+	form.Set("ContentVariables", string(variables))
+
+	postUrl := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(baseUrl, "/"), url.PathEscape(conf.AccessId))
+	req, err := http.NewRequest("POST", postUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return &MyError{Code: SmsProviderConfigError, Log: "twilio new request error: " + err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(conf.AccessId, conf.SecretKey)
+	resp, err := smsHttpClient().Do(req)
+	if err != nil {
+		return &MyError{Code: SmsSendError, Log: "twilio request error: " + err.Error()}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &MyError{Code: SmsSendError, Log: "twilio read body error: " + err.Error()}
+	}
+	result := &twilioResult{}
+	_ = json.Unmarshal(body, result)
+	if resp.StatusCode >= http.StatusMultipleChoices || result.Code != 0 {
+		return &MyError{Code: SmsSendError, Log: fmt.Sprintf("twilio status: %d, code: %d, message: %s", resp.StatusCode, result.Code, result.Message)}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+/**
+ * @project Accounts
+ * @filename template.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/28 10:00
+ * @version 1.0
+ * @description
+ * 邮件、短信模板渲染
+ * 邮件模板(mail_tpl)的标题、内容及短信服务商的模板参数([Sms.Providers.名称.Params])使用Go模板语法, 变量见 TemplateVars
+ * 模板定时从数据库加载到内存, 请求的语言没有模板时按 [Template.Fallback] 依次回退, 最后使用 DefaultLang
+ * 兼容旧模板中的 {CODE}
+ */
+
+package base
+
+import (
+	"bytes"
+	"fmt"
+	htmlTemplate "html/template"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+)
+
+// 模板都不存在时使用的语言
+const TemplateDefaultLang = "en"
+
+// 内存中模板的key
+const (
+	MailTemplateMemoryKey = "_account_mail_template"
+	SmsTemplateMemoryKey  = "_account_sms_template"
+)
+
+// 模板变量, 模板中使用 {{.Code}}、{{.ExpireMinutes}} 等
+type TemplateVars struct {
+	Code          string //验证码
+	ExpireMinutes int64  //验证码有效期, 分钟, 即配置的CodeExpires
+	Account       string //脱敏后的账号, 如 ab***@example.com, 861****0000
+	GameName      string //项目名称, 见 [Template.GameNames]
+	Ip            string //请求ip
+	Time          string //请求时间, 2006-01-02 15:04:05
+}
+
+// 已解析的邮件模板
+type mailTemplate struct {
+	tpl     *MailTpl
+	title   *textTemplate.Template
+	content *htmlTemplate.Template
+}
+
+// NewTemplateVars 验证码模板变量
+func NewTemplateVars(code, account, ip string, gameId, platformId int) *TemplateVars {
+	return &TemplateVars{
+		Code:          code,
+		ExpireMinutes: GConf.Base.CodeExpires,
+		Account:       MaskAccount(account),
+		GameName:      TemplateGameName(gameId, platformId),
+		Ip:            ip,
+		Time:          time.Now().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// MaskAccount 账号脱敏, 邮箱保留前2位及域名, 手机号保留前3位及后4位
+func MaskAccount(account string) string {
+	if at := strings.LastIndex(account, "@"); at > 0 {
+		keep := 2
+		if at <= keep {
+			keep = 1
+		}
+		return account[:keep] + "***" + account[at:]
+	}
+	if len(account) > 7 {
+		return account[:3] + "****" + account[len(account)-4:]
+	}
+	if len(account) > 2 {
+		return account[:1] + "***" + account[len(account)-1:]
+	}
+	return "***"
+}
+
+// TemplateGameName 项目名称, 先查 "项目-大区", 再查 "项目"
+func TemplateGameName(gameId, platformId int) string {
+	if name, ok := GConf.Template.GameNames[fmt.Sprintf("%d-%d", gameId, platformId)]; ok {
+		return name
+	}
+	return GConf.Template.GameNames[strconv.Itoa(gameId)]
+}
+
+// TemplateLangChain 语言回退顺序: 请求的语言, [Template.Fallback] 中配置的语言, DefaultLang
+func TemplateLangChain(lang string) []string {
+	defaultLang := GConf.Template.DefaultLang
+	if defaultLang == "" {
+		defaultLang = TemplateDefaultLang
+	}
+	chain := []string{}
+	seen := map[string]bool{}
+	for _, item := range append(append([]string{lang}, GConf.Template.Fallback[lang]...), defaultLang) {
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		chain = append(chain, item)
+	}
+	return chain
+}
+
+// 模板在内存中的key
+func templateKey(codeType, lang string) string {
+	return codeType + "_" + lang
+}
+
+// 旧模板使用 {CODE} 表示验证码
+func templateCompatible(text string) string {
+	return strings.Replace(text, "{CODE}", "{{.Code}}", -1)
+}
+
+// StoreMailTemplates 解析邮件模板并替换内存中的全部模板, 解析失败的模板跳过
+func StoreMailTemplates(tpls []*MailTpl) []error {
+	templates := map[string]*mailTemplate{}
+	var errs []error
+	for _, tpl := range tpls {
+		key := templateKey(tpl.Type, tpl.LangId)
+		title, err := textTemplate.New(key).Option("missingkey=error").Parse(templateCompatible(tpl.Title))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mail template %s title: %s", key, err.Error()))
+			continue
+		}
+		content, err := htmlTemplate.New(key).Option("missingkey=error").Parse(templateCompatible(tpl.Content))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mail template %s content: %s", key, err.Error()))
+			continue
+		}
+		templates[key] = &mailTemplate{tpl: tpl, title: title, content: content}
+	}
+	MemoryStoreInfo.Store(MailTemplateMemoryKey, templates)
+	return errs
+}
+
+// StoreSmsTemplates 替换内存中的全部短信模板
+func StoreSmsTemplates(tpls []*SmsTpl) {
+	templates := map[string]map[string]*SmsTpl{}
+	for _, tpl := range tpls {
+		key := templateKey(tpl.Type, tpl.LangId)
+		if templates[key] == nil {
+			templates[key] = map[string]*SmsTpl{}
+		}
+		templates[key][tpl.Provider] = tpl
+	}
+	MemoryStoreInfo.Store(SmsTemplateMemoryKey, templates)
+}
+
+// RenderMailTpl 按语言回退顺序查找邮件模板并渲染标题及内容
+func RenderMailTpl(codeType int, lang string, vars *TemplateVars) (*MailTpl, *MyError) {
+	value, _ := MemoryStoreInfo.Load(MailTemplateMemoryKey)
+	templates, _ := value.(map[string]*mailTemplate)
+	for _, item := range TemplateLangChain(lang) {
+		tpl, ok := templates[templateKey(strconv.Itoa(codeType), item)]
+		if !ok {
+			continue
+		}
+		title := &bytes.Buffer{}
+		if err := tpl.title.Execute(title, vars); err != nil {
+			return nil, &MyError{Code: TemplateRenderError, Log: fmt.Sprintf("render mail template %d_%s title error: %s", codeType, item, err.Error())}
+		}
+		content := &bytes.Buffer{}
+		if err := tpl.content.Execute(content, vars); err != nil {
+			return nil, &MyError{Code: TemplateRenderError, Log: fmt.Sprintf("render mail template %d_%s content error: %s", codeType, item, err.Error())}
+		}
+		return &MailTpl{Type: tpl.tpl.Type, LangId: tpl.tpl.LangId, Title: title.String(), Content: content.String()}, nil
+	}
+	return nil, &MyError{Code: MailTplConfigQueryFailure, Log: fmt.Sprintf("mail template type %d, lang %v not exists", codeType, TemplateLangChain(lang))}
+}
+
+// SmsTemplates 短信模板, key为服务商名称; 每个服务商按语言回退顺序取第一个存在的模板
+func SmsTemplates(codeType int, lang string) map[string]*SmsTpl {
+	value, _ := MemoryStoreInfo.Load(SmsTemplateMemoryKey)
+	templates, _ := value.(map[string]map[string]*SmsTpl)
+	ret := map[string]*SmsTpl{}
+	chain := TemplateLangChain(lang)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for provider, tpl := range templates[templateKey(strconv.Itoa(codeType), chain[i])] {
+			ret[provider] = tpl
+		}
+	}
+	return ret
+}
+
+// RenderSmsParams 渲染短信服务商的模板参数
+func RenderSmsParams(params map[string]string, vars *TemplateVars) (map[string]string, *MyError) {
+	ret := make(map[string]string, len(params))
+	for name, text := range params {
+		tpl, err := textTemplate.New(name).Option("missingkey=error").Parse(templateCompatible(text))
+		if err != nil {
+			return nil, &MyError{Code: TemplateRenderError, Log: fmt.Sprintf("parse sms param %s error: %s", name, err.Error())}
+		}
+		value := &bytes.Buffer{}
+		if err = tpl.Execute(value, vars); err != nil {
+			return nil, &MyError{Code: TemplateRenderError, Log: fmt.Sprintf("render sms param %s error: %s", name, err.Error())}
+		}
+		ret[name] = value.String()
+	}
+	return ret, nil
+}
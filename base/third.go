@@ -0,0 +1,553 @@
+/**
+ * @project Accounts
+ * @filename third.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/24 10:30
+ * @version 1.0
+ * @description
+ * 第三方平台, 每个平台实现ThirdProvider, 一个平台一个文件(third_名称.go), 在init中注册
+ * 注册、登录、绑定时使用第三方凭证得到第三方uid, 不再信任客户端传入的uid
+ * 公共部分: id token校验(JWKS公钥缓存)、请求第三方接口、通知去重、数据删除确认码
+ * 保存的第三方token使用 AES-256-GCM 加密, key为 Third.TokenEncryptKey
+ */
+
+package base
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// 公钥中没有token的kid时, 最短的重新拉取间隔, 秒, 防止伪造kid的请求频繁拉取
+const thirdJwksMinRefresh = 60
+
+// 第三方公钥缓存
+type thirdJwks struct {
+	keys      map[string]crypto.PublicKey //key: kid
+	fetchedAt int64
+	expiresAt int64
+}
+
+var (
+	thirdJwksCache = map[string]*thirdJwks{} //key: JwksUrl
+	thirdJwksLock  sync.Mutex
+)
+
+// ThirdProvider 第三方平台, 每个平台一个文件, 在init中调用RegisterThirdProvider注册
+type ThirdProvider interface {
+	Id() int      //第三方编码, 如 1002
+	Name() string //名称, 与配置 [Third.Providers.名称] 一致
+	// VerifyCredential 校验客户端传入的凭证, 返回第三方uid
+	VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError)
+	// ExchangeToken 使用授权码换取刷新token, 注销时用于撤销授权, 不需要时返回空
+	ExchangeToken(third *ThirdAccount, conf ThirdProviderConf, gameConfig *GameConfig) (string, *MyError)
+	// RevokeToken 账号注销后撤销第三方授权
+	RevokeToken(refreshToken string, conf ThirdProviderConf, gameConfig *GameConfig) *MyError
+	// ExchangeCode 移动端SDK返回的授权码换取token及用户id, 微信、QQ等
+	ExchangeCode(code string, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError)
+}
+
+// 通过授权码换取uid的第三方(微信、QQ), 返回换取结果, unionid、openid都可能对应已有账号
+type thirdTokenVerifier interface {
+	VerifyToken(third *ThirdAccount, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError)
+}
+
+// ThirdAccountResolver 换取结果对应的第三方账号, 见 models.ResolveThirdAccount, 兼容接入unionid之前注册的openid账号
+type ThirdAccountResolver func(thirdId int, tokenInfo *ThirdTokenInfo) string
+
+// 只有编码和名称的第三方, 不支持服务端校验, 其他平台可嵌入后按需实现
+type thirdProviderBase struct {
+	id   int
+	name string
+}
+
+func (p thirdProviderBase) Id() int {
+	return p.id
+}
+
+func (p thirdProviderBase) Name() string {
+	return p.name
+}
+
+func (p thirdProviderBase) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	return "", &MyError{Code: ThirdVerifyUnsupported, Log: fmt.Sprintf("third %s does not support verification", p.name)}
+}
+
+func (p thirdProviderBase) ExchangeToken(third *ThirdAccount, conf ThirdProviderConf, gameConfig *GameConfig) (string, *MyError) {
+	return "", nil
+}
+
+func (p thirdProviderBase) RevokeToken(refreshToken string, conf ThirdProviderConf, gameConfig *GameConfig) *MyError {
+	return nil
+}
+
+func (p thirdProviderBase) ExchangeCode(code string, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError) {
+	return nil, &MyError{Code: ThirdVerifyUnsupported, Log: fmt.Sprintf("third %s does not support authorization code", p.name)}
+}
+
+// 已注册的第三方, key: 第三方编码
+var thirdProviders = map[int]ThirdProvider{}
+
+// RegisterThirdProvider 注册第三方, 编码重复时后注册的覆盖
+func RegisterThirdProvider(provider ThirdProvider) {
+	thirdProviders[provider.Id()] = provider
+}
+
+// GetThirdProvider 按编码获取第三方
+func GetThirdProvider(thirdId int) (ThirdProvider, bool) {
+	provider, ok := thirdProviders[thirdId]
+	return provider, ok
+}
+
+// GetThirdProviders 所有已注册的第三方, 按编码排序
+func GetThirdProviders() []ThirdProvider {
+	providers := make([]ThirdProvider, 0, len(thirdProviders))
+	for _, provider := range thirdProviders {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Id() < providers[j].Id()
+	})
+	return providers
+}
+
+// ThirdProviderConfig 第三方的配置
+func ThirdProviderConfig(provider ThirdProvider) ThirdProviderConf {
+	return GConf.Third.Providers[provider.Name()]
+}
+
+// ThirdProviderGameConfig 项目使用的第三方配置, GameApps中配置了项目的应用时使用其AppId、AppSecret
+func ThirdProviderGameConfig(provider ThirdProvider, gameId, platformId int) ThirdProviderConf {
+	conf := ThirdProviderConfig(provider)
+	if app, ok := ThirdProviderGameApp(provider, gameId, platformId); ok {
+		conf.AppId = app.AppId
+		conf.AppSecret = app.AppSecret
+	}
+	return conf
+}
+
+// ThirdProviderGameApp 项目在GameApps中配置的应用, 不使用默认应用, 用于校验回调等需要与项目绑定的签名
+func ThirdProviderGameApp(provider ThirdProvider, gameId, platformId int) (ThirdAppConf, bool) {
+	conf := ThirdProviderConfig(provider)
+	for _, key := range []string{fmt.Sprintf("%d-%d", gameId, platformId), strconv.Itoa(gameId)} {
+		if app, ok := conf.GameApps[key]; ok && app.AppSecret != "" {
+			return app, true
+		}
+	}
+	return ThirdAppConf{}, false
+}
+
+// ThirdProviderEnabled 项目及大区是否可以使用此第三方, 未配置Games时所有项目可用
+// Games 格式: 16 代表项目16的所有大区, 16-1 代表项目16的大区1
+func ThirdProviderEnabled(provider ThirdProvider, gameId, platformId int) bool {
+	games := ThirdProviderConfig(provider).Games
+	if len(games) == 0 {
+		return true
+	}
+	return stringInList(strconv.Itoa(gameId), games) || stringInList(fmt.Sprintf("%d-%d", gameId, platformId), games)
+}
+
+// ThirdVerifyEnabled 是否开启第三方凭证校验, 未配置Verify时开启, 只有明确配置为false才关闭
+func ThirdVerifyEnabled() bool {
+	return GConf.Third.Verify == nil || *GConf.Third.Verify
+}
+
+// VerifyThirdAccount 检查项目是否可以使用此第三方, 并校验第三方凭证, 返回由服务端得到的第三方账号, 如 1002_第三方uid
+// 未开启校验时原样返回; 通过授权码换取uid的第三方(微信、QQ)由resolve选择unionid或openid账号, 为空时优先使用unionid
+func VerifyThirdAccount(account, thirdAccountJson string, gameId, platformId int, resolve ThirdAccountResolver) (string, *MyError) {
+	thirdId, _ := strconv.Atoi(strings.Split(account, "_")[0])
+	provider, ok := GetThirdProvider(thirdId)
+	if !ok {
+		return "", &MyError{Code: ThirdIdUnsupported, Log: "account: " + account}
+	}
+	if !ThirdProviderEnabled(provider, gameId, platformId) {
+		return "", &MyError{Code: ThirdProviderDisabled, Log: fmt.Sprintf("third %s not enabled for game %d-%d", provider.Name(), gameId, platformId)}
+	}
+	if !ThirdVerifyEnabled() {
+		return account, nil
+	}
+
+	thirdAccount := &ThirdAccount{}
+	if thirdAccountJson == "" || json.Unmarshal([]byte(thirdAccountJson), thirdAccount) != nil {
+		return "", &MyError{Code: ThirdVerifyParamsError, Log: "third account: " + thirdAccountJson}
+	}
+	//账号中的第三方id与凭证的一致
+	if thirdAccount.ThirdId != thirdId {
+		return "", &MyError{Code: ThirdVerifyIdNotMatch, Log: fmt.Sprintf("account %s, third id %d", account, thirdAccount.ThirdId)}
+	}
+
+	verified, myErr := verifyThirdCredential(provider, thirdAccount, ThirdProviderGameConfig(provider, gameId, platformId), resolve)
+	if myErr != nil {
+		return "", myErr
+	}
+
+	if verified != account {
+		SecurityLog.Warn().
+			Str("event", "third_account_mismatch").
+			Str("account", account).
+			Str("verified", verified).
+			Msg("third account differs from the verified credential")
+	}
+	return verified, nil
+}
+
+// 校验凭证, 返回第三方账号
+func verifyThirdCredential(provider ThirdProvider, thirdAccount *ThirdAccount, conf ThirdProviderConf, resolve ThirdAccountResolver) (string, *MyError) {
+	if verifier, ok := provider.(thirdTokenVerifier); ok && resolve != nil {
+		tokenInfo, myErr := verifier.VerifyToken(thirdAccount, conf)
+		if myErr != nil {
+			return "", myErr
+		}
+		if ThirdTokenUid(tokenInfo) == "" {
+			return "", &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("third id %d, uid empty", thirdAccount.ThirdId)}
+		}
+		return resolve(thirdAccount.ThirdId, tokenInfo), nil
+	}
+
+	thirdUid, myErr := provider.VerifyCredential(thirdAccount, conf)
+	if myErr != nil {
+		return "", myErr
+	}
+	if thirdUid == "" {
+		return "", &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("third id %d, uid empty", thirdAccount.ThirdId)}
+	}
+	return fmt.Sprintf("%d_%s", thirdAccount.ThirdId, thirdUid), nil
+}
+
+// ThirdTokenUid 授权码换取结果中的第三方uid, 优先使用unionid, 同一开放平台下的应用共用一个账号
+func ThirdTokenUid(tokenInfo *ThirdTokenInfo) string {
+	if tokenInfo.UnionId != "" {
+		return tokenInfo.UnionId
+	}
+	return tokenInfo.OpenId
+}
+
+// 校验id token(JWKS公钥), 返回sub, 供Google、Apple等使用
+func verifyThirdIdToken(idToken string, conf ThirdProviderConf) (string, *MyError) {
+	if idToken == "" {
+		return "", &MyError{Code: ThirdVerifyParamsError, Log: "id token empty"}
+	}
+	claims, myErr := parseThirdJwt(idToken, conf)
+	if myErr != nil {
+		return "", myErr
+	}
+	sub, _ := claims["sub"].(string)
+	return sub, nil
+}
+
+// 校验第三方签发的jwt(JWKS公钥)及iss、aud, 返回claims
+func parseThirdJwt(tokenString string, conf ThirdProviderConf) (jwt.MapClaims, *MyError) {
+	var keyErr *MyError
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, myErr := getThirdJwksKey(conf.JwksUrl, kid)
+		if myErr != nil {
+			keyErr = myErr
+			return nil, errors.New(myErr.Log)
+		}
+		//公钥类型与alg一致, 防止算法混淆
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("kid %s is RSA, token alg %s", kid, token.Method.Alg())
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+				return nil, fmt.Errorf("kid %s is EC, token alg %s", kid, token.Method.Alg())
+			}
+		}
+		return key, nil
+	})
+	if keyErr != nil && keyErr.Code == ThirdVerifyJwksError {
+		return nil, keyErr
+	}
+	if err != nil || !token.Valid {
+		return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("parse third jwt error: %v", err)}
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	iss, _ := claims["iss"].(string)
+	if !stringInList(iss, conf.Issuers) {
+		return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: "third jwt iss: " + iss}
+	}
+	//aud可能是字符串或数组
+	audOk := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOk = stringInList(aud, conf.ClientIds)
+	case []interface{}:
+		for _, v := range aud {
+			if s, ok := v.(string); ok && stringInList(s, conf.ClientIds) {
+				audOk = true
+				break
+			}
+		}
+	}
+	if !audOk {
+		return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("third jwt aud: %v", claims["aud"])}
+	}
+	return claims, nil
+}
+
+// 第三方通知(苹果通知、Google安全事件)按jti只处理一次
+func thirdEventOnce(key, value string, duplicateCode int) *MyError {
+	ok, err := RedisClient.SetNX(key, value, AppleNotificationExpires*time.Second).Result()
+	if err != nil {
+		return &MyError{Code: AppleNotifySaveError, Log: fmt.Sprintf("save third event %s error: %s", key, err.Error())}
+	}
+	if !ok {
+		return &MyError{Code: duplicateCode, Log: "third event already processed: " + key}
+	}
+	return nil
+}
+
+// ReleaseThirdEvent 处理失败时删除已处理标记, 第三方重试时可再次处理, 不会被当作重复事件丢弃
+func ReleaseThirdEvent(key string) {
+	if key == "" {
+		return
+	}
+	RedisClient.Del(key)
+}
+
+// BuildDeletionCode 数据删除确认码, 内容为 项目-大区-项目uid, 使用ServerKey签名, 查询进度时不需要另外存储
+func BuildDeletionCode(uid int64, gameId, platformId int) string {
+	content := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d-%d-%d", gameId, platformId, uid)))
+	return content + "." + deletionCodeSign(content)
+}
+
+// ParseDeletionCode 校验确认码, 返回项目uid、项目、大区
+func ParseDeletionCode(code string) (int64, int, int, *MyError) {
+	parts := strings.Split(code, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(deletionCodeSign(parts[0]))) {
+		return 0, 0, 0, &MyError{Code: ThirdDeletionCodeInvalid, Log: "confirmation code: " + code}
+	}
+	content, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, 0, 0, &MyError{Code: ThirdDeletionCodeInvalid, Log: "confirmation code decode error: " + err.Error()}
+	}
+	var (
+		uid                int64
+		gameId, platformId int
+	)
+	_, err = fmt.Sscanf(string(content), "%d-%d-%d", &gameId, &platformId, &uid)
+	if err != nil {
+		return 0, 0, 0, &MyError{Code: ThirdDeletionCodeInvalid, Log: "confirmation code content: " + string(content)}
+	}
+	return uid, gameId, platformId, nil
+}
+
+func deletionCodeSign(content string) string {
+	mac := hmac.New(sha256.New, []byte(GConf.Base.ServerKey))
+	mac.Write([]byte("deletion:" + content))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// 第三方token加密使用的AEAD
+func thirdTokenCipher() (cipher.AEAD, *MyError) {
+	if len(GConf.Third.TokenEncryptKey) != 32 {
+		return nil, &MyError{Code: ThirdTokenEncryptKeyError, Log: fmt.Sprintf("third token encrypt key length: %d", len(GConf.Third.TokenEncryptKey))}
+	}
+	block, err := aes.NewCipher([]byte(GConf.Third.TokenEncryptKey))
+	if err != nil {
+		return nil, &MyError{Code: ThirdTokenEncryptKeyError, Log: "third token new cipher error: " + err.Error()}
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, &MyError{Code: ThirdTokenEncryptKeyError, Log: "third token new gcm error: " + err.Error()}
+	}
+	return aead, nil
+}
+
+// EncryptThirdToken 加密第三方token, 结果为 base64(nonce + 密文), 空token不加密
+func EncryptThirdToken(token string) (string, *MyError) {
+	if token == "" {
+		return "", nil
+	}
+	aead, myErr := thirdTokenCipher()
+	if myErr != nil {
+		return "", myErr
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", &MyError{Code: ThirdTokenSaveError, Log: "read third token nonce error: " + err.Error()}
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+// DecryptThirdToken 解密第三方token
+func DecryptThirdToken(encrypted string) (string, *MyError) {
+	if encrypted == "" {
+		return "", nil
+	}
+	aead, myErr := thirdTokenCipher()
+	if myErr != nil {
+		return "", myErr
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", &MyError{Code: ThirdTokenDecryptError, Log: "third token decode error"}
+	}
+	token, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", &MyError{Code: ThirdTokenDecryptError, Log: "third token decrypt error: " + err.Error()}
+	}
+	return string(token), nil
+}
+
+// 获取第三方公钥, 缓存过期或没有kid时重新拉取, 拉取失败时继续使用旧的公钥
+func getThirdJwksKey(jwksUrl, kid string) (crypto.PublicKey, *MyError) {
+	thirdJwksLock.Lock()
+	defer thirdJwksLock.Unlock()
+
+	now := time.Now().Unix()
+	cached := thirdJwksCache[jwksUrl]
+	if cached != nil {
+		key, ok := cached.keys[kid]
+		if ok && now < cached.expiresAt {
+			return key, nil
+		}
+		if !ok && now < cached.expiresAt && now-cached.fetchedAt < thirdJwksMinRefresh {
+			return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("kid %s not found in %s", kid, jwksUrl)}
+		}
+	}
+
+	fetched, myErr := fetchThirdJwks(jwksUrl)
+	if myErr != nil {
+		if cached != nil {
+			if key, ok := cached.keys[kid]; ok {
+				return key, nil
+			}
+		}
+		return nil, myErr
+	}
+	thirdJwksCache[jwksUrl] = fetched
+	key, ok := fetched.keys[kid]
+	if !ok {
+		return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("kid %s not found in %s", kid, jwksUrl)}
+	}
+	return key, nil
+}
+
+// 拉取JWKS, 缓存时间优先使用Cache-Control中的max-age
+func fetchThirdJwks(jwksUrl string) (*thirdJwks, *MyError) {
+	client := &http.Client{Timeout: time.Duration(GConf.Third.Timeout) * time.Second}
+	resp, err := client.Get(jwksUrl)
+	if err != nil {
+		return nil, &MyError{Code: ThirdVerifyJwksError, Log: fmt.Sprintf("get jwks %s error: %s", jwksUrl, err.Error())}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, &MyError{Code: ThirdVerifyJwksError, Log: fmt.Sprintf("get jwks %s status: %d, error: %v", jwksUrl, resp.StatusCode, err)}
+	}
+	jwks := &JwksFields{}
+	err = json.Unmarshal(body, jwks)
+	if err != nil {
+		return nil, &MyError{Code: ThirdVerifyJwksError, Log: fmt.Sprintf("parse jwks %s error: %s", jwksUrl, err.Error())}
+	}
+
+	now := time.Now().Unix()
+	fetched := &thirdJwks{keys: map[string]crypto.PublicKey{}, fetchedAt: now, expiresAt: now + GConf.Third.JwksExpires}
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if maxAge, parseErr := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64); parseErr == nil && maxAge > 0 {
+				fetched.expiresAt = now + maxAge
+			}
+		}
+	}
+	for _, jwk := range jwks.Keys {
+		key, parseErr := parseJwk(jwk)
+		if parseErr != nil {
+			continue
+		}
+		fetched.keys[jwk.Kid] = key
+	}
+	if len(fetched.keys) == 0 {
+		return nil, &MyError{Code: ThirdVerifyJwksError, Log: fmt.Sprintf("jwks %s has no usable key", jwksUrl)}
+	}
+	return fetched, nil
+}
+
+// jwk转换为公钥, 支持RSA及EC P-256
+func parseJwk(jwk JwkFields) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("curve %s not supported", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("kty %s not supported", jwk.Kty)
+}
+
+// 请求第三方校验接口
+func thirdHttpGet(getUrl string) ([]byte, *MyError) {
+	client := &http.Client{Timeout: time.Duration(GConf.Third.Timeout) * time.Second}
+	resp, err := client.Get(getUrl)
+	if err != nil {
+		//地址中带有应用密钥, 不记录完整地址
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, &MyError{Code: ThirdVerifyRequestError, Log: "third verify request error: " + err.Error()}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &MyError{Code: ThirdVerifyRequestError, Log: "third verify read body error: " + err.Error()}
+	}
+	return body, nil
+}
+
+func stringInList(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
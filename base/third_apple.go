@@ -0,0 +1,162 @@
+/**
+ * @project Accounts
+ * @filename third_apple.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/25 11:00
+ * @version 1.0
+ * @description
+ * Apple, 校验 identity token; 登录、注册、绑定及注销申请时用 authorization code 换取刷新token, 注销完成后撤销授权
+ * 保存的刷新token定时校验, 失效代表用户已在苹果设置中停止使用此App
+ * client_id、client_secret 来自 game_config 表, 见 RefreshGameConfig
+ * 服务端通知(Sign in with Apple server-to-server notification)的校验, 公钥与identity token相同
+ */
+
+package base
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+type appleProvider struct {
+	thirdProviderBase
+}
+
+func init() {
+	RegisterThirdProvider(appleProvider{thirdProviderBase{id: ThirdApple, name: "Apple"}})
+}
+
+// VerifyCredential 校验 identity token, 返回sub
+func (p appleProvider) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	return verifyThirdIdToken(third.IdToken, conf)
+}
+
+// ExchangeToken authorization code 换取刷新token, 没有code或项目未配置苹果信息时不处理
+func (p appleProvider) ExchangeToken(third *ThirdAccount, conf ThirdProviderConf, gameConfig *GameConfig) (string, *MyError) {
+	if third.AuthorizationCode == "" || gameConfig.AppleClientId == "" || gameConfig.AppleClientSecret == "" {
+		return "", nil
+	}
+	code, err := base64.StdEncoding.DecodeString(third.AuthorizationCode)
+	if err != nil {
+		return "", &MyError{Code: ThirdVerifyParamsError, Log: fmt.Sprintf("authorization code %s base64 decode error: %s", third.AuthorizationCode, err.Error())}
+	}
+	tokenUrl := conf.TokenUrl
+	if tokenUrl == "" {
+		tokenUrl = AppleValidateCodeUrl
+	}
+	result, myErr := HttpPostForm(tokenUrl, map[string]string{
+		"client_id":     gameConfig.AppleClientId,
+		"client_secret": gameConfig.AppleClientSecret,
+		"code":          string(code),
+		"grant_type":    "authorization_code",
+	})
+	if myErr != nil {
+		return "", myErr
+	}
+	var tokenInfo struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = json.Unmarshal(result, &tokenInfo)
+	if err != nil || tokenInfo.RefreshToken == "" {
+		return "", &MyError{Code: ThirdVerifyTokenInvalid, Log: "apple validate code return: " + string(result)}
+	}
+	return tokenInfo.RefreshToken, nil
+}
+
+// RevokeToken 撤销授权, 返回空代表成功
+func (p appleProvider) RevokeToken(refreshToken string, conf ThirdProviderConf, gameConfig *GameConfig) *MyError {
+	if gameConfig.AppleClientId == "" || gameConfig.AppleClientSecret == "" {
+		return &MyError{Code: ThirdVerifyParamsError, Log: fmt.Sprintf("game %d-%d apple client id or secret empty", gameConfig.GameId, gameConfig.PlatformId)}
+	}
+	revokeUrl := conf.RevokeUrl
+	if revokeUrl == "" {
+		revokeUrl = AppleRevokeUrl
+	}
+	result, myErr := HttpPostForm(revokeUrl, map[string]string{
+		"client_id":       gameConfig.AppleClientId,
+		"client_secret":   gameConfig.AppleClientSecret,
+		"token_type_hint": "refresh_token",
+		"token":           refreshToken,
+	})
+	if myErr != nil {
+		return myErr
+	}
+	if string(result) != "" {
+		return &MyError{Code: ThirdVerifyRequestError, Log: "apple revoke return: " + string(result)}
+	}
+	return nil
+}
+
+// CheckAppleRefreshToken 校验刷新token是否有效, 返回 AppleConsentRevoked 代表用户已撤销授权
+func CheckAppleRefreshToken(refreshToken string, conf ThirdProviderConf, gameConfig *GameConfig) *MyError {
+	if gameConfig.AppleClientId == "" || gameConfig.AppleClientSecret == "" {
+		return &MyError{Code: ThirdVerifyParamsError, Log: fmt.Sprintf("game %d-%d apple client id or secret empty", gameConfig.GameId, gameConfig.PlatformId)}
+	}
+	tokenUrl := conf.TokenUrl
+	if tokenUrl == "" {
+		tokenUrl = AppleValidateCodeUrl
+	}
+	result, myErr := HttpPostForm(tokenUrl, map[string]string{
+		"client_id":     gameConfig.AppleClientId,
+		"client_secret": gameConfig.AppleClientSecret,
+		"refresh_token": refreshToken,
+		"grant_type":    "refresh_token",
+	})
+	if myErr != nil {
+		return myErr
+	}
+	var tokenInfo struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	err := json.Unmarshal(result, &tokenInfo)
+	if err != nil {
+		return &MyError{Code: ThirdVerifyRequestError, Log: "apple refresh token return: " + string(result)}
+	}
+	//invalid_grant: 刷新token已被撤销
+	if tokenInfo.Error == "invalid_grant" {
+		return &MyError{Code: AppleConsentRevoked, Log: fmt.Sprintf("apple client %s refresh token revoked", gameConfig.AppleClientId)}
+	}
+	if tokenInfo.AccessToken == "" {
+		return &MyError{Code: ThirdVerifyRequestError, Log: "apple refresh token return: " + string(result)}
+	}
+	return nil
+}
+
+// ParseAppleNotification 校验苹果服务端通知的签名及iss、aud, 返回事件; 同一通知(jti)只处理一次, 处理失败时调用ReleaseThirdEvent
+func ParseAppleNotification(payload string) (*AppleNotificationEvent, *MyError) {
+	claims, myErr := parseThirdJwt(payload, GConf.Third.Providers["Apple"])
+	if myErr != nil {
+		return nil, myErr
+	}
+
+	//events 为json字符串
+	event := &AppleNotificationEvent{}
+	var err error
+	switch events := claims["events"].(type) {
+	case string:
+		err = json.Unmarshal([]byte(events), event)
+	case map[string]interface{}:
+		content, _ := json.Marshal(events)
+		err = json.Unmarshal(content, event)
+	default:
+		err = fmt.Errorf("events type %T", events)
+	}
+	if err != nil || event.Type == "" || event.Sub == "" {
+		return nil, &MyError{Code: AppleNotifyPayloadError, Log: fmt.Sprintf("apple notification events: %v, error: %v", claims["events"], err)}
+	}
+	event.ClientId, _ = claims["aud"].(string)
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, &MyError{Code: AppleNotifyPayloadError, Log: "apple notification jti empty"}
+	}
+	event.EventKey = fmt.Sprintf(AppleNotificationFormat, jti)
+	myErr = thirdEventOnce(event.EventKey, event.Type, AppleNotifyDuplicate)
+	if myErr != nil {
+		return nil, myErr
+	}
+	return event, nil
+}
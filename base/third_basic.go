@@ -0,0 +1,32 @@
+/**
+ * @project Accounts
+ * @filename third_basic.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/25 11:30
+ * @version 1.0
+ * @description
+ * 暂未对接服务端接口的第三方, 只有编码和名称, 开启服务端校验后不能使用
+ * 对接后从此处移除, 新建 third_名称.go
+ */
+
+package base
+
+func init() {
+	basics := map[int]string{
+		ThirdTwitter:   "Twitter",
+		ThirdYoutube:   "Youtube",
+		ThirdInstagram: "Instagram",
+		ThirdWhatsapp:  "Whatsapp",
+		ThirdSkype:     "Skype",
+		ThirdLinkedln:  "Linkedln",
+		ThirdLine:      "Line",
+		ThirdVK:        "VK",
+		ThirdReddit:    "Reddit",
+		ThirdWeibo:     "Weibo",
+		ThirdTiktok:    "Tiktok",
+	}
+	for id, name := range basics {
+		RegisterThirdProvider(thirdProviderBase{id: id, name: name})
+	}
+}
@@ -0,0 +1,97 @@
+/**
+ * @project Accounts
+ * @filename third_facebook.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/25 10:40
+ * @version 1.0
+ * @description
+ * Facebook, 调用 debug_token 校验客户端的 access token; 校验数据删除回调的 signed_request
+ */
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type facebookProvider struct {
+	thirdProviderBase
+}
+
+func init() {
+	RegisterThirdProvider(facebookProvider{thirdProviderBase{id: ThirdFacebook, name: "Facebook"}})
+}
+
+// VerifyCredential debug_token 校验 access token, 返回user_id
+func (p facebookProvider) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	if third.AccessToken == "" {
+		return "", &MyError{Code: ThirdVerifyParamsError, Log: "access token empty"}
+	}
+	params := url.Values{}
+	params.Set("input_token", third.AccessToken)
+	params.Set("access_token", conf.AppId+"|"+conf.AppSecret)
+	body, myErr := thirdHttpGet(conf.TokenUrl + "?" + params.Encode())
+	if myErr != nil {
+		return "", myErr
+	}
+
+	var result struct {
+		Data struct {
+			AppId   string `json:"app_id"`
+			IsValid bool   `json:"is_valid"`
+			UserId  string `json:"user_id"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(body, &result)
+	if err != nil {
+		return "", &MyError{Code: ThirdVerifyRequestError, Log: "debug token parse error: " + err.Error()}
+	}
+	if !result.Data.IsValid || result.Data.AppId != conf.AppId {
+		return "", &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("debug token is_valid: %t, app_id: %s", result.Data.IsValid, result.Data.AppId)}
+	}
+	return result.Data.UserId, nil
+}
+
+// ParseFacebookSignedRequest 校验数据删除回调的signed_request, 格式: base64url(签名).base64url(内容)
+// 签名为使用应用密钥对内容部分做的HMAC-SHA256, issued_at 超过有效期的不处理
+func ParseFacebookSignedRequest(signedRequest, appSecret string) (*FacebookSignedRequest, *MyError) {
+	parts := strings.Split(signedRequest, ".")
+	if len(parts) != 2 || appSecret == "" {
+		return nil, &MyError{Code: ThirdDeletionParamsError, Log: "signed request format error or app secret empty"}
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return nil, &MyError{Code: ThirdDeletionSignError, Log: "signed request sign decode error: " + err.Error()}
+	}
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(parts[1]))
+	if !hmac.Equal(sign, mac.Sum(nil)) {
+		return nil, &MyError{Code: ThirdDeletionSignError, Log: "signed request sign not match"}
+	}
+
+	content, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, &MyError{Code: ThirdDeletionParamsError, Log: "signed request payload decode error: " + err.Error()}
+	}
+	request := &FacebookSignedRequest{}
+	err = json.Unmarshal(content, request)
+	if err != nil || request.UserId == "" {
+		return nil, &MyError{Code: ThirdDeletionParamsError, Log: fmt.Sprintf("signed request payload: %s, error: %v", content, err)}
+	}
+	if strings.ToUpper(request.Algorithm) != "HMAC-SHA256" {
+		return nil, &MyError{Code: ThirdDeletionSignError, Log: "signed request algorithm: " + request.Algorithm}
+	}
+	//签发时间过久或在未来的不处理, 截获的signed_request不能一直使用
+	now := GetTime()
+	if request.IssuedAt < now-FacebookSignedRequestExpires || request.IssuedAt > now+FacebookSignedRequestExpires {
+		return nil, &MyError{Code: ThirdDeletionRequestExpired, Log: fmt.Sprintf("signed request issued_at: %d, now: %d", request.IssuedAt, now)}
+	}
+	return request, nil
+}
@@ -0,0 +1,72 @@
+/**
+ * @project Accounts
+ * @filename third_google.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/25 10:30
+ * @version 1.0
+ * @description
+ * Google, 校验客户端的 id token; 接收跨账号保护(RISC)安全事件
+ * 安全事件的签名公钥与id token相同, iss 为 https://accounts.google.com/ (带/), 需加入配置的Issuers
+ */
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type googleProvider struct {
+	thirdProviderBase
+}
+
+func init() {
+	RegisterThirdProvider(googleProvider{thirdProviderBase{id: ThirdGoogle, name: "Google"}})
+}
+
+// VerifyCredential 校验 id token, 返回sub
+func (p googleProvider) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	return verifyThirdIdToken(third.IdToken, conf)
+}
+
+// ParseGoogleRiscEvent 校验Google安全事件(Security Event Token)的签名及iss、aud, 返回事件; 同一事件(jti)只处理一次, 处理失败时调用ReleaseThirdEvent
+func ParseGoogleRiscEvent(token string) (*GoogleRiscEvent, *MyError) {
+	claims, myErr := parseThirdJwt(token, GConf.Third.Providers["Google"])
+	if myErr != nil {
+		return nil, myErr
+	}
+
+	//events: {"事件类型": {"subject": {"subject_type": "iss-sub", "iss": "...", "sub": "..."}, "reason": "..."}}
+	var events map[string]struct {
+		Subject struct {
+			Sub string `json:"sub"`
+		} `json:"subject"`
+		Reason string `json:"reason"`
+	}
+	content, _ := json.Marshal(claims["events"])
+	err := json.Unmarshal(content, &events)
+	if err != nil || len(events) != 1 {
+		return nil, &MyError{Code: ThirdEventUnknown, Log: fmt.Sprintf("google risc events: %s, error: %v", content, err)}
+	}
+	event := &GoogleRiscEvent{}
+	for eventType, detail := range events {
+		event.Type = eventType
+		event.Sub = detail.Subject.Sub
+		event.Reason = detail.Reason
+	}
+	if event.Sub == "" && event.Type != GoogleRiscVerification {
+		return nil, &MyError{Code: ThirdEventUnknown, Log: fmt.Sprintf("google risc event %s subject empty", event.Type)}
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, &MyError{Code: ThirdEventUnknown, Log: "google risc event jti empty"}
+	}
+	event.EventKey = fmt.Sprintf(GoogleRiscEventFormat, jti)
+	myErr = thirdEventOnce(event.EventKey, event.Type, ThirdEventDuplicate)
+	if myErr != nil {
+		return nil, myErr
+	}
+	return event, nil
+}
@@ -0,0 +1,118 @@
+/**
+ * @project Accounts
+ * @filename third_qq.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/26 10:40
+ * @version 1.0
+ * @description
+ * QQ, 移动应用SDK返回的code换取access token, 再获取openid、unionid
+ * 同一开放平台下的多个应用unionid相同, 优先使用unionid作为第三方uid
+ */
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type qqProvider struct {
+	thirdProviderBase
+}
+
+func init() {
+	RegisterThirdProvider(qqProvider{thirdProviderBase{id: ThirdQQ, name: "QQ"}})
+}
+
+// QQ接口返回, 失败时error不为0
+type qqResult struct {
+	Error            int         `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+	AccessToken      string      `json:"access_token"`
+	ExpiresIn        json.Number `json:"expires_in"` //返回为字符串
+	RefreshToken     string      `json:"refresh_token"`
+	ClientId         string      `json:"client_id"`
+	OpenId           string      `json:"openid"`
+	UnionId          string      `json:"unionid"`
+}
+
+// VerifyCredential authorization_code 传QQ code, 返回unionid, 未绑定开放平台时返回openid
+func (p qqProvider) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	tokenInfo, myErr := p.VerifyToken(third, conf)
+	if myErr != nil {
+		return "", myErr
+	}
+	return ThirdTokenUid(tokenInfo), nil
+}
+
+// VerifyToken authorization_code 传QQ code, 返回换取结果, 由调用方选择unionid或openid账号
+func (p qqProvider) VerifyToken(third *ThirdAccount, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError) {
+	return p.ExchangeCode(third.AuthorizationCode, conf)
+}
+
+// ExchangeCode code换取access token, 再通过 oauth2.0/me 获取openid、unionid
+func (p qqProvider) ExchangeCode(code string, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError) {
+	if code == "" {
+		return nil, &MyError{Code: ThirdVerifyParamsError, Log: "qq code empty"}
+	}
+	baseUrl := conf.BaseUrl
+	if baseUrl == "" {
+		baseUrl = QQApiBaseUrl
+	}
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("client_id", conf.AppId)
+	params.Set("client_secret", conf.AppSecret)
+	params.Set("code", code)
+	params.Set("redirect_uri", conf.RedirectUri)
+	params.Set("fmt", "json")
+	result, myErr := qqGet(baseUrl + "/oauth2.0/token?" + params.Encode())
+	if myErr != nil {
+		return nil, myErr
+	}
+	if result.AccessToken == "" {
+		return nil, &MyError{Code: ThirdCodeExchangeError, Log: "qq access token empty"}
+	}
+	expiresIn, _ := result.ExpiresIn.Int64()
+	tokenInfo := &ThirdTokenInfo{
+		AppId:        conf.AppId,
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		ExpiresIn:    expiresIn,
+	}
+
+	params = url.Values{}
+	params.Set("access_token", result.AccessToken)
+	params.Set("unionid", "1")
+	params.Set("fmt", "json")
+	me, myErr := qqGet(baseUrl + "/oauth2.0/me?" + params.Encode())
+	if myErr != nil {
+		return nil, myErr
+	}
+	//token须是本应用的
+	if me.ClientId != conf.AppId || me.OpenId == "" {
+		return nil, &MyError{Code: ThirdVerifyTokenInvalid, Log: fmt.Sprintf("qq me client_id: %s, openid: %s", me.ClientId, me.OpenId)}
+	}
+	tokenInfo.OpenId = me.OpenId
+	tokenInfo.UnionId = me.UnionId
+	return tokenInfo, nil
+}
+
+// 请求QQ接口
+func qqGet(getUrl string) (*qqResult, *MyError) {
+	body, myErr := thirdHttpGet(getUrl)
+	if myErr != nil {
+		return nil, myErr
+	}
+	result := &qqResult{}
+	err := json.Unmarshal(body, result)
+	if err != nil {
+		return nil, &MyError{Code: ThirdVerifyRequestError, Log: "qq response parse error: " + err.Error()}
+	}
+	if result.Error != 0 {
+		return nil, &MyError{Code: ThirdCodeExchangeError, Log: fmt.Sprintf("qq error: %d, description: %s", result.Error, result.ErrorDescription)}
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+/**
+ * @project Accounts
+ * @filename third_weixin.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/26 10:00
+ * @version 1.0
+ * @description
+ * 微信, 移动应用SDK返回的code换取access token、openid、unionid
+ * 同一开放平台下的多个应用unionid相同, 优先使用unionid作为第三方uid
+ */
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type weixinProvider struct {
+	thirdProviderBase
+}
+
+func init() {
+	RegisterThirdProvider(weixinProvider{thirdProviderBase{id: ThirdWeixin, name: "Weixin"}})
+}
+
+// 微信接口返回, 失败时errcode不为0
+type weixinResult struct {
+	ErrCode      int    `json:"errcode"`
+	ErrMsg       string `json:"errmsg"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	OpenId       string `json:"openid"`
+	UnionId      string `json:"unionid"`
+}
+
+// VerifyCredential authorization_code 传微信code, 返回unionid, 未绑定开放平台时返回openid
+func (p weixinProvider) VerifyCredential(third *ThirdAccount, conf ThirdProviderConf) (string, *MyError) {
+	tokenInfo, myErr := p.VerifyToken(third, conf)
+	if myErr != nil {
+		return "", myErr
+	}
+	return ThirdTokenUid(tokenInfo), nil
+}
+
+// VerifyToken authorization_code 传微信 code, 返回换取结果, 由调用方选择unionid或openid账号
+func (p weixinProvider) VerifyToken(third *ThirdAccount, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError) {
+	return p.ExchangeCode(third.AuthorizationCode, conf)
+}
+
+// ExchangeCode code换取access token, 返回中没有unionid时再从用户信息中获取
+func (p weixinProvider) ExchangeCode(code string, conf ThirdProviderConf) (*ThirdTokenInfo, *MyError) {
+	if code == "" {
+		return nil, &MyError{Code: ThirdVerifyParamsError, Log: "weixin code empty"}
+	}
+	baseUrl := conf.BaseUrl
+	if baseUrl == "" {
+		baseUrl = WeixinApiBaseUrl
+	}
+	params := url.Values{}
+	params.Set("appid", conf.AppId)
+	params.Set("secret", conf.AppSecret)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	result, myErr := weixinGet(baseUrl + "/sns/oauth2/access_token?" + params.Encode())
+	if myErr != nil {
+		return nil, myErr
+	}
+	if result.AccessToken == "" || result.OpenId == "" {
+		return nil, &MyError{Code: ThirdCodeExchangeError, Log: "weixin access token or openid empty"}
+	}
+	tokenInfo := &ThirdTokenInfo{
+		AppId:        conf.AppId,
+		OpenId:       result.OpenId,
+		UnionId:      result.UnionId,
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		ExpiresIn:    result.ExpiresIn,
+	}
+	if tokenInfo.UnionId != "" {
+		return tokenInfo, nil
+	}
+
+	params = url.Values{}
+	params.Set("access_token", result.AccessToken)
+	params.Set("openid", result.OpenId)
+	userInfo, myErr := weixinGet(baseUrl + "/sns/userinfo?" + params.Encode())
+	if myErr != nil {
+		return nil, myErr
+	}
+	tokenInfo.UnionId = userInfo.UnionId
+	return tokenInfo, nil
+}
+
+// 请求微信接口
+func weixinGet(getUrl string) (*weixinResult, *MyError) {
+	body, myErr := thirdHttpGet(getUrl)
+	if myErr != nil {
+		return nil, myErr
+	}
+	result := &weixinResult{}
+	err := json.Unmarshal(body, result)
+	if err != nil {
+		return nil, &MyError{Code: ThirdVerifyRequestError, Log: "weixin response parse error: " + err.Error()}
+	}
+	if result.ErrCode != 0 {
+		return nil, &MyError{Code: ThirdCodeExchangeError, Log: fmt.Sprintf("weixin errcode: %d, errmsg: %s", result.ErrCode, result.ErrMsg)}
+	}
+	return result, nil
+}
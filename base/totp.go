@@ -0,0 +1,222 @@
+/**
+ * @project Accounts
+ * @filename totp.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/19 11:00
+ * @version 1.0
+ * @description
+ * 二次验证, RFC 6238 TOTP(SHA1, 6位, 30秒), 兼容Google Authenticator等认证器App
+ * 密钥使用 AES-256-GCM 加密后存储在主账号表, 恢复码只存储sha256
+ * 登录需要二次验证时, 先返回challenge token, 验证码通过后再签发token
+ */
+
+package base
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod       = 30 //时间步长, 秒
+	totpDigits       = 6
+	totpSkew         = 1  //允许前后各1个时间步的误差
+	totpSecretLength = 20 //密钥长度, 160位
+	//恢复码字符, 去掉了容易混淆的 0 O 1 I L
+	recoveryCodeChar   = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+	recoveryCodeLength = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret 生成TOTP密钥, base32编码
+func GenerateTotpSecret() (string, *MyError) {
+	b := make([]byte, totpSecretLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", &MyError{Code: TotpSecretError, Log: "read totp secret error: " + err.Error()}
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TotpUri 生成认证器App使用的 otpauth uri
+func TotpUri(label, secret string) string {
+	issuer := GConf.Totp.Issuer
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+label) + "?" + params.Encode()
+}
+
+// 计算某个时间步的验证码
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// VerifyTotp 校验验证码, 通过时返回匹配的时间步, 用于防止重复使用
+func VerifyTotp(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, current+i)), []byte(code)) == 1 {
+			return current + i, true
+		}
+	}
+	return 0, false
+}
+
+// UseTotpStep 标记时间步已使用, 同一时间步的验证码只能使用一次
+func UseTotpStep(mainUid, step int64) bool {
+	ok, err := RedisClient.SetNX(fmt.Sprintf(TotpUsedFormat, mainUid, step), 1, time.Duration(totpPeriod*(2*totpSkew+1))*time.Second).Result()
+	return err == nil && ok
+}
+
+// 加密key, 必须是32位
+func totpCipher() (cipher.AEAD, *MyError) {
+	if len(GConf.Totp.EncryptKey) != 32 {
+		return nil, &MyError{Code: TotpEncryptKeyError, Log: fmt.Sprintf("totp encrypt key length: %d", len(GConf.Totp.EncryptKey))}
+	}
+	block, err := aes.NewCipher([]byte(GConf.Totp.EncryptKey))
+	if err != nil {
+		return nil, &MyError{Code: TotpEncryptKeyError, Log: "totp new cipher error: " + err.Error()}
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, &MyError{Code: TotpEncryptKeyError, Log: "totp new gcm error: " + err.Error()}
+	}
+	return aead, nil
+}
+
+// EncryptTotpSecret 加密TOTP密钥, 结果为 base64(nonce + 密文)
+func EncryptTotpSecret(secret string) (string, *MyError) {
+	aead, myErr := totpCipher()
+	if myErr != nil {
+		return "", myErr
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", &MyError{Code: TotpSecretError, Log: "read totp nonce error: " + err.Error()}
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// DecryptTotpSecret 解密TOTP密钥
+func DecryptTotpSecret(encrypted string) (string, *MyError) {
+	aead, myErr := totpCipher()
+	if myErr != nil {
+		return "", myErr
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", &MyError{Code: TotpSecretError, Log: "totp secret decode error"}
+	}
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", &MyError{Code: TotpSecretError, Log: "totp secret decrypt error: " + err.Error()}
+	}
+	return string(secret), nil
+}
+
+// GenerateRecoveryCodes 生成恢复码, 返回明文(展示给用户)及hash(存储)
+func GenerateRecoveryCodes() ([]string, []string, *MyError) {
+	codes := make([]string, 0, RecoveryCodeNumber)
+	hashes := make([]string, 0, RecoveryCodeNumber)
+	max := big.NewInt(int64(len(recoveryCodeChar)))
+	for i := 0; i < RecoveryCodeNumber; i++ {
+		b := make([]byte, recoveryCodeLength)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, nil, &MyError{Code: TotpSaveError, Log: "generate recovery code error: " + err.Error()}
+			}
+			b[j] = recoveryCodeChar[n.Int64()]
+		}
+		code := string(b[:recoveryCodeLength/2]) + "-" + string(b[recoveryCodeLength/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, RecoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// RecoveryCodeHash 恢复码hash, 忽略大小写及分隔符
+func RecoveryCodeHash(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveTotpChallenge 保存登录二次验证challenge
+func SaveTotpChallenge(info *TotpChallengeInfo) (*SecondFactorFields, *MyError) {
+	token := BuildTokenId()
+	content, _ := json.Marshal(info)
+	err := RedisClient.Set(fmt.Sprintf(TotpChallengeFormat, token), content, time.Duration(GConf.Totp.ChallengeExpires)*time.Second).Err()
+	if err != nil {
+		return nil, &MyError{Code: TotpChallengeSaveError, Log: fmt.Sprintf("save totp challenge, main uid: %d, error: %s", info.MainUid, err.Error())}
+	}
+	return &SecondFactorFields{ChallengeToken: token, ExpiresIn: GConf.Totp.ChallengeExpires}, nil
+}
+
+// GetTotpChallenge 获取登录二次验证challenge, 校验验证码前先原子增加尝试次数, 超过限制则失效
+// 并发提交时每个请求各占用一次, 不能绕过次数限制; 验证通过后调用DeleteTotpChallenge
+func GetTotpChallenge(token string) (*TotpChallengeInfo, *MyError) {
+	content, err := RedisClient.Get(fmt.Sprintf(TotpChallengeFormat, token)).Bytes()
+	if err != nil {
+		return nil, &MyError{Code: TotpChallengeInvalid, Log: fmt.Sprintf("get totp challenge %s error: %s", token, err.Error())}
+	}
+	key := fmt.Sprintf(TotpChallengeAttemptsFormat, token)
+	pipe := RedisClient.TxPipeline()
+	incrCmd := pipe.Incr(key)
+	pipe.Expire(key, time.Duration(GConf.Totp.ChallengeExpires)*time.Second)
+	_, err = pipe.Exec()
+	if err != nil {
+		return nil, &MyError{Code: TotpChallengeInvalid, Log: fmt.Sprintf("incr totp challenge %s attempts error: %s", token, err.Error())}
+	}
+	if incrCmd.Val() > TotpChallengeMaxAttempts {
+		DeleteTotpChallenge(token)
+		return nil, &MyError{Code: TotpChallengeInvalid, Log: fmt.Sprintf("totp challenge %s attempts: %d", token, incrCmd.Val())}
+	}
+	info := &TotpChallengeInfo{}
+	err = json.Unmarshal(content, info)
+	if err != nil {
+		return nil, &MyError{Code: TotpChallengeInvalid, Log: fmt.Sprintf("totp challenge %s parse error: %s", token, err.Error())}
+	}
+	return info, nil
+}
+
+// DeleteTotpChallenge 删除challenge, 验证通过后只能使用一次, 并发提交时只有删除成功的一方可以继续
+func DeleteTotpChallenge(token string) bool {
+	deleted, err := RedisClient.Del(fmt.Sprintf(TotpChallengeFormat, token)).Result()
+	RedisClient.Del(fmt.Sprintf(TotpChallengeAttemptsFormat, token))
+	return err == nil && deleted > 0
+}
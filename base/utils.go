@@ -13,7 +13,9 @@ package base
 
 import (
 	"crypto/md5"
+	cryptoRand "crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,8 +28,8 @@ import (
 	"hash/crc32"
 	"io"
 	"math"
+	"math/big"
 	"math/rand"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -40,6 +42,11 @@ import (
 
 // 失败输出
 func ResponseFail(w http.ResponseWriter, myError *MyError, logger zerolog.Logger) {
+	ResponseFailStatus(w, http.StatusOK, myError, logger)
+}
+
+// 失败输出, 指定http状态码, 如访问限制返回429
+func ResponseFailStatus(w http.ResponseWriter, status int, myError *MyError, logger zerolog.Logger) {
 	resp := &AccountResponse{}
 	resp.Code = myError.Code
 	if myError.Error() != "" {
@@ -60,6 +67,9 @@ func ResponseFail(w http.ResponseWriter, myError *MyError, logger zerolog.Logger
 	startTimeStr := w.Header().Get("StartTime")
 	w.Header().Del("StartTime")
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
 	_, err = w.Write(body)
 
 	if err != nil {
@@ -140,8 +150,9 @@ func RequestHandler(r *http.Request, data interface{}) *MyError {
 		return &MyError{Code: RequestDataValidatorFail, Log: fmt.Sprintf("validate.Struct error %s: %s", err.Error(), body)}
 	}
 
-	//ip 限制访问策略
-	limitErr := LimitIp(GetRealAddr(r).String(), r.URL.Path)
+	//ip 限制访问策略, 禁止名单由IpAccessHandler处理
+	ip := GetRealAddr(r).String()
+	limitErr := LimitIp(ip, r.URL.Path, signedGameId(data))
 	if limitErr != nil {
 		return limitErr
 	}
@@ -149,6 +160,24 @@ func RequestHandler(r *http.Request, data interface{}) *MyError {
 	return nil
 }
 
+// 签名正确时请求的项目, 用于选择挑战类型; 在接口校验签名之前调用, 签名不正确时返回0, 避免伪造game_id选择其他挑战类型
+func signedGameId(data interface{}) int {
+	common, ok := data.(interface{ GetCommonFields() CommonFields })
+	if !ok {
+		return 0
+	}
+	fields := common.GetCommonFields()
+	//各接口的app类型不同, 只比对签名
+	appInfo, err := getAppIdInfo(fields.AppId, AppIdTypeSdk)
+	if (err != nil && err.Code != AppIdTypeError) || appInfo.GameId != fields.GameId {
+		return 0
+	}
+	if fields.Sign != Md5Sum([]byte(fmt.Sprintf("%s&%s", StructToString(data), appInfo.SecretKey))) {
+		return 0
+	}
+	return fields.GameId
+}
+
 // 连接数据库
 func connMysql(config MysqlConfig) *sql.DB {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=%s&readTimeout=%s&writeTimeout=%s&charset=utf8", config.User, config.Pass, config.Host, config.Port, config.DbName, GConf.MysqlTimeout.MysqlTimeout, GConf.MysqlTimeout.MysqlReadTimeout, GConf.MysqlTimeout.MysqlWriteTimeout)
@@ -251,57 +280,83 @@ func SignValidator(appId int64, sign string, gameId int, dataFields interface{},
 	return nil
 }
 
-// 生成登录Token
-func BuildLoginToken(gameId int, platformId int, channelId int, loginRet *LoginReturnFields, tokenType int, expireTime int64) (string, *MyError) {
-	signingKey := []byte(GConf.Base.ServerKey)
-
+// 生成登录Token, 并记录会话
+// familyId 为token族id, 登录时新建, 刷新时沿用
+func BuildLoginToken(gameId int, platformId int, channelId int, loginRet *LoginReturnFields, tokenType int, familyId string, expireTime int64) (string, *MyError) {
+	jti := BuildTokenId()
 	claims := CustomClaims{
-		gameId,
-		platformId,
-		channelId,
-		loginRet.Uid,
-		loginRet.LoginTime,
-		tokenType,
-		jwt.StandardClaims{
+		GameId:     gameId,
+		PlatformId: platformId,
+		ChannelId:  channelId,
+		Uid:        loginRet.Uid,
+		LoginTime:  loginRet.LoginTime,
+		TokenType:  tokenType,
+		FamilyId:   familyId,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  GetTime(),
 			ExpiresAt: expireTime,
 			Issuer:    "account_server",
 		},
 	}
-	tokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, err := tokenClaims.SignedString(signingKey)
+	token, err := signLoginToken(claims)
 	if err != nil {
 		return "", &MyError{Code: BuildTokenFailure, Log: "jwt build token error: " + err.Error()}
 	}
 
+	sessionErr := SaveSession(jti, familyId, GetMainUid(loginRet.Uid, gameId, platformId), expireTime)
+	if sessionErr != nil {
+		return "", sessionErr
+	}
+
 	return token, nil
 }
 
-// 检查登录token内的uid与传入的uid是否一致
-func LoginTokenCheck(loginToken string, uid int64) *MyError {
-	//检查token解析，是否过期
-	token, tokenErr := jwt.ParseWithClaims(loginToken, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(GConf.Base.ServerKey), nil
-	})
+// ParseLoginToken 解析登录token, 校验签名、是否过期及是否已撤销, 返回token内的信息
+func ParseLoginToken(loginToken string) (*CustomClaims, *MyError) {
+	token, tokenErr := jwt.ParseWithClaims(loginToken, &CustomClaims{}, loginTokenVerifyKey)
 	if tokenErr != nil {
-		return &MyError{Code: LoginTokenParseError, Log: fmt.Sprintf("login token uid: %d, auth error: %s", uid, tokenErr)}
+		return nil, &MyError{Code: LoginTokenParseError, Log: fmt.Sprintf("login token parse error: %s", tokenErr)}
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		if claims.Uid != uid {
-			return &MyError{Code: LoginTokenUidUnequal, Log: fmt.Sprintf("bind account LoginToken parse uid: %d, params uid: %d", claims.Uid, uid)}
-		}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !token.Valid {
+		return nil, &MyError{Code: LoginTokenParseError, Log: "login token claims invalid"}
 	}
-	return nil
+
+	//是否已被撤销
+	sessionErr := CheckSession(claims)
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	return claims, nil
+}
+
+// 检查登录token内的uid与传入的uid是否一致, 返回token内容
+func LoginTokenCheck(loginToken string, uid int64) (*CustomClaims, *MyError) {
+	//检查token解析，是否过期
+	claims, err := ParseLoginToken(loginToken)
+	if err != nil {
+		err.Log = fmt.Sprintf("login token uid: %d, auth error: %s", uid, err.Log)
+		return nil, err
+	}
+
+	if claims.Uid != uid {
+		return nil, &MyError{Code: LoginTokenUidUnequal, Log: fmt.Sprintf("bind account LoginToken parse uid: %d, params uid: %d", claims.Uid, uid)}
+	}
+	return claims, nil
 }
 
-// 生成指定长度的随机数，不超过16位，验证码使用
-func GetRandom(len int) string {
+// 生成指定长度的随机数，不超过16位，验证码使用, 使用crypto/rand
+func GetRandom(len int) (string, error) {
 	if len > 16 {
-		return ""
+		return "", fmt.Errorf("random length %d more than 16", len)
 	}
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	lenFormat := int64(math.Pow10(len))
-	return fmt.Sprintf(fmt.Sprintf("%%0%dv", len), rnd.Int63n(lenFormat))
+	n, err := cryptoRand.Int(cryptoRand.Reader, big.NewInt(int64(math.Pow10(len))))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(fmt.Sprintf("%%0%dd", len), n.Int64()), nil
 }
 
 // 生成随机字符串，密码盐
@@ -313,11 +368,35 @@ func RandomString(n int) string {
 	return string(b)
 }
 
+// BuildTokenId 生成token唯一id(jti)
+func BuildTokenId() string {
+	b := make([]byte, 16)
+	_, err := cryptoRand.Read(b)
+	if err != nil {
+		return Md5Sum([]byte(GetUnixMilliString() + RandomString(16)))
+	}
+	return hex.EncodeToString(b)
+}
+
 // 获取当前时间，秒
 func GetTime() int64 {
 	return time.Now().Unix()
 }
 
+// GameUidPrefix 项目uid前缀, 项目id(最大6位) + 大区id(最大3位) + 0000000000
+func GameUidPrefix(gameId, platformId int) int64 {
+	gId := strings.Trim(fmt.Sprintf("%6d", gameId), " ")
+	pId := strings.Trim(fmt.Sprintf("%3d", platformId), " ")
+	uidFormat := "%s%s0000000000"
+	prefix, _ := strconv.ParseInt(fmt.Sprintf(uidFormat, gId, pId), 10, 64)
+	return prefix
+}
+
+// GetMainUid 根据项目uid得到主账号uid
+func GetMainUid(gameUid int64, gameId, platformId int) int64 {
+	return gameUid - GameUidPrefix(gameId, platformId)
+}
+
 // 检查用户账号格式
 func CheckUserAccountFormat(account string, accountType int) (string, *MyError) {
 	switch accountType {
@@ -363,7 +442,7 @@ func CheckUserAccountFormat(account string, accountType int) (string, *MyError)
 			if err != nil {
 				return account, &MyError{Code: ThirdIdParseFailure}
 			}
-			if _, ok := ThirdIds[id]; !ok {
+			if _, ok := GetThirdProvider(id); !ok {
 				return account, &MyError{Code: ThirdIdUnsupported}
 			}
 
@@ -394,37 +473,6 @@ func HttpPostForm(postUrl string, postData map[string]string) ([]byte, *MyError)
 	return body, nil
 }
 
-func GetRealAddr(r *http.Request) net.IP {
-	// parse X-ORIGINAL-FORWARDED-FOR header
-	if xoff := strings.Trim(r.Header.Get("X-ORIGINAL-FORWARDED-FOR"), ","); len(xoff) > 0 {
-		addrs := strings.Split(xoff, ",")
-		if ip := net.ParseIP(addrs[0]); ip != nil {
-			return ip
-		}
-	}
-
-	// If we have a forwarded-for header, take the address from there
-	if xff := strings.Trim(r.Header.Get("X-Forwarded-For"), ","); len(xff) > 0 {
-		addrs := strings.Split(xff, ",")
-		if ip := net.ParseIP(addrs[0]); ip != nil {
-			return ip
-		}
-	}
-	// parse X-Real-Ip header
-	if xri := r.Header.Get("X-Real-Ip"); len(xri) > 0 {
-		if ip := net.ParseIP(xri); ip != nil {
-			return ip
-		}
-	}
-
-	// the default is the originating ip. but we try to find better options because this is almost
-	// never the right IP
-	if parts := strings.Split(r.RemoteAddr, ":"); len(parts) >= 1 {
-		return net.ParseIP(parts[0])
-	}
-	return nil
-}
-
 // GetUnixMilliString 获取当前毫秒数字符串
 func GetUnixMilliString() string {
 	return strconv.FormatInt(time.Now().UnixMilli(), 10)
@@ -608,6 +656,16 @@ func GetAccountTable(uid int64) string {
 	return fmt.Sprintf("account_%d", GetAccountTableHashId(uid))
 }
 
+// 通行密钥表, 与主账号表相同分表
+func GetPasskeyTable(uid int64) string {
+	return fmt.Sprintf("passkey_%d", GetAccountTableHashId(uid))
+}
+
+// 第三方token表, 与主账号表相同分表
+func GetThirdTokenTable(uid int64) string {
+	return fmt.Sprintf("third_token_%d", GetAccountTableHashId(uid))
+}
+
 // 主账号表
 func GetAccountHashTable(account string) string {
 	return fmt.Sprintf("account_hash_%d", GetAccountHashTableHashId(account))
@@ -635,6 +693,19 @@ func GetGameUserSlaveDb(uid int64, gameId, platformId int) *sql.DB {
 	return db.(*sql.DB)
 }
 
+// GameUserDbExists 项目及大区的分库是否都已连接, 外部传入的项目需先检查, 否则获取db时退出进程
+func GameUserDbExists(gameId, platformId int) bool {
+	for i := uint32(1); i <= GameUserDbNumber; i++ {
+		if _, ok := GameUserMasterDbMap.Load(fmt.Sprintf("master_db_%d_%d_%d", gameId, platformId, i)); !ok {
+			return false
+		}
+		if _, ok := GameUserSlaveDbMap.Load(fmt.Sprintf("slave_db_%d_%d_%d", gameId, platformId, i)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func GetAllGameUserDb(dbType int) map[string]*sql.DB {
 	if dbType != GameUserSlaveDb && dbType != GameUserMasterDb {
 		return nil
@@ -674,6 +745,8 @@ func GetDbTable(uid int64, gameId, platformId int) *DbTable {
 		AccountMasterDb:          GetAccountMasterDb(strconv.FormatInt(uid, 10)),
 		AccountSlaveDb:           GetAccountSlaveDb(strconv.FormatInt(uid, 10)),
 		AccountTable:             GetAccountTable(uid),
+		PasskeyTable:             GetPasskeyTable(uid),
+		ThirdTokenTable:          GetThirdTokenTable(uid),
 		GameUserTable:            GetGameUserTable(uid),
 		GameUserDeleteApplyTable: GetGameUserDeleteTable(uid),
 	}
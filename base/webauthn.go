@@ -0,0 +1,386 @@
+/**
+ * @project Accounts
+ * @filename webauthn.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/21 10:20
+ * @version 1.0
+ * @description
+ * 通行密钥(WebAuthn), 注册及登录的校验
+ * 注册时从authenticatorData中解析COSE格式的凭证公钥(只解析公钥用到的CBOR), 与浏览器 response.getPublicKey() 返回的SPKI格式公钥一致才保存
+ * 不解析attestationObject的证书链, attestation 固定为 none
+ * 支持 ES256(-7)、RS256(-257)
+ */
+
+package base
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	WebauthnAlgES256 = -7
+	WebauthnAlgRS256 = -257
+
+	//authenticatorData 标志位
+	webauthnFlagUserPresent       = 0x01
+	webauthnFlagUserVerified      = 0x04
+	webauthnFlagAttestedCredData  = 0x40
+	webauthnAuthDataMinLength     = 37
+	webauthnAaguidLength          = 16
+	webauthnMaxCredentialIdLength = 93 //pk_ + base64url 不超过hash表account字段的128位
+
+	//COSE_Key 参数
+	coseKeyKty       = 1
+	coseKeyAlg       = 3
+	coseKeyCrv       = -1 //EC2 曲线, RSA 为n
+	coseKeyX         = -2 //EC2 x, RSA 为e
+	coseKeyY         = -3
+	coseKtyEC2       = 2
+	coseKtyRSA       = 3
+	coseCrvP256      = 1
+	cborMaxMapLength = 16
+)
+
+// WebauthnDecode base64url解码, 兼容带填充的写法
+func WebauthnDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(string(bytes.TrimRight([]byte(s), "=")))
+}
+
+// PasskeyAccount 凭证id对应的hash表账号
+func PasskeyAccount(credentialId []byte) string {
+	return PasskeyAccountPrefix + base64.RawURLEncoding.EncodeToString(credentialId)
+}
+
+// SaveWebauthnChallenge 生成challenge, 以challenge本身作为key, 客户端无需另外传challenge id
+func SaveWebauthnChallenge(info *WebauthnChallengeInfo) (string, *MyError) {
+	if !GConf.Webauthn.Enabled {
+		return "", &MyError{Code: PasskeyDisabled}
+	}
+	challenge := BuildTokenId() + BuildTokenId()
+	content, _ := json.Marshal(info)
+	err := RedisClient.Set(fmt.Sprintf(WebauthnChallengeFormat, challenge), content, time.Duration(GConf.Webauthn.Timeout)*time.Second).Err()
+	if err != nil {
+		return "", &MyError{Code: PasskeyChallengeSaveError, Log: "save webauthn challenge error: " + err.Error()}
+	}
+	return challenge, nil
+}
+
+// CheckWebauthnClientData 校验clientDataJSON并取出challenge, challenge只能使用一次
+func CheckWebauthnClientData(clientDataJson []byte, ceremonyType string) (*WebauthnChallengeInfo, *MyError) {
+	if !GConf.Webauthn.Enabled {
+		return nil, &MyError{Code: PasskeyDisabled}
+	}
+	clientData := &WebauthnClientData{}
+	err := json.Unmarshal(clientDataJson, clientData)
+	if err != nil {
+		return nil, &MyError{Code: PasskeyClientDataError, Log: "client data parse error: " + err.Error()}
+	}
+	if clientData.Type != ceremonyType {
+		return nil, &MyError{Code: PasskeyClientDataError, Log: fmt.Sprintf("client data type: %s, expected: %s", clientData.Type, ceremonyType)}
+	}
+	originAllowed := false
+	for _, origin := range GConf.Webauthn.Origins {
+		if origin == clientData.Origin {
+			originAllowed = true
+			break
+		}
+	}
+	if !originAllowed {
+		return nil, &MyError{Code: PasskeyClientDataError, Log: "client data origin not allowed: " + clientData.Origin}
+	}
+
+	//challenge是base64url编码的字符串, 取出后删除
+	challengeBytes, err := WebauthnDecode(clientData.Challenge)
+	if err != nil {
+		return nil, &MyError{Code: PasskeyChallengeInvalid, Log: "challenge decode error: " + err.Error()}
+	}
+	key := fmt.Sprintf(WebauthnChallengeFormat, string(challengeBytes))
+	pipe := RedisClient.TxPipeline()
+	getCmd := pipe.Get(key)
+	pipe.Del(key)
+	_, err = pipe.Exec()
+	if err != nil {
+		return nil, &MyError{Code: PasskeyChallengeInvalid, Log: "take webauthn challenge error: " + err.Error()}
+	}
+	info := &WebauthnChallengeInfo{}
+	err = json.Unmarshal([]byte(getCmd.Val()), info)
+	if err != nil || info.Type != ceremonyType {
+		return nil, &MyError{Code: PasskeyChallengeInvalid, Log: fmt.Sprintf("webauthn challenge type: %s, expected: %s", info.Type, ceremonyType)}
+	}
+	return info, nil
+}
+
+// ParseWebauthnAuthData 解析并校验authenticatorData, 注册时解析出凭证id及凭证公钥
+// rpIdHash(32) | flags(1) | signCount(4) | [aaguid(16) | credIdLen(2) | credId | credentialPublicKey]
+func ParseWebauthnAuthData(data []byte, registration bool) (*WebauthnAuthData, *MyError) {
+	if len(data) < webauthnAuthDataMinLength {
+		return nil, &MyError{Code: PasskeyAuthDataError, Log: fmt.Sprintf("authenticator data length: %d", len(data))}
+	}
+	authData := &WebauthnAuthData{
+		RpIdHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIdHash := sha256.Sum256([]byte(GConf.Webauthn.RpId))
+	if !bytes.Equal(authData.RpIdHash, rpIdHash[:]) {
+		return nil, &MyError{Code: PasskeyAuthDataError, Log: "rp id hash not match"}
+	}
+	if authData.Flags&webauthnFlagUserPresent == 0 {
+		return nil, &MyError{Code: PasskeyAuthDataError, Log: "user not present"}
+	}
+	if GConf.Webauthn.UserVerification && authData.Flags&webauthnFlagUserVerified == 0 {
+		return nil, &MyError{Code: PasskeyAuthDataError, Log: "user not verified"}
+	}
+	if !registration {
+		return authData, nil
+	}
+
+	if authData.Flags&webauthnFlagAttestedCredData == 0 || len(data) < webauthnAuthDataMinLength+webauthnAaguidLength+2 {
+		return nil, &MyError{Code: PasskeyAuthDataError, Log: "attested credential data not included"}
+	}
+	offset := webauthnAuthDataMinLength + webauthnAaguidLength
+	idLength := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if idLength == 0 || idLength > webauthnMaxCredentialIdLength || len(data) < offset+idLength {
+		return nil, &MyError{Code: PasskeyCredentialIdError, Log: fmt.Sprintf("credential id length: %d", idLength)}
+	}
+	authData.CredentialId = data[offset : offset+idLength]
+
+	//凭证公钥, 转为SPKI格式与客户端传入的公钥比对
+	key, algorithm, err := parseCoseKey(data[offset+idLength:])
+	if err != nil {
+		return nil, &MyError{Code: PasskeyPublicKeyError, Log: "parse credential public key error: " + err.Error()}
+	}
+	authData.PublicKey, err = x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, &MyError{Code: PasskeyPublicKeyError, Log: "marshal credential public key error: " + err.Error()}
+	}
+	authData.Algorithm = algorithm
+	return authData, nil
+}
+
+// 解析COSE_Key, 只支持 ES256(P-256) 及 RS256
+func parseCoseKey(data []byte) (crypto.PublicKey, int, error) {
+	params, err := parseCborIntMap(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	kty, _ := params[coseKeyKty].(int64)
+	alg, _ := params[coseKeyAlg].(int64)
+	switch {
+	case kty == coseKtyEC2 && alg == WebauthnAlgES256:
+		crv, _ := params[coseKeyCrv].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("ec2 key crv: %d, x: %d, y: %d", crv, len(x), len(y))
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, errors.New("ec2 point not on curve")
+		}
+		return key, WebauthnAlgES256, nil
+	case kty == coseKtyRSA && alg == WebauthnAlgRS256:
+		n, _ := params[coseKeyCrv].([]byte)
+		e, _ := params[coseKeyX].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("rsa key n: %d, e: %d", len(n), len(e))
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, WebauthnAlgRS256, nil
+	}
+	return nil, 0, fmt.Errorf("cose key kty: %d, alg: %d not supported", kty, alg)
+}
+
+// 解析key为整数的CBOR map, 值只支持整数、字节串、文本串, 足够解析COSE_Key; map之后的扩展数据忽略
+func parseCborIntMap(data []byte) (map[int64]interface{}, error) {
+	major, length, offset, err := cborHead(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if major != 5 || length > cborMaxMapLength {
+		return nil, fmt.Errorf("cbor major type %d, length %d is not a key map", major, length)
+	}
+	params := make(map[int64]interface{}, length)
+	for i := uint64(0); i < length; i++ {
+		var key interface{}
+		key, offset, err = cborItem(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		intKey, ok := key.(int64)
+		if !ok {
+			return nil, errors.New("cbor map key is not integer")
+		}
+		params[intKey], offset, err = cborItem(data, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// 读取一个CBOR数据项, 整数返回int64, 字节串、文本串返回[]byte
+func cborItem(data []byte, offset int) (interface{}, int, error) {
+	major, arg, offset, err := cborHead(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch major {
+	case 0, 1:
+		if arg > 1<<62 {
+			return nil, 0, fmt.Errorf("cbor integer %d overflow", arg)
+		}
+		if major == 1 {
+			return -1 - int64(arg), offset, nil
+		}
+		return int64(arg), offset, nil
+	case 2, 3:
+		if arg > uint64(len(data)-offset) {
+			return nil, 0, fmt.Errorf("cbor string length %d out of range", arg)
+		}
+		end := offset + int(arg)
+		return data[offset:end], end, nil
+	}
+	return nil, 0, fmt.Errorf("cbor major type %d not supported", major)
+}
+
+// 读取CBOR数据项头部, 返回主类型、参数及之后的位置, 不支持不定长
+func cborHead(data []byte, offset int) (byte, uint64, int, error) {
+	if offset >= len(data) {
+		return 0, 0, 0, errors.New("cbor data too short")
+	}
+	major, info := data[offset]>>5, data[offset]&0x1f
+	offset++
+	if info < 24 {
+		return major, uint64(info), offset, nil
+	}
+	if info > 27 {
+		return 0, 0, 0, fmt.Errorf("cbor additional info %d not supported", info)
+	}
+	size := 1 << (info - 24)
+	if len(data) < offset+size {
+		return 0, 0, 0, errors.New("cbor data too short")
+	}
+	var arg uint64
+	for _, b := range data[offset : offset+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	return major, arg, offset + size, nil
+}
+
+// CheckPasskeyPublicKey 检查SPKI公钥与算法是否一致
+func CheckPasskeyPublicKey(der []byte, algorithm int) *MyError {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return &MyError{Code: PasskeyPublicKeyError, Log: "parse public key error: " + err.Error()}
+	}
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm == WebauthnAlgES256 && pub.Curve == elliptic.P256() {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if algorithm == WebauthnAlgRS256 {
+			return nil
+		}
+	}
+	return &MyError{Code: PasskeyPublicKeyError, Log: fmt.Sprintf("public key type %T, algorithm %d not supported", key, algorithm)}
+}
+
+// VerifyPasskeySignature 校验登录签名, 签名内容为 authenticatorData + sha256(clientDataJSON)
+func VerifyPasskeySignature(credential *PasskeyCredential, authData, clientDataJson, signature []byte) *MyError {
+	key, err := x509.ParsePKIXPublicKey(credential.PublicKey)
+	if err != nil {
+		return &MyError{Code: PasskeyPublicKeyError, Log: "parse public key error: " + err.Error()}
+	}
+	clientDataHash := sha256.Sum256(clientDataJson)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	verified := false
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		verified = credential.Algorithm == WebauthnAlgES256 && ecdsa.VerifyASN1(pub, digest[:], signature)
+	case *rsa.PublicKey:
+		verified = credential.Algorithm == WebauthnAlgRS256 && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !verified {
+		return &MyError{Code: PasskeySignatureError, Log: fmt.Sprintf("credential %s signature verify failed", credential.CredentialId)}
+	}
+	return nil
+}
+
+// CheckPasskeySignCount 签名计数必须递增, 都为0表示认证器不支持计数(如同步的通行密钥)
+func CheckPasskeySignCount(credential *PasskeyCredential, signCount uint32) *MyError {
+	if signCount == 0 && credential.SignCount == 0 {
+		return nil
+	}
+	if signCount <= credential.SignCount {
+		SecurityLog.Warn().
+			Str("event", "passkey_sign_count").
+			Int64("main_uid", credential.MainUid).
+			Str("credential_id", credential.CredentialId).
+			Uint32("stored", credential.SignCount).
+			Uint32("received", signCount).
+			Msg("passkey sign count did not increase, credential may be cloned")
+		return &MyError{Code: PasskeySignCountError, Log: fmt.Sprintf("credential %s sign count %d, stored %d", credential.CredentialId, signCount, credential.SignCount)}
+	}
+	return nil
+}
+
+// GetPasskeyCreationOptions 注册选项
+func GetPasskeyCreationOptions(challenge string, uid int64, excludeIds []string) *PasskeyCreationOptions {
+	userVerification := "preferred"
+	if GConf.Webauthn.UserVerification {
+		userVerification = "required"
+	}
+	exclude := []map[string]string{}
+	for _, id := range excludeIds {
+		exclude = append(exclude, map[string]string{"type": "public-key", "id": id})
+	}
+	uidStr := fmt.Sprintf("%d", uid)
+	return &PasskeyCreationOptions{
+		Challenge: base64.RawURLEncoding.EncodeToString([]byte(challenge)),
+		Rp:        map[string]string{"id": GConf.Webauthn.RpId, "name": GConf.Webauthn.RpName},
+		User: map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString([]byte(uidStr)),
+			"name":        uidStr,
+			"displayName": uidStr,
+		},
+		PubKeyCredParams: []map[string]interface{}{
+			{"type": "public-key", "alg": WebauthnAlgES256},
+			{"type": "public-key", "alg": WebauthnAlgRS256},
+		},
+		Timeout:                GConf.Webauthn.Timeout * 1000,
+		Attestation:            "none",
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: map[string]string{"residentKey": "required", "userVerification": userVerification},
+	}
+}
+
+// GetPasskeyRequestOptions 登录选项, 使用可发现凭证, 不指定allowCredentials
+func GetPasskeyRequestOptions(challenge string) *PasskeyRequestOptions {
+	userVerification := "preferred"
+	if GConf.Webauthn.UserVerification {
+		userVerification = "required"
+	}
+	return &PasskeyRequestOptions{
+		Challenge:        base64.RawURLEncoding.EncodeToString([]byte(challenge)),
+		RpId:             GConf.Webauthn.RpId,
+		Timeout:          GConf.Webauthn.Timeout * 1000,
+		UserVerification: userVerification,
+	}
+}
@@ -6,7 +6,7 @@
  * @datetime 2023/3/29 12:02
  * @version 1.0
  * @description
- * 图形验证码， 用于拦截恶意请求
+ * 人机验证(图形验证码、语音、滑块、第三方)， 用于拦截恶意请求, 挑战类型见 base/challenge.go
  */
 
 package controllers
@@ -18,7 +18,7 @@ import (
 	"net/http"
 )
 
-// 验证码显示、刷新
+// 挑战展示、刷新, 第三方挑战在客户端展示
 func Image(resp http.ResponseWriter, req *http.Request) {
 	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
 	userLog := hlog.FromRequest(req)
@@ -38,16 +38,24 @@ func Image(resp http.ResponseWriter, req *http.Request) {
 	if data.Height == -1 {
 		data.Height = captcha.StdHeight
 	}
-	if data.Refresh == 1 {
-		captcha.Reload(data.Id)
-	}
 
+	challenge := base.LoadChallenge(data.Id)
+	provider, conf := base.GetChallengeProvider(challenge.Provider)
 	resp.Header().Set("Access-Control-Allow-Origin", "*") //允许访问所有域
-	resp.Header().Set("Content-Type", "image/png")
-	captcha.WriteImage(resp, data.Id, data.Width, data.Height)
+	answer, myErr := provider.Render(resp, challenge, data, conf)
+	if myErr != nil {
+		base.ResponseFail(resp, myErr, userLog.Hook(requestHook))
+		return
+	}
+	//刷新后保存新的答案
+	if answer != "" {
+		if err := base.SaveChallenge(challenge); err != nil {
+			userLog.Error().Msgf("save challenge %s error: %s", challenge.Id, err.Error())
+		}
+	}
 }
 
-// 验证，通过则删除限制
+// 验证，通过则删除生成挑战时保存的锁定并重新计数; 按生成时保存的挑战类型校验, 每个挑战只能校验一次
 func Verify(resp http.ResponseWriter, req *http.Request) {
 	ip := base.GetRealAddr(req).String()
 	requestHook := base.RequestHook{IP: ip}
@@ -61,15 +69,17 @@ func Verify(resp http.ResponseWriter, req *http.Request) {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
 	}
-	ok := captcha.VerifyString(data.CaptchaId, data.CaptchaCode)
-	if !ok {
-		base.ResponseFail(resp, &base.MyError{Code: base.RequestLimitCodeError}, userLog.Hook(requestHook))
+	challenge := base.TakeChallenge(data.CaptchaId)
+	provider, conf := base.GetChallengeProvider(challenge.Provider)
+	if myErr := provider.Verify(challenge, data.CaptchaCode, ip, conf); myErr != nil {
+		base.ResponseFail(resp, myErr, userLog.Hook(requestHook))
 		return
 	}
 
-	locKey := data.CaptchaType + ip
-	ipKey := base.LimitIpKey + ip
-	base.RedisClient.Del(locKey)
-	base.RedisClient.Del(ipKey)
+	//删除生成挑战时保存的锁定及计数, 不使用请求中的限制类型
+	if myErr := base.UnlockChallenge(challenge); myErr != nil {
+		base.ResponseFail(resp, myErr, userLog.Hook(requestHook))
+		return
+	}
 	base.ResponseOK(resp, base.EmptyData, userLog.Hook(requestHook))
 }
@@ -0,0 +1,38 @@
+/**
+ * @project Accounts
+ * @filename jwks.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/12 16:05
+ * @version 1.0
+ * @description
+ * 公开token验证公钥(JWKS), 游戏服务器可据此离线验证登录token
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"encoding/json"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+)
+
+// Jwks 返回标准JWKS格式, 不使用统一的code/msg/data结构
+func Jwks(resp http.ResponseWriter, req *http.Request) {
+	userLog := hlog.FromRequest(req)
+	body, err := json.Marshal(base.GetJwks())
+	if err != nil {
+		userLog.Error().Msgf("jwks json error %s", err.Error())
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.Header().Set("Cache-Control", "public, max-age=300")
+	_, err = resp.Write(body)
+	if err != nil {
+		userLog.Error().Msgf("jwks response write error %s", err.Error())
+	}
+}
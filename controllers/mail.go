@@ -0,0 +1,59 @@
+/**
+ * @project Accounts
+ * @filename mail.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/27 15:00
+ * @version 1.0
+ * @description
+ * 邮件发送状态查询, 发送验证码(邮件方式)返回的mail_id
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"fmt"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+)
+
+// MailStatus 查询邮件发送状态, 只能查询本项目发送的邮件
+func MailStatus(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	data := &base.MailStatusFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	msg, err := base.GetMailMessage(data.MailId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	if msg.GameId != data.GameId || msg.PlatformId != data.PlatformId {
+		base.ResponseFail(resp, &base.MyError{Code: base.MailNotExists, Log: fmt.Sprintf("mail %s game %d-%d", data.MailId, msg.GameId, msg.PlatformId)}, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, &base.MailStatusReturnFields{
+		MailId:      msg.Id,
+		Status:      msg.Status,
+		Attempts:    msg.Attempts,
+		UpdatedTime: msg.UpdatedTime,
+	}, userLog.Hook(requestHook))
+	return
+}
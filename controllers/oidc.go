@@ -0,0 +1,437 @@
+/**
+ * @project Accounts
+ * @filename oidc.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/14 14:20
+ * @version 1.0
+ * @description
+ * OIDC provider, 官网、论坛等通过授权码模式(PKCE)使用主账号登录
+ * discovery、token、userinfo 使用OAuth2标准返回格式, 登录页提交凭证的接口使用统一的code/msg/data格式
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"accounts/models"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// OidcDiscovery /.well-known/openid-configuration
+func OidcDiscovery(resp http.ResponseWriter, req *http.Request) {
+	userLog := hlog.FromRequest(req)
+	discovery, err := base.GetOidcDiscovery()
+	if err != nil {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+	resp.Header().Set("Cache-Control", "public, max-age=300")
+	oauthResponse(resp, http.StatusOK, discovery, userLog)
+}
+
+// OidcAuthorize 授权
+// GET: 浏览器跳转, 检查参数后带上原始参数跳转到登录页
+// POST: 登录页提交账号凭证, 登录成功后返回带授权码的redirect_uri, 由登录页跳转
+func OidcAuthorize(resp http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		oidcAuthorizeRedirect(resp, req)
+		return
+	}
+
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	requestHook := base.RequestHook{IP: ip}
+	data := &base.OidcAuthorizeFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.RequestBody = map[string]interface{}{"client_id": data.ClientId, "redirect_uri": data.RedirectUri, "account": data.Account, "type": data.Type}
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+
+	client, err := base.GetOidcClient(data.ClientId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.GameId = client.GameId
+	err = base.CheckOidcRedirectUri(client, data.RedirectUri)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	err = base.CheckOidcAuthorizeParams(data.ResponseType, data.Scope, data.CodeChallenge, data.CodeChallengeMethod)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	//复用登录流程, 官网、论坛等没有渠道
+	loginData := &base.LoginFields{
+		Account:   data.Account,
+		Code:      data.Code,
+		Password:  data.Password,
+		Type:      data.Type,
+		ChannelId: 0,
+		DataExt:   "{}",
+		CommonFields: base.CommonFields{
+			GameId:     client.GameId,
+			PlatformId: int(client.PlatformId),
+			AppId:      client.AppId,
+		},
+	}
+	//OIDC授权在浏览器中完成, 设备类型记为pc/h5
+	session := &base.SessionInfo{DeviceType: 3, Ip: ip, UserAgent: req.UserAgent()}
+	pending := &base.OidcPendingInfo{
+		ClientId:      data.ClientId,
+		RedirectUri:   data.RedirectUri,
+		Scope:         data.Scope,
+		State:         data.State,
+		Nonce:         data.Nonce,
+		CodeChallenge: data.CodeChallenge,
+	}
+	ret, err := credentialLogin(loginData, ip, session, userLog)
+	if err != nil {
+		//已开启二次验证, 保存授权参数, 登录页用challenge_token提交验证码到 /oauth/authorize/totp
+		if secondFactor, ok := err.Data.(*base.SecondFactorFields); ok && err.Code == base.LoginSecondFactorRequired {
+			pendingErr := base.SaveOidcPending(secondFactor.ChallengeToken, pending)
+			if pendingErr != nil {
+				err = pendingErr
+			}
+		}
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.Uid = ret.Uid
+
+	redirectUri, err := oidcIssueCode(client, pending, ret)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	//数据写入
+	base.DataExtLog(ret.Uid, loginData.DataExt, "1314520", ip, base.LoginDataLog)
+	base.ResponseOK(resp, &base.OidcAuthorizeRespFields{RedirectUri: redirectUri}, userLog.Hook(requestHook))
+	return
+}
+
+// OidcAuthorizeTotp OIDC登录需要二次验证时, 登录页提交验证码, 通过后签发授权码
+func OidcAuthorizeTotp(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	requestHook := base.RequestHook{IP: ip}
+	data := &base.OidcAuthorizeTotpFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.RequestBody = map[string]interface{}{"challenge_token": data.ChallengeToken}
+
+	pending, err := base.GetOidcPending(data.ChallengeToken)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	client, err := base.GetOidcClient(pending.ClientId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	requestHook.GameId = client.GameId
+
+	//challenge必须是该客户端所属项目登录时生成的
+	ret, err := models.TotpLogin(&base.TotpLoginFields{
+		ChallengeToken: data.ChallengeToken,
+		Code:           data.Code,
+		CommonFields: base.CommonFields{
+			GameId:     client.GameId,
+			PlatformId: int(client.PlatformId),
+			AppId:      client.AppId,
+		},
+	})
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	base.DeleteOidcPending(data.ChallengeToken)
+	requestHook.Uid = ret.Uid
+
+	redirectUri, err := oidcIssueCode(client, pending, ret)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	//数据写入
+	base.DataExtLog(ret.Uid, "{}", "1314520", ip, base.LoginDataLog)
+	base.ResponseOK(resp, &base.OidcAuthorizeRespFields{RedirectUri: redirectUri}, userLog.Hook(requestHook))
+	return
+}
+
+// 登录成功后签发授权码, 返回带授权码的回调地址
+func oidcIssueCode(client base.AppIdConfig, pending *base.OidcPendingInfo, ret *base.LoginReturnFields) (string, *base.MyError) {
+	code, err := base.SaveOidcCode(&base.OidcCodeInfo{
+		ClientId:      pending.ClientId,
+		RedirectUri:   pending.RedirectUri,
+		Scope:         pending.Scope,
+		Nonce:         pending.Nonce,
+		CodeChallenge: pending.CodeChallenge,
+		MainUid:       base.GetMainUid(ret.Uid, client.GameId, int(client.PlatformId)),
+		Email:         ret.Binds.Email,
+		AuthTime:      ret.LoginTime,
+		Tokens:        ret.Tokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if pending.State != "" {
+		params.Set("state", pending.State)
+	}
+	return appendQuery(pending.RedirectUri, params), nil
+}
+
+// 浏览器跳转到授权地址, client或redirect_uri错误时不能跳转回客户端, 直接返回错误
+func oidcAuthorizeRedirect(resp http.ResponseWriter, req *http.Request) {
+	userLog := hlog.FromRequest(req)
+	query := req.URL.Query()
+
+	client, err := base.GetOidcClient(query.Get("client_id"))
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_request", err, userLog)
+		return
+	}
+	redirectUri := query.Get("redirect_uri")
+	err = base.CheckOidcRedirectUri(client, redirectUri)
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_request", err, userLog)
+		return
+	}
+
+	err = base.CheckOidcAuthorizeParams(query.Get("response_type"), query.Get("scope"), query.Get("code_challenge"), query.Get("code_challenge_method"))
+	if err != nil {
+		userLog.Error().Int("error_code", err.Code).Msg(err.Log)
+		oauthErr := "invalid_request"
+		switch err.Code {
+		case base.OidcResponseTypeError:
+			oauthErr = "unsupported_response_type"
+		case base.OidcScopeError:
+			oauthErr = "invalid_scope"
+		}
+		params := url.Values{}
+		params.Set("error", oauthErr)
+		params.Set("error_description", err.Error())
+		if state := query.Get("state"); state != "" {
+			params.Set("state", state)
+		}
+		http.Redirect(resp, req, appendQuery(redirectUri, params), http.StatusFound)
+		return
+	}
+
+	http.Redirect(resp, req, base.GConf.Oidc.LoginPage+"?"+req.URL.RawQuery, http.StatusFound)
+}
+
+// OidcToken 授权码或刷新token换取token, application/x-www-form-urlencoded
+func OidcToken(resp http.ResponseWriter, req *http.Request) {
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set("Pragma", "no-cache")
+	if req.Method != http.MethodPost {
+		oauthFail(resp, http.StatusMethodNotAllowed, "invalid_request", &base.MyError{Code: base.NotPostRequest}, userLog)
+		return
+	}
+	parseErr := req.ParseForm()
+	if parseErr != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_request", &base.MyError{Code: base.RequestDataParserError, Log: parseErr.Error()}, userLog)
+		return
+	}
+
+	//客户端认证, 支持client_secret_basic和client_secret_post
+	clientId, clientSecret, ok := req.BasicAuth()
+	if !ok {
+		clientId = req.PostForm.Get("client_id")
+		clientSecret = req.PostForm.Get("client_secret")
+	}
+	client, err := base.GetOidcClient(clientId)
+	if err != nil {
+		oauthFail(resp, http.StatusUnauthorized, "invalid_client", err, userLog)
+		return
+	}
+	err = base.CheckOidcClientSecret(client, clientSecret)
+	if err != nil {
+		oauthFail(resp, http.StatusUnauthorized, "invalid_client", err, userLog)
+		return
+	}
+
+	grantType := req.PostForm.Get("grant_type")
+	userLog.Info().Str("client_id", clientId).Str("grant_type", grantType).Msg("")
+	switch grantType {
+	case "authorization_code":
+		oidcTokenByCode(resp, req, clientId, userLog)
+	case "refresh_token":
+		oidcTokenByRefresh(resp, req, client, ip, userLog)
+	default:
+		oauthFail(resp, http.StatusBadRequest, "unsupported_grant_type", &base.MyError{Code: base.OidcGrantTypeError, Log: "grant_type: " + grantType}, userLog)
+	}
+}
+
+// 授权码换取token
+func oidcTokenByCode(resp http.ResponseWriter, req *http.Request, clientId string, userLog *zerolog.Logger) {
+	info, err := base.TakeOidcCode(req.PostForm.Get("code"))
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+	if info.ClientId != clientId || info.RedirectUri != req.PostForm.Get("redirect_uri") {
+		err = &base.MyError{Code: base.OidcCodeInvalid, Log: fmt.Sprintf("code client %s redirect_uri %s, request client %s redirect_uri %s", info.ClientId, info.RedirectUri, clientId, req.PostForm.Get("redirect_uri"))}
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+	err = base.CheckPkce(req.PostForm.Get("code_verifier"), info.CodeChallenge)
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+
+	//scope包含email时才在id_token中返回email
+	email := ""
+	for _, v := range strings.Fields(info.Scope) {
+		if v == "email" {
+			email = info.Email
+			break
+		}
+	}
+	idToken, err := base.BuildIdToken(clientId, info.MainUid, info.Nonce, info.AuthTime, email)
+	if err != nil {
+		oauthFail(resp, http.StatusInternalServerError, "server_error", err, userLog)
+		return
+	}
+
+	oauthResponse(resp, http.StatusOK, &base.OidcTokenRespFields{
+		AccessToken:  info.Tokens.LoginToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    info.Tokens.ExpiresIn,
+		RefreshToken: info.Tokens.RefreshToken,
+		IdToken:      idToken,
+		Scope:        info.Scope,
+	}, userLog)
+}
+
+// 刷新token换取token, 与 /user/refreshToken 规则一致, 刷新token只能使用一次
+func oidcTokenByRefresh(resp http.ResponseWriter, req *http.Request, client base.AppIdConfig, ip string, userLog *zerolog.Logger) {
+	refreshToken := req.PostForm.Get("refresh_token")
+	claims, err := base.ParseLoginToken(refreshToken)
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+	if claims.TokenType != base.TokenTypeRefresh {
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", &base.MyError{Code: base.RefreshTokenTypeError, Log: fmt.Sprintf("token type: %d", claims.TokenType)}, userLog)
+		return
+	}
+	if claims.GameId != client.GameId || claims.PlatformId != int(client.PlatformId) {
+		err = &base.MyError{Code: base.RefreshTokenGameNotMatch, Log: fmt.Sprintf("refresh token game: %d-%d, client game: %d-%d", claims.GameId, claims.PlatformId, client.GameId, client.PlatformId)}
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+	err = base.UseRefreshToken(claims, refreshToken, ip)
+	if err != nil {
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+
+	//未返回新token时删除使用标记, 原刷新token仍可使用
+	tokens, err := models.RefreshLoginToken(claims)
+	if err != nil {
+		base.ReleaseRefreshToken(claims, refreshToken)
+		oauthFail(resp, http.StatusBadRequest, "invalid_grant", err, userLog)
+		return
+	}
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	idToken, err := base.BuildIdToken(strconv.FormatInt(client.AppId, 10), mainUid, "", claims.LoginTime, "")
+	if err != nil {
+		base.ReleaseRefreshToken(claims, refreshToken)
+		oauthFail(resp, http.StatusInternalServerError, "server_error", err, userLog)
+		return
+	}
+
+	oauthResponse(resp, http.StatusOK, &base.OidcTokenRespFields{
+		AccessToken:  tokens.LoginToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		IdToken:      idToken,
+	}, userLog)
+}
+
+// OidcUserInfo 使用access_token获取主账号信息, Authorization: Bearer access_token
+func OidcUserInfo(resp http.ResponseWriter, req *http.Request) {
+	userLog := hlog.FromRequest(req)
+	resp.Header().Set("Cache-Control", "no-store")
+
+	accessToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	claims, err := base.ParseLoginToken(accessToken)
+	if err == nil && claims.TokenType != base.TokenTypeAccess {
+		err = &base.MyError{Code: base.OidcAccessTokenError, Log: fmt.Sprintf("token type: %d", claims.TokenType)}
+	}
+	if err == nil {
+		err = models.CheckGameUserEnabled(claims.Uid, claims.GameId, claims.PlatformId)
+	}
+	if err != nil {
+		resp.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthFail(resp, http.StatusUnauthorized, "invalid_token", err, userLog)
+		return
+	}
+
+	userInfo, err := models.GetOidcUserInfo(claims)
+	if err != nil {
+		oauthFail(resp, http.StatusInternalServerError, "server_error", err, userLog)
+		return
+	}
+	oauthResponse(resp, http.StatusOK, userInfo, userLog)
+}
+
+// OAuth2标准格式返回
+func oauthResponse(resp http.ResponseWriter, status int, data interface{}, userLog *zerolog.Logger) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		userLog.Error().Msgf("oauth response json error %s", err.Error())
+	}
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.WriteHeader(status)
+	_, err = resp.Write(body)
+	if err != nil {
+		userLog.Error().Msgf("oauth response write error %s", err.Error())
+	}
+}
+
+// OAuth2标准格式错误返回
+func oauthFail(resp http.ResponseWriter, status int, oauthErr string, err *base.MyError, userLog *zerolog.Logger) {
+	userLog.Error().Int("error_code", err.Code).Str("oauth_error", oauthErr).Msg(err.Log)
+	oauthResponse(resp, status, &base.OauthErrorFields{Error: oauthErr, ErrorDescription: err.Error()}, userLog)
+}
+
+// 在地址上追加参数, 保留原有参数
+func appendQuery(rawUrl string, params url.Values) string {
+	if strings.Contains(rawUrl, "?") {
+		return rawUrl + "&" + params.Encode()
+	}
+	return rawUrl + "?" + params.Encode()
+}
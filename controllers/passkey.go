@@ -0,0 +1,188 @@
+/**
+ * @project Accounts
+ * @filename passkey.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/21 16:20
+ * @version 1.0
+ * @description
+ * 通行密钥(WebAuthn), 已登录账号注册通行密钥, 及使用通行密钥免密登录
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"accounts/models"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+	"strconv"
+)
+
+// PasskeyRegisterOptions 获取注册选项, 客户端传给 navigator.credentials.create()
+func PasskeyRegisterOptions(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.PasskeyOptionsFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.PasskeyTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	ret, err := models.PasskeyRegisterOptions(claims)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+	return
+}
+
+// PasskeyRegister 注册通行密钥
+func PasskeyRegister(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.PasskeyRegisterFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.PasskeyTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	credentialId, err := models.PasskeyRegister(claims, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "passkey_registered").
+		Int64("uid", data.Uid).
+		Int("game_id", data.GameId).
+		Str("credential_id", credentialId).
+		Str("ip", requestHook.IP).
+		Msg("passkey registered")
+	base.ResponseOK(resp, &base.PasskeyRegisterRespFields{CredentialId: credentialId}, userLog.Hook(requestHook))
+	return
+}
+
+// PasskeyLoginOptions 获取登录选项, 客户端传给 navigator.credentials.get()
+func PasskeyLoginOptions(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.PasskeyLoginOptionsFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	ret, err := models.PasskeyLoginOptions(data.GameId, data.PlatformId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+	return
+}
+
+// PasskeyLogin 通行密钥登录, 返回格式与登录一致, 启用了二次验证的账号同样需要二次验证
+func PasskeyLogin(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	data := &base.PasskeyLoginFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	logHook.RequestBody = data
+	logHook.GameId = data.GameId
+	logHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	err = base.LimitLogin(ip)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	deviceType, _ := strconv.Atoi(req.Header.Get(base.HeaderDeviceType))
+	session := &base.SessionInfo{DeviceType: deviceType, Ip: ip, UserAgent: req.UserAgent()}
+	ret, err := models.PasskeyLogin(data, session)
+	if err != nil {
+		//签名或计数校验失败计入登录限制
+		if err.Code == base.PasskeySignatureError || err.Code == base.PasskeySignCountError || err.Code == base.PasskeyNotExists {
+			limitErr := base.LimitLoginIncr(ip, base.PasskeyAccountPrefix+data.CredentialId)
+			if limitErr != nil {
+				userLog.Err(limitErr).Msg("limit login incr error")
+			}
+		}
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(logHook))
+	return
+}
@@ -31,6 +31,19 @@ func TimingRefresh() {
 
 	//定时刷新节假日信息
 	go refreshHoliday()
+
+	//定时读取邮件、短信模板, 写入内存中
+	go refreshTemplate()
+
+	//定时读取ip访问名单、GeoIP文件, 写入内存中
+	go refreshIpAccess()
+
+	//定时检查苹果授权是否撤销
+	go refreshAppleConsent()
+
+	//token签名密钥环先同步加载, 避免启动初期仍使用ServerKey签名
+	base.RefreshJwtKeyRing(true)
+	go refreshJwtKeyRing()
 }
 
 // 定时刷新接口key
@@ -73,6 +86,63 @@ func refreshHoliday() {
 	}
 }
 
+// 定时刷新邮件、短信模板
+func refreshTemplate() {
+	//先初始化一次
+	models.RefreshTemplate()
+
+	//未单独配置时与game_config刷新时间一致
+	refreshTime := base.GConf.RefreshTime.TemplateRefreshTime
+	if refreshTime <= 0 {
+		refreshTime = base.GConf.RefreshTime.GameConfigRefreshTime
+	}
+	for range time.Tick(time.Second * time.Duration(refreshTime)) {
+		models.RefreshTemplate()
+	}
+}
+
+// 定时刷新ip访问名单、GeoIP文件
+func refreshIpAccess() {
+	//先初始化一次
+	models.RefreshIpAccessList()
+
+	//未单独配置时与game_config刷新时间一致
+	refreshTime := base.GConf.RefreshTime.IpAccessRefreshTime
+	if refreshTime <= 0 {
+		refreshTime = base.GConf.RefreshTime.GameConfigRefreshTime
+	}
+	for range time.Tick(time.Second * time.Duration(refreshTime)) {
+		models.RefreshIpAccessList()
+		base.RefreshGeoIp()
+	}
+}
+
+// 定时检查保存的苹果刷新token, 未配置时间时不检查
+func refreshAppleConsent() {
+	if base.GConf.RefreshTime.AppleConsentRefreshTime <= 0 {
+		return
+	}
+	for range time.Tick(time.Second * time.Duration(base.GConf.RefreshTime.AppleConsentRefreshTime)) {
+		models.RefreshAppleConsent()
+	}
+}
+
+// 定时刷新token签名密钥环
+func refreshJwtKeyRing() {
+	if base.GConf.JwtKeyRing.Path == "" {
+		return
+	}
+
+	//未单独配置时与接口key刷新时间一致
+	refreshTime := base.GConf.RefreshTime.JwtKeyRefreshTime
+	if refreshTime <= 0 {
+		refreshTime = base.GConf.RefreshTime.AppKeyRefreshTime
+	}
+	for range time.Tick(time.Second * time.Duration(refreshTime)) {
+		base.RefreshJwtKeyRing(false)
+	}
+}
+
 // 心跳监测，正常返回200
 func Heartbeat(resp http.ResponseWriter, req *http.Request) {
 	resp.WriteHeader(200)
@@ -0,0 +1,131 @@
+/**
+ * @project Accounts
+ * @filename sessions.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/17 15:20
+ * @version 1.0
+ * @description
+ * 登录会话管理, 查看当前账号在各设备上的登录会话, 撤销指定会话或其他所有会话
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"fmt"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+)
+
+// Sessions 登录会话列表
+func Sessions(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.SessionListFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.SessionTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	sessions, err := base.ListSessions(mainUid, claims.FamilyId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, sessions, userLog.Hook(requestHook))
+	return
+}
+
+// RevokeSession 撤销登录会话
+// 撤销后该会话的登录token和刷新token立即失效
+func RevokeSession(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.RevokeSessionFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.SessionTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	if data.Others == 1 {
+		err = base.RevokeOtherSessions(mainUid, claims.FamilyId)
+	} else if data.SessionId == "" || data.SessionId == base.DefaultNoValue {
+		err = &base.MyError{Code: base.SessionIdEmpty}
+	} else {
+		err = base.RevokeUserSession(mainUid, data.SessionId)
+	}
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "session_revoke").
+		Int64("uid", data.Uid).
+		Int("game_id", data.GameId).
+		Str("session_id", data.SessionId).
+		Int("others", data.Others).
+		Str("ip", requestHook.IP).
+		Msg("user revoked login session")
+	base.ResponseOK(resp, base.EmptyData, userLog.Hook(requestHook))
+	return
+}
+
+// 会话管理、二次验证设置等使用登录token, token内的uid需与参数一致
+func accessTokenCheck(token string, uid int64, typeErrCode int) (*base.CustomClaims, *base.MyError) {
+	claims, err := base.ParseLoginToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != base.TokenTypeAccess {
+		return nil, &base.MyError{Code: typeErrCode, Log: fmt.Sprintf("token type: %d", claims.TokenType)}
+	}
+	if claims.Uid != uid {
+		return nil, &base.MyError{Code: base.LoginTokenUidUnequal, Log: fmt.Sprintf("access token uid: %d, params uid: %d", claims.Uid, uid)}
+	}
+	return claims, nil
+}
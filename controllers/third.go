@@ -0,0 +1,324 @@
+/**
+ * @project Accounts
+ * @filename third.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/26 14:00
+ * @version 1.0
+ * @description
+ * 第三方授权码登录, 微信、QQ移动端SDK返回code, 服务端换取token及用户id后注册或登录
+ * 苹果服务端通知, 由苹果调用, 不校验app_id签名, 使用苹果公钥校验通知内容
+ * Facebook数据删除回调、Google安全事件, 地址上带 game_id、platform_id 区分项目
+ * 苹果登录、注册、绑定成功后换取刷新token加密保存, 不影响接口返回
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"accounts/models"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ThirdCodeLogin 第三方授权码登录, 账号不存在时注册, 返回格式与注册一致
+func ThirdCodeLogin(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	data := &base.ThirdCodeLoginFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	logHook.RequestBody = data
+	logHook.GameId = data.GameId
+	logHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	provider, ok := base.GetThirdProvider(data.ThirdId)
+	if !ok {
+		base.ResponseFail(resp, &base.MyError{Code: base.ThirdIdUnsupported, Log: fmt.Sprintf("third id: %d", data.ThirdId)}, userLog.Hook(logHook))
+		return
+	}
+	if !base.ThirdProviderEnabled(provider, data.GameId, data.PlatformId) {
+		base.ResponseFail(resp, &base.MyError{Code: base.ThirdProviderDisabled, Log: fmt.Sprintf("third %s not enabled for game %d-%d", provider.Name(), data.GameId, data.PlatformId)}, userLog.Hook(logHook))
+		return
+	}
+
+	//检查ip是否达到限制数量
+	err = base.LimitRegister(ip)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	//授权码换取token, 第三方uid由服务端得到
+	tokenInfo, err := provider.ExchangeCode(data.AuthCode, base.ThirdProviderGameConfig(provider, data.GameId, data.PlatformId))
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	registerData := &base.RegisterFields{
+		Account:      models.ResolveThirdAccount(provider.Id(), tokenInfo),
+		Code:         base.DefaultNoValue,
+		Password:     base.DefaultNoValue,
+		DeviceType:   data.DeviceType,
+		Type:         base.AccountThird,
+		Lang:         data.Lang,
+		ChannelId:    data.ChannelId,
+		DataExt:      data.DataExt,
+		CommonFields: data.CommonFields,
+	}
+	registerData.Account, err = base.CheckUserAccountFormat(registerData.Account, registerData.Type)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	session := &base.SessionInfo{DeviceType: data.DeviceType, Ip: ip, UserAgent: req.UserAgent()}
+	ret, err := models.AccountRegister(registerData, ip, session)
+	if err.Code != base.RegisterSuccess && err.Code != base.LoginSuccess && err.Code != base.LoginSecondFactorRequired {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	//保存token, 失败不影响登录
+	saveErr := models.SaveThirdToken(registerData.Account, provider.Id(), tokenInfo)
+	if saveErr != nil {
+		userLog.Err(saveErr).Msg("save third token error")
+	}
+
+	//需要二次验证
+	if err.Code == base.LoginSecondFactorRequired {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	dataLogId := "1314520"
+	dataLog := base.LoginDataLog
+	//注册成功后累加此ip的数量
+	if err.Code == base.RegisterSuccess {
+		dataLog = base.RegisterDataLog
+		dataLogId = "1314521"
+		err = base.LimitRegisterIncr(ip)
+		if err != nil {
+			userLog.Err(err).Msg("limit register incr error")
+		}
+	}
+
+	//数据写入
+	base.DataExtLog(ret.Uid, data.DataExt, dataLogId, ip, dataLog)
+
+	//成功返回
+	base.ResponseOK(resp, ret, userLog.Hook(logHook))
+	return
+}
+
+// 苹果账号用third_account中的authorization_code换取刷新token保存, 注销时撤销授权, 失败只记录日志
+func saveAppleRefreshToken(account, thirdAccount string, gameId, platformId int, userLog *zerolog.Logger) {
+	err := models.SaveAppleRefreshToken(account, thirdAccount, gameId, platformId)
+	if err != nil {
+		userLog.Err(err).Msg("save apple refresh token error")
+	}
+}
+
+// AppleNotification 苹果服务端通知(撤销授权、删除苹果账号、中转邮箱停用/启用)
+func AppleNotification(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	data := &base.AppleNotificationFields{}
+	err := base.RequestHandler(req, data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	logHook.RequestBody = data
+
+	event, err := base.ParseAppleNotification(data.Payload)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	userLog.Info().Interface("apple_event", event).Msg("")
+
+	err = models.AppleNotification(event, userLog)
+	if err != nil {
+		base.ReleaseThirdEvent(event.EventKey)
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "apple_notification").
+		Str("type", event.Type).
+		Str("sub", event.Sub).
+		Str("client_id", event.ClientId).
+		Str("ip", ip).
+		Msg("apple notification processed")
+	base.ResponseOK(resp, base.EmptyData, userLog.Hook(logHook))
+	return
+}
+
+// FacebookDataDeletion Facebook数据删除回调, 按注销流程添加注销申请, 返回进度查询地址及确认码
+// 地址: /third/facebookDataDeletion?game_id=16&platform_id=1, 使用项目在GameApps中配置的Facebook应用密钥校验签名
+func FacebookDataDeletion(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	if req.Method != "POST" {
+		base.ResponseFail(resp, &base.MyError{Code: base.NotPostRequest}, userLog.Hook(logHook))
+		return
+	}
+	err := base.LimitIp(ip, req.URL.Path, 0)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	gameId, _ := strconv.Atoi(req.URL.Query().Get("game_id"))
+	platformId, _ := strconv.Atoi(req.URL.Query().Get("platform_id"))
+	signedRequest := req.PostFormValue("signed_request")
+	logHook.GameId = gameId
+	logHook.RequestBody = req.PostForm
+	if gameId <= 0 || platformId <= 0 || signedRequest == "" {
+		base.ResponseFail(resp, &base.MyError{Code: base.ThirdDeletionParamsError, Log: fmt.Sprintf("game: %d-%d, signed request empty: %t", gameId, platformId, signedRequest == "")}, userLog.Hook(logHook))
+		return
+	}
+	if !base.GameUserDbExists(gameId, platformId) {
+		base.ResponseFail(resp, &base.MyError{Code: base.GameIdNotExists, Log: fmt.Sprintf("facebook data deletion game: %d-%d", gameId, platformId)}, userLog.Hook(logHook))
+		return
+	}
+
+	//只使用项目自己的应用密钥, 其他项目的signed_request不能在此项目中使用
+	provider, _ := base.GetThirdProvider(base.ThirdFacebook)
+	app, ok := base.ThirdProviderGameApp(provider, gameId, platformId)
+	if !ok {
+		base.ResponseFail(resp, &base.MyError{Code: base.ThirdDeletionAppNotConfigured, Log: fmt.Sprintf("facebook app of game %d-%d not configured", gameId, platformId)}, userLog.Hook(logHook))
+		return
+	}
+	signed, err := base.ParseFacebookSignedRequest(signedRequest, app.AppSecret)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	uid, err := models.ThirdDeletionApply(fmt.Sprintf("%d_%s", base.ThirdFacebook, signed.UserId), gameId, platformId, userLog)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	code := base.BuildDeletionCode(uid, gameId, platformId)
+	base.SecurityLog.Info().
+		Str("event", "facebook_data_deletion").
+		Int64("uid", uid).
+		Int("game_id", gameId).
+		Str("confirmation_code", code).
+		Str("ip", ip).
+		Msg("facebook data deletion requested")
+	oauthResponse(resp, http.StatusOK, &base.FacebookDeletionRespFields{
+		Url:              base.GConf.Third.DeletionStatusUrl + "?code=" + url.QueryEscape(code),
+		ConfirmationCode: code,
+	}, userLog)
+}
+
+// ThirdDeletionStatus 数据删除进度, 读取注销申请表的状态
+func ThirdDeletionStatus(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	code := req.URL.Query().Get("code")
+	logHook.RequestBody = code
+
+	err := base.LimitIp(ip, req.URL.Path, 0)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	uid, gameId, platformId, err := base.ParseDeletionCode(code)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	logHook.GameId = gameId
+	logHook.Uid = uid
+
+	status, err := models.GetDeleteApplyStatus(uid, gameId, platformId)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	status.ConfirmationCode = code
+	base.ResponseOK(resp, status, userLog.Hook(logHook))
+	return
+}
+
+// GoogleRiscEvent Google跨账号保护(RISC)安全事件, 请求体为Google签名的jwt, 成功返回202
+// 地址: /third/googleRiscEvent?game_id=16&platform_id=1, account-purged 事件在此项目中添加注销申请
+func GoogleRiscEvent(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	userLog := hlog.FromRequest(req)
+	ip := base.GetRealAddr(req).String()
+	logHook := base.RequestHook{IP: ip}
+	if req.Method != "POST" {
+		base.ResponseFail(resp, &base.MyError{Code: base.NotPostRequest}, userLog.Hook(logHook))
+		return
+	}
+	err := base.LimitIp(ip, req.URL.Path, 0)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	body, readErr := io.ReadAll(req.Body)
+	if readErr != nil {
+		base.ResponseFail(resp, &base.MyError{Code: base.RequestDataIncorrect, Log: "read body error: " + readErr.Error()}, userLog.Hook(logHook))
+		return
+	}
+	gameId, _ := strconv.Atoi(req.URL.Query().Get("game_id"))
+	platformId, _ := strconv.Atoi(req.URL.Query().Get("platform_id"))
+	logHook.GameId = gameId
+	if (gameId != 0 || platformId != 0) && !base.GameUserDbExists(gameId, platformId) {
+		base.ResponseFail(resp, &base.MyError{Code: base.GameIdNotExists, Log: fmt.Sprintf("google risc event game: %d-%d", gameId, platformId)}, userLog.Hook(logHook))
+		return
+	}
+
+	event, err := base.ParseGoogleRiscEvent(strings.TrimSpace(string(body)))
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+	logHook.RequestBody = event
+	err = models.GoogleRiscEvent(event, gameId, platformId, userLog)
+	if err != nil {
+		base.ReleaseThirdEvent(event.EventKey)
+		base.ResponseFail(resp, err, userLog.Hook(logHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "google_risc_event").
+		Str("type", event.Type).
+		Str("sub", event.Sub).
+		Str("reason", event.Reason).
+		Str("ip", ip).
+		Msg("google security event processed")
+	resp.Header().Del("StartTime")
+	resp.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,185 @@
+/**
+ * @project Accounts
+ * @filename totp.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/19 16:10
+ * @version 1.0
+ * @description
+ * 二次验证(TOTP), 生成密钥、确认启用、关闭, 及登录时的二次验证
+ */
+
+package controllers
+
+import (
+	"accounts/base"
+	"accounts/models"
+	"github.com/rs/zerolog/hlog"
+	"net/http"
+)
+
+// TotpEnroll 生成TOTP密钥, 客户端展示二维码, 用户在认证器App中添加后调用确认接口
+func TotpEnroll(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.TotpFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.TotpTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	ret, err := models.TotpEnroll(claims)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+	return
+}
+
+// TotpConfirm 确认启用二次验证, 返回一次性恢复码
+func TotpConfirm(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.TotpFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.TotpTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	codes, err := models.TotpConfirm(claims, data.Code)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "totp_enabled").
+		Int64("uid", data.Uid).
+		Int("game_id", data.GameId).
+		Str("ip", requestHook.IP).
+		Msg("two-factor authentication enabled")
+	base.ResponseOK(resp, &base.TotpConfirmRespFields{RecoveryCodes: codes}, userLog.Hook(requestHook))
+	return
+}
+
+// TotpDisable 关闭二次验证
+func TotpDisable(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.TotpFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	claims, err := accessTokenCheck(data.Token, data.Uid, base.TotpTokenTypeError)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	err = models.TotpDisable(claims, data.Code)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.SecurityLog.Info().
+		Str("event", "totp_disabled").
+		Int64("uid", data.Uid).
+		Int("game_id", data.GameId).
+		Str("ip", requestHook.IP).
+		Msg("two-factor authentication disabled")
+	base.ResponseOK(resp, base.EmptyData, userLog.Hook(requestHook))
+	return
+}
+
+// TotpLogin 登录二次验证, 登录返回需要二次验证时, 使用challenge_token及验证码或恢复码完成登录
+// 返回格式与登录一致
+func TotpLogin(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.TotpLoginFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	ret, err := models.TotpLogin(data)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+	return
+}
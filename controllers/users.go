@@ -15,9 +15,11 @@ import (
 	"accounts/base"
 	"accounts/models"
 	"fmt"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -53,6 +55,15 @@ func Register(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	//检查项目是否开启此第三方, 使用凭证校验, 第三方uid由服务端得到
+	if data.Type == base.AccountThird {
+		data.Account, err = base.VerifyThirdAccount(data.Account, data.ThirdAccount, data.GameId, data.PlatformId, models.ResolveThirdAccount)
+		if err != nil {
+			base.ResponseFail(resp, err, userLog.Hook(logHook))
+			return
+		}
+	}
+
 	//邮箱或手机号注册时，验证码和密码不允许都是空;
 	if (data.Type == base.AccountEmail || data.Type == base.AccountMobile) && data.Code == base.DefaultNoValue && data.Password == base.DefaultNoValue {
 		base.ResponseFail(resp, &base.MyError{Code: base.RegisterCodeAndPasswordEmpty}, userLog.Hook(logHook))
@@ -69,7 +80,7 @@ func Register(resp http.ResponseWriter, req *http.Request) {
 	//检查验证码
 	var codeKey string
 	if (data.Type == base.AccountMobile || data.Type == base.AccountEmail) && data.Code != base.DefaultNoValue {
-		codeKey = fmt.Sprintf(base.CodeFormat, base.CodeTypeRegister, data.Account)
+		codeKey = fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, base.CodeTypeRegister, data.Account)
 		err = models.CheckVerifyCode(codeKey, data.Code)
 		if err != nil {
 			base.ResponseFail(resp, err, userLog.Hook(logHook))
@@ -77,7 +88,8 @@ func Register(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	ret, err := models.AccountRegister(data, ip)
+	session := &base.SessionInfo{DeviceType: data.DeviceType, Ip: ip, UserAgent: req.UserAgent()}
+	ret, err := models.AccountRegister(data, ip, session)
 	if err.Code != base.RegisterSuccess && err.Code != base.LoginSuccess {
 		base.ResponseFail(resp, err, userLog.Hook(logHook))
 		return
@@ -100,6 +112,11 @@ func Register(resp http.ResponseWriter, req *http.Request) {
 		models.DeleteVerifyCode(codeKey)
 	}
 
+	//苹果账号换取刷新token保存
+	if data.Type == base.AccountThird {
+		go saveAppleRefreshToken(data.Account, data.ThirdAccount, data.GameId, data.PlatformId, userLog)
+	}
+
 	//数据写入
 	base.DataExtLog(ret.Uid, data.DataExt, dataLogId, ip, dataLog)
 
@@ -131,33 +148,58 @@ func Login(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	//检查账号格式
-	data.Account, err = base.CheckUserAccountFormat(data.Account, data.Type)
+	//登录接口无设备类型参数, 从请求头获取
+	deviceType, _ := strconv.Atoi(req.Header.Get(base.HeaderDeviceType))
+	session := &base.SessionInfo{DeviceType: deviceType, Ip: ip, UserAgent: req.UserAgent()}
+	ret, err := credentialLogin(data, ip, session, userLog)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(logHook))
 		return
 	}
 
+	//数据写入
+	base.DataExtLog(ret.Uid, data.DataExt, "1314520", ip, base.LoginDataLog)
+
+	//成功返回
+	base.ResponseOK(resp, ret, userLog.Hook(logHook))
+	return
+}
+
+// 账号密码或验证码登录, 供登录接口及OIDC授权共用
+// 包含账号格式检查、登录限制、验证码校验, 成功后删除已使用的验证码
+func credentialLogin(data *base.LoginFields, ip string, session *base.SessionInfo, userLog *zerolog.Logger) (*base.LoginReturnFields, *base.MyError) {
+	//检查账号格式
+	account, err := base.CheckUserAccountFormat(data.Account, data.Type)
+	if err != nil {
+		return nil, err
+	}
+	data.Account = account
+
+	//检查项目是否开启此第三方, 使用凭证校验, 第三方uid由服务端得到
+	if data.Type == base.AccountThird {
+		data.Account, err = base.VerifyThirdAccount(data.Account, data.ThirdAccount, data.GameId, data.PlatformId, models.ResolveThirdAccount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//邮箱或手机号登录时，验证码和密码不允许都是空;
 	if (data.Type == base.AccountEmail || data.Type == base.AccountMobile) && data.Code == base.DefaultNoValue && data.Password == base.DefaultNoValue {
-		base.ResponseFail(resp, &base.MyError{Code: base.LoginCodeAndPasswordEmpty}, userLog.Hook(logHook))
-		return
+		return nil, &base.MyError{Code: base.LoginCodeAndPasswordEmpty}
 	}
 
 	err = base.LimitLogin(ip)
 	if err != nil {
-		base.ResponseFail(resp, err, userLog.Hook(logHook))
-		return
+		return nil, err
 	}
 
 	//检查验证码
 	var codeKey string
 	if (data.Type == base.AccountMobile || data.Type == base.AccountEmail) && data.Code != base.DefaultNoValue {
-		codeKey = fmt.Sprintf(base.CodeFormat, base.CodeTypeLogin, data.Account)
+		codeKey = fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, base.CodeTypeLogin, data.Account)
 		err = models.CheckVerifyCode(codeKey, data.Code)
 		if err != nil {
-			base.ResponseFail(resp, err, userLog.Hook(logHook))
-			return
+			return nil, err
 		}
 	}
 
@@ -168,18 +210,23 @@ func Login(resp http.ResponseWriter, req *http.Request) {
 			userLog.Err(err).Msg("limit login incr error")
 		}
 		//此处的错误码为账号或密码错误，而不是账号不存在，防止利用登录探测账号是否存在
-		base.ResponseFail(resp, &base.MyError{Code: base.LoginUserOrPasswordError}, userLog.Hook(logHook))
-		return
+		return nil, &base.MyError{Code: base.LoginUserOrPasswordError}
 	}
 
-	ret, err := models.AccountLogin(data, accountUid)
+	ret, err := models.AccountLogin(data, accountUid, session)
+	//密码或验证码已通过, 需要二次验证, 返回challenge
+	if err.Code == base.LoginSecondFactorRequired {
+		if (data.Type == base.AccountMobile || data.Type == base.AccountEmail) && data.Code != base.DefaultNoValue {
+			models.DeleteVerifyCode(codeKey)
+		}
+		return nil, err
+	}
 	if err.Code != base.LoginSuccess {
 		limitErr := base.LimitLoginIncr(ip, data.Account)
 		if limitErr != nil {
 			userLog.Err(limitErr).Msg("limit login incr error")
 		}
-		base.ResponseFail(resp, err, userLog.Hook(logHook))
-		return
+		return nil, err
 	}
 
 	//删除已使用验证码
@@ -187,11 +234,74 @@ func Login(resp http.ResponseWriter, req *http.Request) {
 		models.DeleteVerifyCode(codeKey)
 	}
 
-	//数据写入
-	base.DataExtLog(ret.Uid, data.DataExt, "1314520", ip, base.LoginDataLog)
+	//苹果账号换取刷新token保存
+	if data.Type == base.AccountThird {
+		go saveAppleRefreshToken(data.Account, data.ThirdAccount, data.GameId, data.PlatformId, userLog)
+	}
 
-	//成功返回
-	base.ResponseOK(resp, ret, userLog.Hook(logHook))
+	return ret, nil
+}
+
+// RefreshToken 使用刷新token换取新的登录token和刷新token
+// 返回格式与登录、注册返回的tokens一致
+func RefreshToken(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+
+	data := &base.RefreshTokenFields{}
+	err := base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeSdk)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	//检查token解析，是否过期
+	claims, err := base.ParseLoginToken(data.RefreshToken)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	if claims.TokenType != base.TokenTypeRefresh {
+		base.ResponseFail(resp, &base.MyError{Code: base.RefreshTokenTypeError, Log: fmt.Sprintf("token type: %d", claims.TokenType)}, userLog.Hook(requestHook))
+		return
+	}
+	if claims.Uid != data.Uid {
+		base.ResponseFail(resp, &base.MyError{Code: base.RefreshTokenUidNotMatch, Log: fmt.Sprintf("refresh token uid: %d, params uid: %d", claims.Uid, data.Uid)}, userLog.Hook(requestHook))
+		return
+	}
+	if claims.GameId != data.GameId || claims.PlatformId != data.PlatformId {
+		base.ResponseFail(resp, &base.MyError{Code: base.RefreshTokenGameNotMatch, Log: fmt.Sprintf("refresh token game: %d-%d, params game: %d-%d", claims.GameId, claims.PlatformId, data.GameId, data.PlatformId)}, userLog.Hook(requestHook))
+		return
+	}
+
+	//刷新token只能使用一次, 重复使用则撤销整个token族
+	err = base.UseRefreshToken(claims, data.RefreshToken, requestHook.IP)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	tokens, err := models.RefreshLoginToken(claims)
+	if err != nil {
+		//未签发新token, 原刷新token仍可使用
+		base.ReleaseRefreshToken(claims, data.RefreshToken)
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	base.ResponseOK(resp, tokens, userLog.Hook(requestHook))
 	return
 }
 
@@ -228,7 +338,7 @@ func ForgetPassword(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查验证码
-	codeKey := fmt.Sprintf(base.CodeFormat, base.CodeTypeForgetPassword, data.Account)
+	codeKey := fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, base.CodeTypeForgetPassword, data.Account)
 	err = models.CheckVerifyCode(codeKey, data.Code)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
@@ -275,7 +385,7 @@ func ChangePassword(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.LoginToken, data.Uid)
+	err = loginTokenCheck(data.LoginToken, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -333,11 +443,24 @@ func SendVerifyCode(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	codeRand := base.GetRandom(6)
-	codeKey := fmt.Sprintf(base.CodeFormat, data.CodeType, data.Account)
+	codeRand, randErr := base.GetRandom(6)
+	if randErr != nil {
+		base.ResponseFail(resp, &base.MyError{Code: base.VerifyCodeInsertError, Log: "generate code error: " + randErr.Error()}, userLog.Hook(requestHook))
+		return
+	}
+	codeKey := fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, data.CodeType, data.Account)
+	tplVars := base.NewTemplateVars(codeRand, data.Account, ip, data.GameId, data.PlatformId)
+	ret := &base.SendCodeReturnFields{}
 	//账号类型为 邮件方式
 	if data.Type == base.AccountEmail {
-		tplConfig, err := models.MailTplConfig(data)
+		//苹果中转邮箱停用转发后不再发送
+		err = base.CheckEmailDeliverable(data.Account)
+		if err != nil {
+			base.ResponseFail(resp, err, userLog.Hook(requestHook))
+			return
+		}
+		//按语言回退顺序查找模板并渲染
+		mailTpl, err := base.RenderMailTpl(data.CodeType, data.LangId, tplVars)
 		if err != nil {
 			base.ResponseFail(resp, err, userLog.Hook(requestHook))
 			return
@@ -348,9 +471,12 @@ func SendVerifyCode(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		tplConfig.Content = strings.Replace(tplConfig.Content, "{CODE}", codeRand, -1)
-		fmt.Printf("tplContent: %+v\n", tplConfig)
-		go base.SendMail(&base.GConf.MailConfig, tplConfig, data.Account, userLog) //单元测试时，协程去掉
+		//写入发送队列, 返回mail_id用于查询发送状态
+		ret.MailId, err = base.QueueMail(mailTpl, data.Account, data.GameId, data.PlatformId)
+		if err != nil {
+			base.ResponseFail(resp, err, userLog.Hook(requestHook))
+			return
+		}
 		err = base.LimitVerifyCodeIncr(ip, data.Account)
 		if err != nil {
 			userLog.Err(err).Msg("limit verify code incr error")
@@ -358,13 +484,15 @@ func SendVerifyCode(resp http.ResponseWriter, req *http.Request) {
 	}
 	//短信方式
 	if data.Type == base.AccountMobile {
-		smsConfig, err := models.SmsTplConfig(data)
-		if err != nil {
-			base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		smsConfig := base.SmsTemplates(data.CodeType, data.LangId)
+		if len(smsConfig) == 0 {
+			base.ResponseFail(resp, &base.MyError{Code: base.SmsTplConfigEmpty}, userLog.Hook(requestHook))
 			return
 		}
-		if smsConfig == nil {
-			base.ResponseFail(resp, &base.MyError{Code: base.SmsTplConfigEmpty}, userLog.Hook(requestHook))
+		//按国家码选择服务商
+		providers, err := base.SmsRoute(data.Account, smsConfig)
+		if err != nil {
+			base.ResponseFail(resp, err, userLog.Hook(requestHook))
 			return
 		}
 
@@ -374,14 +502,14 @@ func SendVerifyCode(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		code := fmt.Sprintf("{\"code\":\"%s\"}", codeRand)
-		go base.AlibabaSmsSend(data.Account, smsConfig.SmsId, smsConfig.Title, code, userLog) //单元测试时，协程去掉
+		go base.SendSms(data.Account, tplVars, providers, smsConfig, userLog) //单元测试时，协程去掉
+		err = base.LimitVerifyCodeIncr(ip, data.Account)
 		if err != nil {
 			userLog.Err(err).Msg("limit verify code incr error")
 		}
 	}
 
-	base.ResponseOK(resp, base.EmptyData, userLog.Hook(requestHook))
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
 	return
 }
 
@@ -417,8 +545,17 @@ func BindAccount(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	//检查项目是否开启此第三方, 使用凭证校验, 第三方uid由服务端得到
+	if data.Type == base.AccountThird {
+		data.BindAccount, err = base.VerifyThirdAccount(data.BindAccount, data.ThirdAccount, data.GameId, data.PlatformId, models.ResolveThirdAccount)
+		if err != nil {
+			base.ResponseFail(resp, err, userLog.Hook(requestHook))
+			return
+		}
+	}
+
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.LoginToken, data.Uid)
+	err = loginTokenCheck(data.LoginToken, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -427,7 +564,7 @@ func BindAccount(resp http.ResponseWriter, req *http.Request) {
 	//检查验证码
 	var codeKey string
 	if data.Type != base.AccountThird {
-		codeKey = fmt.Sprintf(base.CodeFormat, base.CodeTypeBindAccount, data.BindAccount)
+		codeKey = fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, base.CodeTypeBindAccount, data.BindAccount)
 		err = models.CheckVerifyCode(codeKey, data.Code)
 		if err != nil {
 			base.ResponseFail(resp, err, userLog.Hook(requestHook))
@@ -446,6 +583,11 @@ func BindAccount(resp http.ResponseWriter, req *http.Request) {
 		models.DeleteVerifyCode(codeKey)
 	}
 
+	//苹果账号换取刷新token保存
+	if data.Type == base.AccountThird {
+		go saveAppleRefreshToken(data.BindAccount, data.ThirdAccount, data.GameId, data.PlatformId, userLog)
+	}
+
 	ret, err := models.GetAccountBindsInfo(data.Account, data.GameId, data.PlatformId)
 	if err != nil {
 		userLog.Info().Err(err).Msg("get already bind error")
@@ -494,7 +636,7 @@ func UnBindAccount(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.LoginToken, data.Uid)
+	err = loginTokenCheck(data.LoginToken, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -503,7 +645,7 @@ func UnBindAccount(resp http.ResponseWriter, req *http.Request) {
 	//检查验证码
 	var codeKey string
 	if data.Type != base.AccountThird {
-		codeKey = fmt.Sprintf(base.CodeFormat, base.CodeTypeUnBindAccount, data.UnBindAccount)
+		codeKey = fmt.Sprintf(base.CodeFormat, data.GameId, data.AppId, base.CodeTypeUnBindAccount, data.UnBindAccount)
 		err = models.CheckVerifyCode(codeKey, data.Code)
 		if err != nil {
 			base.ResponseFail(resp, err, userLog.Hook(requestHook))
@@ -532,20 +674,13 @@ func LoginAuth(resp http.ResponseWriter, req *http.Request) {
 	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
 	userLog := hlog.FromRequest(req)
 	//白名单
-	if base.GConf.RequestLimitRule.Enabled && len(base.GConf.RequestLimitRule.LoginAuthWhiteListMap) > 0 {
-		domain := req.URL.Host
-		if strings.Index(domain, ":") != -1 {
-			domains := strings.Split(domain, ":")
-			domain = domains[0]
-		}
-		if _, ok := base.GConf.RequestLimitRule.LoginAuthWhiteListMap[domain]; !ok {
-			//fmt.Printf("request domain: %s, host:%s\n", domain, req.URL.Host)
-			base.ResponseFail(resp, &base.MyError{Code: base.LoginAuthDomainNotWhiteList, Log: "request domain:" + domain}, userLog.Hook(requestHook))
-			return
-		}
+	err := loginAuthWhiteListCheck(req)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
 	}
 	data := &base.LoginAuthFields{}
-	err := base.RequestHandler(req, data)
+	err = base.RequestHandler(req, data)
 	requestHook.RequestBody = data
 	requestHook.GameId = data.GameId
 	requestHook.Uid = data.Uid
@@ -563,7 +698,7 @@ func LoginAuth(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.Token, data.Uid)
+	err = loginTokenCheck(data.Token, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -573,6 +708,123 @@ func LoginAuth(resp http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// LoginAuthV2 服务器登录校验v2, 参考token introspection
+// token无效时仍返回成功, 由active及error_code说明原因, 同时返回token内的信息及项目用户当前状态
+func LoginAuthV2(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("StartTime", base.GetUnixMilliString())
+	requestHook := base.RequestHook{IP: base.GetRealAddr(req).String()}
+	userLog := hlog.FromRequest(req)
+	//白名单
+	err := loginAuthWhiteListCheck(req)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	data := &base.LoginAuthFields{}
+	err = base.RequestHandler(req, data)
+	requestHook.RequestBody = data
+	requestHook.GameId = data.GameId
+	requestHook.Uid = data.Uid
+	requestHook.HeaderGamePlatform = req.Header.Get(base.HeaderGamePlatform)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+	userLog.Info().Interface("req_body", data).Msg("")
+
+	err = base.SignValidator(data.AppId, data.Sign, data.GameId, data, base.AppIdTypeClient)
+	if err != nil {
+		base.ResponseFail(resp, err, userLog.Hook(requestHook))
+		return
+	}
+
+	ret := &base.LoginAuthV2RespFields{}
+	claims, err := base.ParseLoginToken(data.Token)
+	if err != nil {
+		userLog.Info().Int("error_code", err.Code).Msg(err.Log)
+		ret.ErrorCode = err.Code
+		base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+		return
+	}
+	ret.Claims = &base.LoginAuthClaimsFields{
+		GameId:     claims.GameId,
+		PlatformId: claims.PlatformId,
+		ChannelId:  claims.ChannelId,
+		Uid:        claims.Uid,
+		LoginTime:  claims.LoginTime,
+		TokenType:  claims.TokenType,
+		Jti:        claims.Id,
+		IssuedAt:   claims.IssuedAt,
+		ExpiresAt:  claims.ExpiresAt,
+	}
+
+	//刷新token不能当作登录token使用, 也不接受其他项目、大区签发的token
+	switch {
+	case claims.TokenType != base.TokenTypeAccess:
+		ret.ErrorCode = base.LoginAuthRefreshToken
+	case claims.GameId != data.GameId || claims.PlatformId != data.PlatformId:
+		ret.ErrorCode = base.LoginAuthGameNotMatch
+	case claims.Uid != data.Uid:
+		ret.ErrorCode = base.LoginTokenUidUnequal
+	}
+	if ret.ErrorCode != 0 {
+		base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+		return
+	}
+
+	//项目用户当前状态, 登录后可能已被禁用或申请注销
+	ret.Status, err = models.GetGameUserStatus(claims.Uid, claims.GameId, claims.PlatformId)
+	if err != nil {
+		if err.Code != base.LoginAuthUserNotExists {
+			base.ResponseFail(resp, err, userLog.Hook(requestHook))
+			return
+		}
+		ret.ErrorCode = err.Code
+		base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+		return
+	}
+	switch ret.Status {
+	case base.AccountNormal:
+		ret.Active = true
+	case base.AccountDisabled:
+		ret.ErrorCode = base.LoginAccountDisabled
+		//已被禁用, 撤销所有会话
+		err = base.RevokeAllSessions(base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId))
+		if err != nil {
+			userLog.Error().Int64("uid", claims.Uid).Msg(err.Log)
+		}
+	default:
+		ret.ErrorCode = base.AccountIsBeingDeleted
+	}
+
+	base.ResponseOK(resp, ret, userLog.Hook(requestHook))
+	return
+}
+
+// 检查登录token, 项目用户已被禁用时撤销其所有会话, 已签发的token不能继续使用
+func loginTokenCheck(loginToken string, uid int64) *base.MyError {
+	claims, err := base.LoginTokenCheck(loginToken, uid)
+	if err != nil {
+		return err
+	}
+	return models.CheckGameUserEnabled(claims.Uid, claims.GameId, claims.PlatformId)
+}
+
+// 服务器登录校验的域名白名单
+func loginAuthWhiteListCheck(req *http.Request) *base.MyError {
+	if base.GConf.RequestLimitRule.Enabled && len(base.GConf.RequestLimitRule.LoginAuthWhiteListMap) > 0 {
+		domain := req.URL.Host
+		if strings.Index(domain, ":") != -1 {
+			domains := strings.Split(domain, ":")
+			domain = domains[0]
+		}
+		if _, ok := base.GConf.RequestLimitRule.LoginAuthWhiteListMap[domain]; !ok {
+			return &base.MyError{Code: base.LoginAuthDomainNotWhiteList, Log: "request domain:" + domain}
+		}
+	}
+	return nil
+}
+
 // WhiteList 白名单校验，客户端直接使用
 func WhiteList(resp http.ResponseWriter, req *http.Request) {
 	resp.Header().Set("StartTime", base.GetUnixMilliString())
@@ -627,7 +879,7 @@ func ApplyLogout(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.Token, data.Uid)
+	err = loginTokenCheck(data.Token, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -669,7 +921,7 @@ func UndoLogout(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.Token, data.Uid)
+	err = loginTokenCheck(data.Token, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -710,7 +962,7 @@ func GetUserInfo(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.Token, data.Uid)
+	err = loginTokenCheck(data.Token, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -751,7 +1003,7 @@ func RealNameAuth(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	//检查登录token内的uid与当前绑定的uid是否一致
-	err = base.LoginTokenCheck(data.Token, data.Uid)
+	err = loginTokenCheck(data.Token, data.Uid)
 	if err != nil {
 		base.ResponseFail(resp, err, userLog.Hook(requestHook))
 		return
@@ -0,0 +1,136 @@
+/**
+ * @project Accounts
+ * @filename limiter.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/28 15:00
+ * @version 1.0
+ * @description
+ * 滑动窗口限流, 基于Redis有序集合及Lua脚本
+ * 每次检查并消耗在一个脚本中完成, 只有一次往返, 不会出现key丢失过期时间的情况
+ * 集合中只保存窗口内被允许的请求, 成员数不超过limit; 时间使用Redis服务器时间, 多台服务器时钟不一致时不影响
+ */
+
+package limiter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 检查并消耗, 返回 {是否允许, 窗口内已用次数, 距离可以再次请求的微秒数, 距离窗口清空的微秒数}
+// cost为0时只查询, 是否允许表示下一次请求是否允许; 旧版本的计数key为string类型, 先删除
+var allowScript = redis.NewScript(`
+redis.replicate_commands()
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local member = ARGV[4]
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local keyType = redis.call('TYPE', key)['ok']
+if keyType ~= 'zset' and keyType ~= 'none' then
+	redis.call('DEL', key)
+end
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if cost == 0 then
+	if count < limit then
+		allowed = 1
+	end
+elseif count + cost <= limit then
+	allowed = 1
+	for i = 1, cost do
+		redis.call('ZADD', key, now, member .. ':' .. i)
+	end
+	count = count + cost
+end
+local retryAfter = 0
+local resetAfter = 0
+if count > 0 then
+	local need = count + math.max(cost, 1) - limit
+	if need > 0 then
+		local index = math.min(need, count) - 1
+		local oldest = redis.call('ZRANGE', key, index, index, 'WITHSCORES')
+		retryAfter = tonumber(oldest[2]) + window - now
+	end
+	local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+	resetAfter = tonumber(newest[2]) + window - now
+	redis.call('PEXPIRE', key, math.ceil(resetAfter / 1000))
+end
+return {allowed, count, retryAfter, resetAfter}
+`)
+
+// 限流结果
+type Result struct {
+	Allowed    bool          //是否允许
+	Limit      int64         //窗口内允许的次数
+	Count      int64         //窗口内已用次数, 包含本次
+	Remaining  int64         //窗口内剩余次数
+	RetryAfter time.Duration //剩余次数为0时, 距离可以再次请求的时间
+	ResetAfter time.Duration //距离窗口内全部请求过期, 次数完全恢复的时间
+}
+
+// 滑动窗口限流
+type Limiter struct {
+	client *redis.Client
+}
+
+// New 使用已有的Redis连接创建限流
+func New(client *redis.Client) *Limiter {
+	return &Limiter{client: client}
+}
+
+// Allow 检查并消耗1次
+func (l *Limiter) Allow(key string, limit int64, window time.Duration) (*Result, error) {
+	return l.AllowN(key, limit, window, 1)
+}
+
+// AllowN 检查并消耗n次, 剩余次数不足时不消耗
+func (l *Limiter) AllowN(key string, limit int64, window time.Duration, n int64) (*Result, error) {
+	if limit <= 0 || window <= 0 || n < 0 {
+		return nil, fmt.Errorf("limiter %s invalid limit: %d, window: %s, n: %d", key, limit, window, n)
+	}
+	member := make([]byte, 8)
+	_, _ = rand.Read(member)
+	values, err := allowScript.Run(l.client, []string{key}, window.Microseconds(), limit, n, hex.EncodeToString(member)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("limiter %s run script error: %s", key, err.Error())
+	}
+	ret, ok := values.([]interface{})
+	if !ok || len(ret) != 4 {
+		return nil, fmt.Errorf("limiter %s script result error: %v", key, values)
+	}
+	nums := make([]int64, 4)
+	for i, value := range ret {
+		nums[i], _ = value.(int64)
+	}
+	remaining := limit - nums[1]
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &Result{
+		Allowed:    nums[0] == 1,
+		Limit:      limit,
+		Count:      nums[1],
+		Remaining:  remaining,
+		RetryAfter: time.Duration(nums[2]) * time.Microsecond,
+		ResetAfter: time.Duration(nums[3]) * time.Microsecond,
+	}, nil
+}
+
+// Peek 查询剩余次数, 不消耗
+func (l *Limiter) Peek(key string, limit int64, window time.Duration) (*Result, error) {
+	return l.AllowN(key, limit, window, 0)
+}
+
+// Reset 清空计数
+func (l *Limiter) Reset(key string) error {
+	return l.client.Del(key).Err()
+}
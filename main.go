@@ -24,6 +24,9 @@ func main() {
 	//定时刷新, 将数据加载到内存
 	controllers.TimingRefresh()
 
+	//邮件发送队列
+	base.StartMailQueue()
+
 	//加载路由以及启动服务
 	routers.InitRouterService()
 }
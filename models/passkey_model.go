@@ -0,0 +1,228 @@
+/**
+ * @project Accounts
+ * @filename passkey_model.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/21 15:00
+ * @version 1.0
+ * @description
+ * 通行密钥model, 凭证存储在passkey表(与主账号表相同分表), hash表中记录 pk_凭证id 到主账号uid 的映射
+ */
+
+package models
+
+import (
+	"accounts/base"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+)
+
+// PasskeyRegisterOptions 生成注册选项, 已注册的凭证放入excludeCredentials, 避免同一认证器重复注册
+func PasskeyRegisterOptions(claims *base.CustomClaims) (*base.PasskeyCreationOptions, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	querySql := fmt.Sprintf("SELECT credential_id FROM %s WHERE uid = ?", dbTable.PasskeyTable)
+	rows, err := dbTable.AccountSlaveDb.Query(querySql, mainUid)
+	if err != nil {
+		return nil, &base.MyError{Code: base.PasskeyQueryError, Log: fmt.Sprintf("query passkey, main uid: %d, error: %s", mainUid, err.Error())}
+	}
+	defer rows.Close()
+	excludeIds := []string{}
+	for rows.Next() {
+		var credentialId string
+		if rows.Scan(&credentialId) == nil {
+			excludeIds = append(excludeIds, credentialId)
+		}
+	}
+
+	challenge, myErr := base.SaveWebauthnChallenge(&base.WebauthnChallengeInfo{
+		Type:       base.WebauthnTypeCreate,
+		MainUid:    mainUid,
+		GameId:     claims.GameId,
+		PlatformId: claims.PlatformId,
+	})
+	if myErr != nil {
+		return nil, myErr
+	}
+	return base.GetPasskeyCreationOptions(challenge, claims.Uid, excludeIds), nil
+}
+
+// PasskeyRegister 注册通行密钥, 返回凭证id
+func PasskeyRegister(claims *base.CustomClaims, data *base.PasskeyRegisterFields) (string, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+
+	clientDataJson, err := base.WebauthnDecode(data.ClientDataJson)
+	if err != nil {
+		return "", &base.MyError{Code: base.PasskeyClientDataError, Log: "client data decode error: " + err.Error()}
+	}
+	challenge, myErr := base.CheckWebauthnClientData(clientDataJson, base.WebauthnTypeCreate)
+	if myErr != nil {
+		return "", myErr
+	}
+	if challenge.MainUid != mainUid {
+		return "", &base.MyError{Code: base.PasskeyChallengeInvalid, Log: fmt.Sprintf("challenge main uid: %d, token main uid: %d", challenge.MainUid, mainUid)}
+	}
+
+	authDataBytes, err := base.WebauthnDecode(data.AuthenticatorData)
+	if err != nil {
+		return "", &base.MyError{Code: base.PasskeyAuthDataError, Log: "authenticator data decode error: " + err.Error()}
+	}
+	authData, myErr := base.ParseWebauthnAuthData(authDataBytes, true)
+	if myErr != nil {
+		return "", myErr
+	}
+	publicKey, err := base.WebauthnDecode(data.PublicKey)
+	if err != nil {
+		return "", &base.MyError{Code: base.PasskeyPublicKeyError, Log: "public key decode error: " + err.Error()}
+	}
+	myErr = base.CheckPasskeyPublicKey(publicKey, data.PublicKeyAlgorithm)
+	if myErr != nil {
+		return "", myErr
+	}
+	//客户端传入的公钥必须与认证器数据中的凭证公钥一致, 不能由客户端指定
+	if !bytes.Equal(publicKey, authData.PublicKey) || data.PublicKeyAlgorithm != authData.Algorithm {
+		return "", &base.MyError{Code: base.PasskeyPublicKeyError, Log: fmt.Sprintf("public key not match authenticator data, algorithm: %d, %d", data.PublicKeyAlgorithm, authData.Algorithm)}
+	}
+
+	//先写hash表, 凭证id唯一
+	account := base.PasskeyAccount(authData.CredentialId)
+	credentialId := base64.RawURLEncoding.EncodeToString(authData.CredentialId)
+	hashDbTable := base.GetHashDbTable(account)
+	insertHash := fmt.Sprintf("INSERT INTO %s(account, uid) VALUES(?,?)", hashDbTable.AccountHashTable)
+	_, err = hashDbTable.AccountMasterDb.Exec(insertHash, account, mainUid)
+	if err != nil {
+		return "", &base.MyError{Code: base.PasskeyExists, Log: fmt.Sprintf("insert passkey hash %s error: %s", account, err.Error())}
+	}
+
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+	currTime := base.GetTime()
+	insertPasskey := fmt.Sprintf("INSERT INTO %s (credential_id, uid, public_key, algorithm, sign_count, `name`, created_time) VALUES (?,?,?,?,?,?,?)", dbTable.PasskeyTable)
+	_, err = dbTable.AccountMasterDb.Exec(insertPasskey, credentialId, mainUid, publicKey, data.PublicKeyAlgorithm, authData.SignCount, data.Name, currTime)
+	if err != nil {
+		deleteSql := fmt.Sprintf("DELETE FROM %s WHERE account = ?", hashDbTable.AccountHashTable)
+		hashDbTable.AccountMasterDb.Exec(deleteSql, account)
+		return "", &base.MyError{Code: base.PasskeySaveError, Log: fmt.Sprintf("insert passkey, main uid: %d, error: %s", mainUid, err.Error())}
+	}
+	return credentialId, nil
+}
+
+// PasskeyLoginOptions 生成登录选项
+func PasskeyLoginOptions(gameId, platformId int) (*base.PasskeyRequestOptions, *base.MyError) {
+	challenge, err := base.SaveWebauthnChallenge(&base.WebauthnChallengeInfo{
+		Type:       base.WebauthnTypeGet,
+		GameId:     gameId,
+		PlatformId: platformId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return base.GetPasskeyRequestOptions(challenge), nil
+}
+
+// PasskeyLogin 通行密钥登录, 签名校验通过后按主账号登录
+func PasskeyLogin(data *base.PasskeyLoginFields, session *base.SessionInfo) (*base.LoginReturnFields, *base.MyError) {
+	clientDataJson, err := base.WebauthnDecode(data.ClientDataJson)
+	if err != nil {
+		return nil, &base.MyError{Code: base.PasskeyClientDataError, Log: "client data decode error: " + err.Error()}
+	}
+	challenge, myErr := base.CheckWebauthnClientData(clientDataJson, base.WebauthnTypeGet)
+	if myErr != nil {
+		return nil, myErr
+	}
+	if challenge.GameId != data.GameId || challenge.PlatformId != data.PlatformId {
+		return nil, &base.MyError{Code: base.PasskeyGameNotMatch, Log: fmt.Sprintf("challenge game: %d-%d, params game: %d-%d", challenge.GameId, challenge.PlatformId, data.GameId, data.PlatformId)}
+	}
+
+	credentialIdBytes, err := base.WebauthnDecode(data.CredentialId)
+	if err != nil || len(credentialIdBytes) == 0 {
+		return nil, &base.MyError{Code: base.PasskeyCredentialIdError, Log: "credential id decode error"}
+	}
+	mainUid := GetAccountUid(base.PasskeyAccount(credentialIdBytes))
+	if mainUid == 0 {
+		return nil, &base.MyError{Code: base.PasskeyNotExists, Log: "credential id: " + data.CredentialId}
+	}
+
+	dbTable := base.GetDbTable(mainUid, data.GameId, data.PlatformId)
+	credential := &base.PasskeyCredential{CredentialId: base64.RawURLEncoding.EncodeToString(credentialIdBytes), MainUid: mainUid}
+	querySql := fmt.Sprintf("SELECT public_key, algorithm, sign_count FROM %s WHERE credential_id = ? AND uid = ?", dbTable.PasskeyTable)
+	err = dbTable.AccountMasterDb.QueryRow(querySql, credential.CredentialId, mainUid).Scan(&credential.PublicKey, &credential.Algorithm, &credential.SignCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &base.MyError{Code: base.PasskeyNotExists, Log: fmt.Sprintf("passkey %s not in table, main uid: %d", credential.CredentialId, mainUid)}
+		}
+		return nil, &base.MyError{Code: base.PasskeyQueryError, Log: fmt.Sprintf("query passkey %s error: %s", credential.CredentialId, err.Error())}
+	}
+
+	authDataBytes, err := base.WebauthnDecode(data.AuthenticatorData)
+	if err != nil {
+		return nil, &base.MyError{Code: base.PasskeyAuthDataError, Log: "authenticator data decode error: " + err.Error()}
+	}
+	authData, myErr := base.ParseWebauthnAuthData(authDataBytes, false)
+	if myErr != nil {
+		return nil, myErr
+	}
+	signature, err := base.WebauthnDecode(data.Signature)
+	if err != nil {
+		return nil, &base.MyError{Code: base.PasskeySignatureError, Log: "signature decode error: " + err.Error()}
+	}
+	myErr = base.VerifyPasskeySignature(credential, authDataBytes, clientDataJson, signature)
+	if myErr != nil {
+		return nil, myErr
+	}
+	myErr = base.CheckPasskeySignCount(credential, authData.SignCount)
+	if myErr != nil {
+		return nil, myErr
+	}
+
+	updateSql := fmt.Sprintf("UPDATE %s SET sign_count = ?, last_used_time = ? WHERE credential_id = ?", dbTable.PasskeyTable)
+	_, err = dbTable.AccountMasterDb.Exec(updateSql, authData.SignCount, base.GetTime(), credential.CredentialId)
+	if err != nil {
+		return nil, &base.MyError{Code: base.PasskeySaveError, Log: fmt.Sprintf("update passkey %s sign count error: %s", credential.CredentialId, err.Error())}
+	}
+
+	//按主账号注册时的账号登录, 通行密钥类型不比对密码
+	account, myErr := getMainAccount(dbTable, mainUid)
+	if myErr != nil {
+		return nil, myErr
+	}
+	loginFields := &base.LoginFields{
+		Account:   account,
+		Code:      base.DefaultNoValue,
+		Password:  base.DefaultNoValue,
+		Type:      base.AccountPasskey,
+		ChannelId: data.ChannelId,
+		CommonFields: base.CommonFields{
+			GameId:     data.GameId,
+			PlatformId: data.PlatformId,
+		},
+	}
+	ret, myErr := AccountLogin(loginFields, mainUid, session)
+	if myErr.Code != base.LoginSuccess {
+		return nil, myErr
+	}
+	return ret, nil
+}
+
+// 主账号注册时使用的账号
+func getMainAccount(dbTable *base.DbTable, mainUid int64) (string, *base.MyError) {
+	var accountType int
+	accounts := map[int]string{}
+	var email, mobile, username, guest, third string
+	querySql := fmt.Sprintf("SELECT type, IFNULL(email, ''), IFNULL(mobile, ''), IFNULL(username, ''), IFNULL(guest, ''), IFNULL(third, '') FROM %s WHERE uid = ?", dbTable.AccountTable)
+	err := dbTable.AccountSlaveDb.QueryRow(querySql, mainUid).Scan(&accountType, &email, &mobile, &username, &guest, &third)
+	if err != nil {
+		return "", &base.MyError{Code: base.AccountLoginException, Log: fmt.Sprintf("query main account, uid: %d, error: %s", mainUid, err.Error())}
+	}
+	accounts[base.AccountEmail] = email
+	accounts[base.AccountMobile] = mobile
+	accounts[base.AccountUsername] = username
+	accounts[base.AccountGuest] = guest
+	accounts[base.AccountThird] = third
+	if accounts[accountType] == "" {
+		return "", &base.MyError{Code: base.AccountLoginException, Log: fmt.Sprintf("main account uid: %d, type %d account empty", mainUid, accountType)}
+	}
+	return accounts[accountType], nil
+}
@@ -58,6 +58,79 @@ func RefreshHoliday() {
 	return
 }
 
+// RefreshTemplate 读取mail_tpl、sms_tpl表数据, 替换内存中的模板; 查询失败时保留已加载的模板
+func RefreshTemplate() {
+	log.Info().Msg("RefreshTemplate to memory start")
+	mailSql := fmt.Sprintf("SELECT `type`, lang_id, title, content FROM %s", base.MailTplTable)
+	rows, err := base.AccountBaseDb.Query(mailSql)
+	if err != nil {
+		log.Error().Msgf("query mail template error: %s", err.Error())
+		return
+	}
+	defer rows.Close()
+	mailTpls := []*base.MailTpl{}
+	for rows.Next() {
+		tpl := &base.MailTpl{}
+		err = rows.Scan(&tpl.Type, &tpl.LangId, &tpl.Title, &tpl.Content)
+		if err != nil {
+			log.Error().Msgf("scan mail template error: %s", err.Error())
+			continue
+		}
+		mailTpls = append(mailTpls, tpl)
+	}
+
+	smsSql := fmt.Sprintf("SELECT `type`, lang_id, sms_id, title, provider FROM %s", base.SmsTplTable)
+	smsRows, err := base.AccountBaseDb.Query(smsSql)
+	if err != nil {
+		log.Error().Msgf("query sms template error: %s", err.Error())
+		return
+	}
+	defer smsRows.Close()
+	smsTpls := []*base.SmsTpl{}
+	for smsRows.Next() {
+		tpl := &base.SmsTpl{}
+		err = smsRows.Scan(&tpl.Type, &tpl.LangId, &tpl.SmsId, &tpl.Title, &tpl.Provider)
+		if err != nil {
+			log.Error().Msgf("scan sms template error: %s", err.Error())
+			continue
+		}
+		smsTpls = append(smsTpls, tpl)
+	}
+
+	for _, parseErr := range base.StoreMailTemplates(mailTpls) {
+		log.Error().Msgf("parse mail template error: %s", parseErr.Error())
+	}
+	base.StoreSmsTemplates(smsTpls)
+	log.Info().Msgf("RefreshTemplate, mail templates: %d, sms templates: %d", len(mailTpls), len(smsTpls))
+}
+
+// RefreshIpAccessList 读取ip_access_list表中未过期的记录, 与配置中的名单合并后替换内存中的名单; 查询失败时保留已加载的名单
+func RefreshIpAccessList() {
+	log.Info().Msg("RefreshIpAccessList to memory start")
+	querySql := fmt.Sprintf("SELECT cidr, `type`, expire_time FROM %s WHERE expire_time = 0 OR expire_time > ?", base.IpAccessListTable)
+	rows, err := base.AccountBaseDb.Query(querySql, time.Now().Unix())
+	if err != nil {
+		log.Error().Msgf("query ip access list error: %s", err.Error())
+		return
+	}
+	defer rows.Close()
+	records := []*base.IpAccess{}
+	for rows.Next() {
+		record := &base.IpAccess{}
+		err = rows.Scan(&record.Cidr, &record.Type, &record.ExpireTime)
+		if err != nil {
+			log.Error().Msgf("scan ip access list error: %s", err.Error())
+			continue
+		}
+		records = append(records, record)
+	}
+
+	for _, parseErr := range base.StoreIpAccessList(records) {
+		log.Error().Msg(parseErr.Error())
+	}
+	log.Info().Msgf("RefreshIpAccessList, records: %d", len(records))
+}
+
 // RefreshGameConfig 定时读取game_config表数据,保存到内存中
 // 超过有效期,则根据game_config表的apple_config数据,生成client_secret,并写入到game_config表的apple_client_id,apple_client_secret中
 func RefreshGameConfig() {
@@ -276,58 +349,170 @@ func userDeleteTransaction(masterDb *sql.DB, applyInfo *UserDeleteApply, gameCon
 
 	dbTx.Commit()
 	userLog.Info().Int64("uid", applyInfo.Uid).Msgf("delete trans, user [%d], transaction success!!!", applyInfo.Uid)
-	//7.调用苹果revoke,判断结果,不参与事务提交
-	revokeRes := appleAuthRevoke(applyInfo, gameConfig)
+	//7.调用第三方撤销授权,判断结果,不参与事务提交
+	revokeRes := thirdAuthRevoke(applyInfo, gameConfig)
 	if !revokeRes {
-		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("delete trans, call apple revoke token failed")
+		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("delete trans, call third revoke token failed")
 	}
 	return
 }
 
-// 调用苹果revoke接口
-func appleAuthRevoke(applyInfo *UserDeleteApply, gameConfig *base.GameConfig) bool {
+// 调用第三方撤销授权
+func thirdAuthRevoke(applyInfo *UserDeleteApply, gameConfig *base.GameConfig) bool {
 	userLog := log.With().Str("req_id", fmt.Sprintf("script_delete_%d_%d_%d", gameConfig.GameId, gameConfig.PlatformId, applyInfo.Uid)).Logger()
-	userLog.Info().Int64("uid", applyInfo.Uid).Msgf("call apple, start")
+	userLog.Info().Int64("uid", applyInfo.Uid).Msgf("third revoke, start")
 	//1.如果ext_info解json失败,或目标字段值为空字符串
-	//则视为普通(非苹果)用户注销,直接返回成功
+	//则视为无需撤销授权的用户注销,直接返回成功
 	data := &base.DeleteAccountExt{}
-	//json.Valid()
 	if applyInfo.ExtInfo != "" {
 		err := json.Unmarshal([]byte(applyInfo.ExtInfo), data)
 		if err != nil {
-			userLog.Info().Int64("uid", applyInfo.Uid).Msgf("call apple, user apply ext_info: %s, json unmarshall error: %s, return success", applyInfo.ExtInfo, err.Error())
+			userLog.Info().Int64("uid", applyInfo.Uid).Msgf("third revoke, user apply ext_info: %s, json unmarshall error: %s, return success", applyInfo.ExtInfo, err.Error())
 			return true
 		}
 	}
-	if data.AppleRefreshToken == "" {
-		userLog.Info().Int64("uid", applyInfo.Uid).Msgf("call apple, refresh token empty, return success")
+	//旧数据只有苹果的刷新token
+	if data.RefreshToken == "" && data.AppleRefreshToken != "" {
+		data.ThirdId = base.ThirdApple
+		data.RefreshToken = data.AppleRefreshToken
+	}
+	//注销申请时没有换取刷新token的, 使用登录时保存的苹果刷新token
+	if data.RefreshToken == "" && gameConfig.AppleClientId != "" {
+		refreshToken, myErr := getThirdRefreshToken(applyInfo.MainUid, base.ThirdApple, gameConfig.AppleClientId)
+		if myErr != nil && myErr.Code != base.ThirdTokenNotExists {
+			userLog.Error().Int64("uid", applyInfo.Uid).Msgf("third revoke, get saved apple refresh token error: %s", myErr.Log)
+			return false
+		}
+		if myErr == nil {
+			data.ThirdId = base.ThirdApple
+			data.RefreshToken = refreshToken
+		}
+	}
+	if data.RefreshToken == "" {
+		userLog.Info().Int64("uid", applyInfo.Uid).Msgf("third revoke, refresh token empty, return success")
 		return true
 	}
-	if gameConfig.AppleClientId == "" || gameConfig.AppleClientSecret == "" {
-		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("call apple, game config missing data, client id: %s, secret: %s", gameConfig.AppleClientId, gameConfig.AppleClientSecret)
+	provider, ok := base.GetThirdProvider(data.ThirdId)
+	if !ok {
+		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("third revoke, third id %d not registered", data.ThirdId)
 		return false
 	}
-	//2.调用revoke接口
-	postData := map[string]string{
-		"client_id":       gameConfig.AppleClientId,
-		"client_secret":   gameConfig.AppleClientSecret,
-		"token_type_hint": "refresh_token",
-		"token":           data.AppleRefreshToken,
-	}
-	res, err := base.HttpPostForm("https://appleid.apple.com/auth/revoke", postData)
-	userLog.Info().Int64("uid", applyInfo.Uid).Interface("apple_revoke_params", postData).RawJSON("apple_revoke_return", res).Msg("apple auth revoke info")
+	//2.调用撤销接口
+	err := provider.RevokeToken(data.RefreshToken, base.ThirdProviderConfig(provider), gameConfig)
 	if err != nil {
-		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("call apple, post failed, error log: %s", err.Log)
+		userLog.Error().Int64("uid", applyInfo.Uid).Msgf("third revoke, %s revoke failed, error log: %s", provider.Name(), err.Log)
 		return false
 	}
-	//返回空字符串,代表成功
-	if string(res) == "" {
-		userLog.Info().Int64("uid", applyInfo.Uid).Msgf("call apple, success, http res empty string")
-		return true
+	userLog.Info().Int64("uid", applyInfo.Uid).Msgf("third revoke, %s revoke success", provider.Name())
+	//已撤销的刷新token不再保存
+	if data.ThirdId == base.ThirdApple && gameConfig.AppleClientId != "" {
+		delErr := deleteThirdToken(applyInfo.MainUid, base.ThirdApple, gameConfig.AppleClientId)
+		if delErr != nil {
+			userLog.Error().Int64("uid", applyInfo.Uid).Msgf("third revoke, delete saved apple refresh token error: %s", delErr.Error())
+		}
+	}
+	return true
+}
+
+// RefreshAppleConsent 定时检查保存的苹果刷新token, 失效时(用户在苹果设置中停止使用此App)按撤销授权通知处理
+// 每条token按检查间隔校验一次, 先更新updated_time再校验, 多服务并发时只有一个服务处理
+func RefreshAppleConsent() {
+	interval := base.GConf.Third.AppleConsentCheckInterval
+	if interval <= 0 {
+		interval = base.AppleConsentCheckInterval
+	}
+	checkTime := base.GetTime() - interval
+	for dbId := 1; dbId <= base.MainAccountDbNumber; dbId++ {
+		masterDb, masterOk := base.AccountMasterDbMap.Load(fmt.Sprintf("account_master_db_%d", dbId))
+		slaveDb, slaveOk := base.AccountSlaveDbMap.Load(fmt.Sprintf("account_slave_db_%d", dbId))
+		if !masterOk || !slaveOk {
+			log.Error().Msgf("RefreshAppleConsent, account db %d not found", dbId)
+			continue
+		}
+		for tableId := 1; tableId <= base.MainAccountTableNumber; tableId++ {
+			appleConsentHandle(masterDb.(*sql.DB), slaveDb.(*sql.DB), fmt.Sprintf("third_token_%d", tableId), checkTime)
+		}
+	}
+}
+
+// 保存的苹果刷新token
+type appleTokenInfo struct {
+	MainUid      int64
+	AppId        string
+	OpenId       string
+	RefreshToken string
+	UpdatedTime  int64
+}
+
+func appleConsentHandle(masterDb *sql.DB, slaveDb *sql.DB, table string, checkTime int64) {
+	querySql := fmt.Sprintf("SELECT uid, app_id, openid, refresh_token, updated_time FROM %s WHERE third_id = ? AND updated_time < ? LIMIT %d", table, base.AppleConsentCheckLimit)
+	rows, err := slaveDb.Query(querySql, base.ThirdApple, checkTime)
+	if err != nil {
+		log.Error().Msgf("RefreshAppleConsent, query %s error: %s", table, err.Error())
+		return
+	}
+	tokens := []appleTokenInfo{}
+	for rows.Next() {
+		var info appleTokenInfo
+		err = rows.Scan(&info.MainUid, &info.AppId, &info.OpenId, &info.RefreshToken, &info.UpdatedTime)
+		if err != nil {
+			log.Error().Msgf("RefreshAppleConsent, scan %s error: %s", table, err.Error())
+			continue
+		}
+		tokens = append(tokens, info)
+	}
+	rows.Close()
+
+	provider, _ := base.GetThirdProvider(base.ThirdApple)
+	for _, info := range tokens {
+		userLog := log.With().Str("req_id", fmt.Sprintf("script_apple_consent_%d", info.MainUid)).Logger()
+		//先更新检查时间, affected为0时已由其他服务处理
+		updateSql := fmt.Sprintf("UPDATE %s SET updated_time = ? WHERE uid = ? AND third_id = ? AND app_id = ? AND updated_time = ?", table)
+		res, err := masterDb.Exec(updateSql, base.GetTime(), info.MainUid, base.ThirdApple, info.AppId, info.UpdatedTime)
+		if err != nil {
+			userLog.Error().Msgf("apple consent, update check time error: %s", err.Error())
+			continue
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			continue
+		}
+		games := appleClientGames(info.AppId)
+		if len(games) == 0 {
+			userLog.Info().Msgf("apple consent, no game for client id %s", info.AppId)
+			continue
+		}
+		refreshToken, myErr := base.DecryptThirdToken(info.RefreshToken)
+		if myErr != nil {
+			userLog.Error().Msgf("apple consent, decrypt refresh token error: %s", myErr.Log)
+			continue
+		}
+		myErr = base.CheckAppleRefreshToken(refreshToken, base.ThirdProviderConfig(provider), &games[0])
+		if myErr == nil {
+			continue
+		}
+		if myErr.Code != base.AppleConsentRevoked {
+			userLog.Error().Msgf("apple consent, check refresh token error: %s", myErr.Log)
+			continue
+		}
+
+		//与苹果撤销授权通知的处理一致
+		userLog.Info().Msgf("apple consent revoked, client id: %s, sub: %s", info.AppId, info.OpenId)
+		event := &base.AppleNotificationEvent{Type: base.AppleEventConsentRevoked, Sub: info.OpenId, ClientId: info.AppId}
+		myErr = AppleNotification(event, &userLog)
+		if myErr != nil && myErr.Code != base.AppleNotifyAccountNotExists {
+			userLog.Error().Msgf("apple consent, revoke error: %s", myErr.Log)
+			continue
+		}
+		err = deleteThirdToken(info.MainUid, base.ThirdApple, info.AppId)
+		if err != nil {
+			userLog.Error().Msgf("apple consent, delete refresh token error: %s", err.Error())
+		}
+		base.SecurityLog.Info().
+			Str("event", "apple_consent_revoked").
+			Int64("main_uid", info.MainUid).
+			Str("client_id", info.AppId).
+			Msg("apple refresh token revoked, consent revoked")
 	}
-	//其他情况,代表失败
-	userLog.Error().Int64("uid", applyInfo.Uid).Msgf("call apple, res not empty string, res: %s", res)
-	return false
 }
 
 // 协程处理账号恢复
@@ -0,0 +1,277 @@
+/**
+ * @project Accounts
+ * @filename third_model.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/26 11:30
+ * @version 1.0
+ * @description
+ * 第三方授权码登录model, 账号解析及token存储
+ * token加密后存储在third_token表(与主账号表相同分表), 同一主账号每个第三方应用一条
+ * 苹果登录、注册、绑定时换取刷新token保存, app_id为项目的苹果客户端id, 注销时撤销授权
+ * 苹果服务端通知的处理: 撤销授权解绑、苹果账号删除时注销、中转邮箱停用时标记无法投递
+ * 第三方要求删除用户数据时(Facebook数据删除回调、Google账号清除), 按注销流程添加注销申请
+ */
+
+package models
+
+import (
+	"accounts/base"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog"
+	"strings"
+)
+
+// ResolveThirdAccount 授权码换取结果对应的第三方账号, 通过hash表查找
+// 优先使用unionid; unionid账号不存在而openid账号已存在时(接入unionid之前注册的), 继续使用openid账号
+func ResolveThirdAccount(thirdId int, tokenInfo *base.ThirdTokenInfo) string {
+	openAccount := fmt.Sprintf("%d_%s", thirdId, tokenInfo.OpenId)
+	if tokenInfo.UnionId == "" {
+		return openAccount
+	}
+	unionAccount := fmt.Sprintf("%d_%s", thirdId, tokenInfo.UnionId)
+	if GetAccountUid(unionAccount) > 0 {
+		return unionAccount
+	}
+	if GetAccountUid(openAccount) > 0 {
+		return openAccount
+	}
+	return unionAccount
+}
+
+// SaveThirdToken 保存第三方token, 用于之后刷新或撤销授权, access token、刷新token加密存储
+func SaveThirdToken(account string, thirdId int, tokenInfo *base.ThirdTokenInfo) *base.MyError {
+	mainUid := GetAccountUid(account)
+	if mainUid == 0 {
+		return &base.MyError{Code: base.ThirdTokenSaveError, Log: "main uid not found, account: " + account}
+	}
+	accessToken, myErr := base.EncryptThirdToken(tokenInfo.AccessToken)
+	if myErr != nil {
+		return myErr
+	}
+	refreshToken, myErr := base.EncryptThirdToken(tokenInfo.RefreshToken)
+	if myErr != nil {
+		return myErr
+	}
+	dbTable := base.GetDbTable(mainUid, -1, -1)
+	currTime := base.GetTime()
+	expiresTime := int64(0)
+	if tokenInfo.ExpiresIn > 0 {
+		expiresTime = currTime + tokenInfo.ExpiresIn
+	}
+	saveSql := fmt.Sprintf("INSERT INTO %s (uid, third_id, app_id, openid, unionid, access_token, refresh_token, expires_time, updated_time) VALUES (?,?,?,?,?,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE openid = VALUES(openid), unionid = VALUES(unionid), access_token = VALUES(access_token), refresh_token = VALUES(refresh_token), expires_time = VALUES(expires_time), updated_time = VALUES(updated_time)", dbTable.ThirdTokenTable)
+	_, err := dbTable.AccountMasterDb.Exec(saveSql, mainUid, thirdId, tokenInfo.AppId, tokenInfo.OpenId, tokenInfo.UnionId, accessToken, refreshToken, expiresTime, currTime)
+	if err != nil {
+		return &base.MyError{Code: base.ThirdTokenSaveError, Log: fmt.Sprintf("save third token, main uid: %d, third id: %d, error: %s", mainUid, thirdId, err.Error())}
+	}
+	return nil
+}
+
+// SaveAppleRefreshToken 苹果登录、注册、绑定时, 用authorization code换取刷新token保存
+// 使用项目的苹果客户端id及secret(见 makeSecretAndUpdateConfig), 不是苹果账号或没有code时不处理
+func SaveAppleRefreshToken(account, thirdAccountJson string, gameId, platformId int) *base.MyError {
+	if !strings.HasPrefix(account, fmt.Sprintf("%d_", base.ThirdApple)) || thirdAccountJson == "" {
+		return nil
+	}
+	thirdAccount := &base.ThirdAccount{}
+	err := json.Unmarshal([]byte(thirdAccountJson), thirdAccount)
+	if err != nil || thirdAccount.AuthorizationCode == "" {
+		return nil
+	}
+	key := fmt.Sprintf("_account_game_config_%d_%d", gameId, platformId)
+	gameConfig, _ := base.MemoryGameConfig.Load(key)
+	gameConfigInfo, ok := gameConfig.(base.GameConfig)
+	if !ok || gameConfigInfo.AppleClientId == "" {
+		return nil
+	}
+
+	provider, _ := base.GetThirdProvider(base.ThirdApple)
+	refreshToken, myErr := provider.ExchangeToken(thirdAccount, base.ThirdProviderConfig(provider), &gameConfigInfo)
+	if myErr != nil || refreshToken == "" {
+		return myErr
+	}
+	return SaveThirdToken(account, base.ThirdApple, &base.ThirdTokenInfo{
+		AppId:        gameConfigInfo.AppleClientId,
+		OpenId:       strings.TrimPrefix(account, fmt.Sprintf("%d_", base.ThirdApple)),
+		RefreshToken: refreshToken,
+	})
+}
+
+// 保存的第三方刷新token, 已解密
+func getThirdRefreshToken(mainUid int64, thirdId int, appId string) (string, *base.MyError) {
+	dbTable := base.GetDbTable(mainUid, -1, -1)
+	var refreshToken string
+	querySql := fmt.Sprintf("SELECT refresh_token FROM %s WHERE uid = ? AND third_id = ? AND app_id = ?", dbTable.ThirdTokenTable)
+	err := dbTable.AccountSlaveDb.QueryRow(querySql, mainUid, thirdId, appId).Scan(&refreshToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", &base.MyError{Code: base.ThirdTokenNotExists, Log: fmt.Sprintf("third token not exists, main uid: %d, third id: %d, app id: %s", mainUid, thirdId, appId)}
+		}
+		return "", &base.MyError{Code: base.ThirdTokenQueryError, Log: fmt.Sprintf("query third token, main uid: %d, error: %s", mainUid, err.Error())}
+	}
+	if refreshToken == "" {
+		return "", &base.MyError{Code: base.ThirdTokenNotExists, Log: fmt.Sprintf("third refresh token empty, main uid: %d, third id: %d, app id: %s", mainUid, thirdId, appId)}
+	}
+	return base.DecryptThirdToken(refreshToken)
+}
+
+// 删除保存的第三方token, 已撤销或失效后不再使用
+func deleteThirdToken(mainUid int64, thirdId int, appId string) error {
+	dbTable := base.GetDbTable(mainUid, -1, -1)
+	deleteSql := fmt.Sprintf("DELETE FROM %s WHERE uid = ? AND third_id = ? AND app_id = ?", dbTable.ThirdTokenTable)
+	_, err := dbTable.AccountMasterDb.Exec(deleteSql, mainUid, thirdId, appId)
+	return err
+}
+
+// AppleNotification 处理苹果服务端通知
+// consent-revoked: 解绑苹果账号, 苹果为注册账号时不能解绑, 撤销所有登录会话
+// account-delete: 苹果为注册账号时按注销流程添加注销申请, 否则解绑
+// email-disabled、email-enabled: 标记中转邮箱是否可以投递
+func AppleNotification(event *base.AppleNotificationEvent, userLog *zerolog.Logger) *base.MyError {
+	switch event.Type {
+	case base.AppleEventEmailDisabled, base.AppleEventEmailEnabled:
+		if event.Email == "" {
+			return &base.MyError{Code: base.AppleNotifyPayloadError, Log: "apple notification email empty, sub: " + event.Sub}
+		}
+		return base.SetEmailUndeliverable(event.Email, event.Type == base.AppleEventEmailDisabled)
+	case base.AppleEventConsentRevoked, base.AppleEventAccountDelete:
+	default:
+		return &base.MyError{Code: base.AppleNotifyEventUnknown, Log: "apple notification type: " + event.Type}
+	}
+
+	account := fmt.Sprintf("%d_%s", base.ThirdApple, event.Sub)
+	mainUid := GetAccountUid(account)
+	if mainUid == 0 {
+		return &base.MyError{Code: base.AppleNotifyAccountNotExists, Log: "apple account: " + account}
+	}
+	games := appleClientGames(event.ClientId)
+	if len(games) == 0 {
+		return &base.MyError{Code: base.AppleNotifyGameNotFound, Log: "apple client id: " + event.ClientId}
+	}
+	mainAccount, myErr := getMainAccount(base.GetDbTable(mainUid, -1, -1), mainUid)
+	if myErr != nil {
+		return myErr
+	}
+
+	if mainAccount != account {
+		return unbindAppleAccount(account, mainUid, games)
+	}
+	if event.Type == base.AppleEventConsentRevoked {
+		return base.RevokeAllSessions(mainUid)
+	}
+	for _, game := range games {
+		_, myErr = ThirdDeletionApply(account, game.GameId, game.PlatformId, userLog)
+		if myErr != nil && myErr.Code != base.ThirdDeletionAccountNotExists {
+			return myErr
+		}
+	}
+	return nil
+}
+
+// ThirdDeletionApply 第三方要求删除用户数据, 按注销流程添加注销申请, 已申请过的不重复申请, 返回项目uid
+func ThirdDeletionApply(account string, gameId, platformId int, userLog *zerolog.Logger) (int64, *base.MyError) {
+	//项目及大区来自回调地址, 先检查是否存在
+	if !base.GameUserDbExists(gameId, platformId) {
+		return 0, &base.MyError{Code: base.GameIdNotExists, Log: fmt.Sprintf("third deletion game: %d-%d", gameId, platformId)}
+	}
+	mainUid := GetAccountUid(account)
+	if mainUid == 0 {
+		return 0, &base.MyError{Code: base.ThirdDeletionAccountNotExists, Log: "account not exists: " + account}
+	}
+	dbTable := base.GetDbTable(mainUid, gameId, platformId)
+	var uid int64
+	querySql := fmt.Sprintf("SELECT uid FROM %s WHERE account = ?", dbTable.GameUserTable)
+	err := dbTable.GameUserSlaveDb.QueryRow(querySql, account).Scan(&uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, &base.MyError{Code: base.ThirdDeletionAccountNotExists, Log: fmt.Sprintf("account %s not in game %d-%d", account, gameId, platformId)}
+		}
+		return 0, &base.MyError{Code: base.DeleteAccountQueryError, Log: fmt.Sprintf("query game user, account: %s, error: %s", account, err.Error())}
+	}
+
+	deleteInfo := &base.LogoutAccountFields{
+		Uid:     uid,
+		Account: account,
+		CommonFields: base.CommonFields{
+			GameId:     gameId,
+			PlatformId: platformId,
+		},
+	}
+	myErr := AddDeleteApply(deleteInfo, userLog)
+	if myErr != nil && myErr.Code != base.DeleteApplyAlreadyExists {
+		return 0, myErr
+	}
+	return uid, nil
+}
+
+// GetDeleteApplyStatus 注销申请的进度
+func GetDeleteApplyStatus(uid int64, gameId, platformId int) (*base.ThirdDeletionStatusFields, *base.MyError) {
+	mainUid := base.GetMainUid(uid, gameId, platformId)
+	dbTable := base.GetDbTable(mainUid, gameId, platformId)
+	status := &base.ThirdDeletionStatusFields{}
+	querySql := fmt.Sprintf("SELECT `status`, apply_time, execute_delete_time FROM %s WHERE uid = ? ORDER BY apply_time DESC LIMIT 1", dbTable.GameUserDeleteApplyTable)
+	err := dbTable.GameUserSlaveDb.QueryRow(querySql, uid).Scan(&status.Status, &status.ApplyTime, &status.ExecuteTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &base.MyError{Code: base.ThirdDeletionApplyNotExists, Log: fmt.Sprintf("delete apply not exists, uid: %d", uid)}
+		}
+		return nil, &base.MyError{Code: base.ThirdDeletionQueryError, Log: fmt.Sprintf("query delete apply, uid: %d, error: %s", uid, err.Error())}
+	}
+	return status, nil
+}
+
+// 苹果客户端id对应的项目及大区
+func appleClientGames(clientId string) []base.GameConfig {
+	games := []base.GameConfig{}
+	if clientId == "" {
+		return games
+	}
+	base.MemoryGameConfig.Range(func(key, value interface{}) bool {
+		gameConfig, ok := value.(base.GameConfig)
+		if ok && gameConfig.AppleClientId == clientId {
+			games = append(games, gameConfig)
+		}
+		return true
+	})
+	return games
+}
+
+// 解绑苹果账号, 与UnBindAccount相同, 在事务中删除使用此客户端id的项目用户表及hash表记录并清空账号表中的字段
+func unbindAppleAccount(account string, mainUid int64, games []base.GameConfig) *base.MyError {
+	gameTables := []*base.DbTable{}
+	for _, game := range games {
+		gameTables = append(gameTables, base.GetDbTable(mainUid, game.GameId, game.PlatformId))
+	}
+	myErr := unbindAccountTx(gameTables, account, base.AccountThird, mainUid)
+	if myErr != nil {
+		return &base.MyError{Code: base.AppleNotifyUnbindError, Log: fmt.Sprintf("unbind apple account %s error: %d %s", account, myErr.Code, myErr.Log)}
+	}
+	return nil
+}
+
+// GoogleRiscEvent 处理Google安全事件
+// account-purged: 按注销流程添加注销申请; tokens-revoked、sessions-revoked、account-disabled: 撤销所有登录会话
+func GoogleRiscEvent(event *base.GoogleRiscEvent, gameId, platformId int, userLog *zerolog.Logger) *base.MyError {
+	if event.Type == base.GoogleRiscVerification {
+		return nil
+	}
+	account := fmt.Sprintf("%d_%s", base.ThirdGoogle, event.Sub)
+	switch event.Type {
+	case base.GoogleRiscAccountPurged:
+		if gameId <= 0 || platformId <= 0 {
+			return &base.MyError{Code: base.ThirdDeletionParamsError, Log: fmt.Sprintf("google account purged, game: %d-%d", gameId, platformId)}
+		}
+		_, myErr := ThirdDeletionApply(account, gameId, platformId, userLog)
+		return myErr
+	case base.GoogleRiscTokensRevoked, base.GoogleRiscSessionsRevoked, base.GoogleRiscAccountDisabled:
+		mainUid := GetAccountUid(account)
+		if mainUid == 0 {
+			return &base.MyError{Code: base.ThirdDeletionAccountNotExists, Log: "account not exists: " + account}
+		}
+		return base.RevokeAllSessions(mainUid)
+	}
+	return &base.MyError{Code: base.ThirdEventUnknown, Log: "google risc event type: " + event.Type}
+}
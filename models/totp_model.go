@@ -0,0 +1,227 @@
+/**
+ * @project Accounts
+ * @filename totp_model.go
+ * @author kangyun@outlook.com
+ * @copyright Copyright (C) kangyun@outlook.com
+ * @datetime 2023/4/19 14:30
+ * @version 1.0
+ * @description
+ * 二次验证model, TOTP密钥及恢复码存储在主账号表
+ */
+
+package models
+
+import (
+	"accounts/base"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 主账号二次验证信息
+type totpInfo struct {
+	secret        string //加密后的TOTP密钥
+	status        int
+	recoveryCodes string //恢复码hash, json数组
+}
+
+// 查询二次验证信息, 启用流程中写入后立即读取, 使用主库
+func getTotpInfo(dbTable *base.DbTable, mainUid int64) (*totpInfo, *base.MyError) {
+	info := &totpInfo{}
+	querySql := fmt.Sprintf("SELECT totp_secret, totp_status, recovery_codes FROM %s WHERE uid = ?", dbTable.AccountTable)
+	err := dbTable.AccountMasterDb.QueryRow(querySql, mainUid).Scan(&info.secret, &info.status, &info.recoveryCodes)
+	if err != nil {
+		return nil, &base.MyError{Code: base.TotpQueryError, Log: fmt.Sprintf("query totp info, main uid: %d, error: %s", mainUid, err.Error())}
+	}
+	return info, nil
+}
+
+// TotpEnroll 生成TOTP密钥, 确认前不生效, 重复调用会重新生成
+func TotpEnroll(claims *base.CustomClaims) (*base.TotpEnrollRespFields, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	info, err := getTotpInfo(dbTable, mainUid)
+	if err != nil {
+		return nil, err
+	}
+	if info.status == base.TotpStatusEnabled {
+		return nil, &base.MyError{Code: base.TotpAlreadyEnabled}
+	}
+
+	secret, err := base.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := base.EncryptTotpSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	updateSql := fmt.Sprintf("UPDATE %s SET totp_secret = ?, totp_status = ?, recovery_codes = '', updated_time = ? WHERE uid = ?", dbTable.AccountTable)
+	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, encrypted, base.TotpStatusPending, base.GetTime(), mainUid)
+	if dbErr != nil {
+		return nil, &base.MyError{Code: base.TotpSaveError, Log: fmt.Sprintf("totp enroll, main uid: %d, update error: %s", mainUid, dbErr.Error())}
+	}
+
+	return &base.TotpEnrollRespFields{
+		Secret:     secret,
+		OtpauthUri: base.TotpUri(strconv.FormatInt(claims.Uid, 10), secret),
+	}, nil
+}
+
+// TotpConfirm 使用认证器App中的验证码确认启用, 返回恢复码
+func TotpConfirm(claims *base.CustomClaims, code string) ([]string, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	info, err := getTotpInfo(dbTable, mainUid)
+	if err != nil {
+		return nil, err
+	}
+	if info.status == base.TotpStatusEnabled {
+		return nil, &base.MyError{Code: base.TotpAlreadyEnabled}
+	}
+	if info.status != base.TotpStatusPending {
+		return nil, &base.MyError{Code: base.TotpNotEnabled}
+	}
+
+	err = checkTotpCode(mainUid, info.secret, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := base.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashContent, _ := json.Marshal(hashes)
+	updateSql := fmt.Sprintf("UPDATE %s SET totp_status = ?, recovery_codes = ?, updated_time = ? WHERE uid = ? AND totp_status = ?", dbTable.AccountTable)
+	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, base.TotpStatusEnabled, string(hashContent), base.GetTime(), mainUid, base.TotpStatusPending)
+	if dbErr != nil {
+		return nil, &base.MyError{Code: base.TotpSaveError, Log: fmt.Sprintf("totp confirm, main uid: %d, update error: %s", mainUid, dbErr.Error())}
+	}
+	return codes, nil
+}
+
+// TotpDisable 关闭二次验证, 需要验证码或恢复码
+func TotpDisable(claims *base.CustomClaims, code string) *base.MyError {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	info, err := getTotpInfo(dbTable, mainUid)
+	if err != nil {
+		return err
+	}
+	if info.status == 0 {
+		return &base.MyError{Code: base.TotpNotEnabled}
+	}
+	//已启用需要验证, 待确认的直接清除
+	if info.status == base.TotpStatusEnabled {
+		err = checkSecondFactor(dbTable, mainUid, info, code)
+		if err != nil {
+			return err
+		}
+	}
+
+	updateSql := fmt.Sprintf("UPDATE %s SET totp_secret = '', totp_status = 0, recovery_codes = '', updated_time = ? WHERE uid = ?", dbTable.AccountTable)
+	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, base.GetTime(), mainUid)
+	if dbErr != nil {
+		return &base.MyError{Code: base.TotpSaveError, Log: fmt.Sprintf("totp disable, main uid: %d, update error: %s", mainUid, dbErr.Error())}
+	}
+	return nil
+}
+
+// TotpLogin 登录二次验证, 验证通过后签发token
+func TotpLogin(data *base.TotpLoginFields) (*base.LoginReturnFields, *base.MyError) {
+	challenge, err := base.GetTotpChallenge(data.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.GameId != data.GameId || challenge.PlatformId != data.PlatformId {
+		return nil, &base.MyError{Code: base.TotpChallengeGameNotMatch, Log: fmt.Sprintf("challenge game: %d-%d, params game: %d-%d", challenge.GameId, challenge.PlatformId, data.GameId, data.PlatformId)}
+	}
+
+	dbTable := base.GetDbTable(challenge.MainUid, challenge.GameId, challenge.PlatformId)
+	info, err := getTotpInfo(dbTable, challenge.MainUid)
+	if err != nil {
+		return nil, err
+	}
+	//challenge有效期内关闭了二次验证, 无需再验证
+	if info.status == base.TotpStatusEnabled {
+		err = checkSecondFactor(dbTable, challenge.MainUid, info, data.Code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !base.DeleteTotpChallenge(data.ChallengeToken) {
+		return nil, &base.MyError{Code: base.TotpChallengeInvalid, Log: fmt.Sprintf("totp challenge %s already used", data.ChallengeToken)}
+	}
+
+	loginRet := &challenge.LoginRet
+	loginRet.LoginTime = base.GetTime()
+	err = issueLoginTokens(loginRet, challenge.MainUid, challenge.GameId, challenge.PlatformId, challenge.ChannelId, challenge.Session)
+	if err != nil {
+		return nil, err
+	}
+	return loginRet, nil
+}
+
+// 校验6位TOTP验证码, 同一验证码只能使用一次
+func checkTotpCode(mainUid int64, encrypted, code string) *base.MyError {
+	if code == "" || code == base.DefaultNoValue {
+		return &base.MyError{Code: base.TotpCodeEmpty}
+	}
+	secret, err := base.DecryptTotpSecret(encrypted)
+	if err != nil {
+		return err
+	}
+	step, ok := base.VerifyTotp(secret, code, time.Now())
+	if !ok || !base.UseTotpStep(mainUid, step) {
+		return &base.MyError{Code: base.TotpCodeError, Log: fmt.Sprintf("main uid: %d, totp code error", mainUid)}
+	}
+	return nil
+}
+
+// 校验验证码或恢复码, 6位数字为验证码, 其余按恢复码处理, 恢复码使用后删除
+func checkSecondFactor(dbTable *base.DbTable, mainUid int64, info *totpInfo, code string) *base.MyError {
+	if code == "" || code == base.DefaultNoValue {
+		return &base.MyError{Code: base.TotpCodeEmpty}
+	}
+	if _, numErr := strconv.Atoi(code); numErr == nil && len(code) == 6 {
+		return checkTotpCode(mainUid, info.secret, code)
+	}
+
+	hashes := []string{}
+	json.Unmarshal([]byte(info.recoveryCodes), &hashes)
+	codeHash := base.RecoveryCodeHash(code)
+	remain := []string{}
+	found := false
+	for _, v := range hashes {
+		if v == codeHash && !found {
+			found = true
+			continue
+		}
+		remain = append(remain, v)
+	}
+	if !found {
+		return &base.MyError{Code: base.TotpCodeError, Log: fmt.Sprintf("main uid: %d, recovery code error", mainUid)}
+	}
+
+	//带上原值做条件, 并发使用同一恢复码时只有一个成功
+	remainContent, _ := json.Marshal(remain)
+	updateSql := fmt.Sprintf("UPDATE %s SET recovery_codes = ? WHERE uid = ? AND recovery_codes = ?", dbTable.AccountTable)
+	result, err := dbTable.AccountMasterDb.Exec(updateSql, string(remainContent), mainUid, info.recoveryCodes)
+	if err != nil {
+		return &base.MyError{Code: base.TotpSaveError, Log: fmt.Sprintf("main uid: %d, use recovery code error: %s", mainUid, err.Error())}
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		return &base.MyError{Code: base.TotpCodeError, Log: fmt.Sprintf("main uid: %d, recovery code already used", mainUid)}
+	}
+	base.SecurityLog.Info().
+		Str("event", "recovery_code_used").
+		Int64("main_uid", mainUid).
+		Int("remain", len(remain)).
+		Msg("recovery code used")
+	return nil
+}
@@ -13,8 +13,8 @@ package models
 
 import (
 	"accounts/base"
+	"crypto/subtle"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -22,10 +22,11 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 // AccountRegister 账号注册
-func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFields, *base.MyError) {
+func AccountRegister(user *base.RegisterFields, ip string, session *base.SessionInfo) (*base.LoginReturnFields, *base.MyError) {
 	//根据account获取uid,查询hash表, 如果存在，则登录
 	mainUid := GetAccountUid(user.Account)
 	if mainUid > 0 {
@@ -46,8 +47,8 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 				PlatformId: user.PlatformId,
 			},
 		}
-		ret, err := AccountLogin(loginFields, mainUid)
-		if err.Code != base.LoginSuccess {
+		ret, err := AccountLogin(loginFields, mainUid, session)
+		if err.Code != base.LoginSuccess && err.Code != base.LoginSecondFactorRequired {
 			base.LimitLoginIncr(ip, user.Account)
 		}
 		return ret, err
@@ -92,29 +93,31 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 		return nil, &base.MyError{Code: base.GetGameUserDbTxError, Log: "get game master db transaction error: " + err.Error()}
 	}
 
-	salt := ""
 	password := ""
-	//密码加盐处理
+	//密码hash处理
 	//游客与第三方密码为空
 	//手机号 + 密码或验证码
 	//邮箱 + 密码或验证码
 	//用户名 + 密码
 	if user.Type == base.AccountUsername || (user.Type == base.AccountMobile && user.Password != base.DefaultNoValue) || (user.Type == base.AccountEmail && user.Password != base.DefaultNoValue) {
-		salt = base.RandomString(base.PasswordSaltLength)
-		password = base.Md5Sum([]byte(user.Password + salt))
+		var hashErr *base.MyError
+		password, hashErr = base.HashPassword(user.Password)
+		if hashErr != nil {
+			return nil, hashErr
+		}
 	}
 
 	currTime := base.GetTime()
 	//插入语句
 	insertHash := fmt.Sprintf("INSERT INTO %s(account, uid) VALUES(?,?)", hashDbTable.AccountHashTable)
-	insertAccount := fmt.Sprintf("INSERT INTO %s (%s, uid, `password`, created_time, created_ip, last_login_time, type, device_type, salt, lang) VALUES(?,?,?,?,?,?,?,?,?,?)", dbTable.AccountTable, base.AccountType[user.Type])
+	insertAccount := fmt.Sprintf("INSERT INTO %s (%s, uid, `password`, created_time, created_ip, last_login_time, type, device_type, lang) VALUES(?,?,?,?,?,?,?,?,?)", dbTable.AccountTable, base.AccountType[user.Type])
 	insertGameUser := fmt.Sprintf("INSERT INTO %s (account, uid, main_uid, created_time, type) VALUES (?,?,?,?,?)", dbTable.GameUserTable)
 
 	_, err = hashDbTx.Exec(insertHash, user.Account, accountUid)
 	if err != nil {
 		return nil, &base.MyError{Code: base.TxExecInsertHashError, Log: "hash insert transaction exec error: " + err.Error()}
 	}
-	_, err = accountDbTx.Exec(insertAccount, user.Account, accountUid, password, currTime, ip, currTime, user.Type, user.DeviceType, salt, user.Lang)
+	_, err = accountDbTx.Exec(insertAccount, user.Account, accountUid, password, currTime, ip, currTime, user.Type, user.DeviceType, user.Lang)
 	if err != nil {
 		hashDbTx.Rollback()
 		return nil, &base.MyError{Code: base.TxExecInsertAccountError, Log: "account insert transaction exec error: " + err.Error()}
@@ -148,8 +151,9 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 			PlayTime:   0,
 		},
 	}
-	//获取生成token
-	token, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, ret, base.TokenTypeAccess, currTime+base.GConf.Base.LoginTokenExpires)
+	//获取生成token, 本次登录新建token族
+	familyId := base.BuildTokenId()
+	token, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, ret, base.TokenTypeAccess, familyId, currTime+base.GConf.Base.LoginTokenExpires)
 	if tokenErr != nil {
 		hashDbTx.Rollback()
 		accountDbTx.Rollback()
@@ -159,7 +163,7 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 	ret.Tokens.LoginToken = token
 
 	//获取refresh token
-	refreshToken, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, ret, base.TokenTypeRefresh, currTime+ret.Tokens.RefreshTokenExpiresIn)
+	refreshToken, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, ret, base.TokenTypeRefresh, familyId, currTime+ret.Tokens.RefreshTokenExpiresIn)
 	if tokenErr != nil {
 		hashDbTx.Rollback()
 		accountDbTx.Rollback()
@@ -167,6 +171,7 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 		return nil, tokenErr
 	}
 	ret.Tokens.RefreshToken = refreshToken
+	saveLoginSession(session, familyId, accountUid, user.GameId, user.PlatformId, user.ChannelId, currTime)
 
 	err = hashDbTx.Commit()
 	if err != nil {
@@ -186,7 +191,7 @@ func AccountRegister(user *base.RegisterFields, ip string) (*base.LoginReturnFie
 }
 
 // 登录
-func AccountLogin(user *base.LoginFields, mainUid int64) (*base.LoginReturnFields, *base.MyError) {
+func AccountLogin(user *base.LoginFields, mainUid int64, session *base.SessionInfo) (*base.LoginReturnFields, *base.MyError) {
 	dbTable := base.GetDbTable(mainUid, user.GameId, user.PlatformId)
 
 	//当前时间戳
@@ -194,8 +199,8 @@ func AccountLogin(user *base.LoginFields, mainUid int64) (*base.LoginReturnField
 
 	//查询主账号
 	userInfo := &base.AccountQueryFields{}
-	querySql := fmt.Sprintf("SELECT IFNULL(email, '') email, IFNULL(mobile, '') mobile, `password`, salt, `name`, card_id, type FROM %s WHERE uid = ?", dbTable.AccountTable)
-	err := dbTable.AccountSlaveDb.QueryRow(querySql, mainUid).Scan(&userInfo.Email, &userInfo.Mobile, &userInfo.Password, &userInfo.Salt, &userInfo.Name, &userInfo.CardId, &userInfo.Type)
+	querySql := fmt.Sprintf("SELECT IFNULL(email, '') email, IFNULL(mobile, '') mobile, `password`, salt, `name`, card_id, type, totp_status FROM %s WHERE uid = ?", dbTable.AccountTable)
+	err := dbTable.AccountSlaveDb.QueryRow(querySql, mainUid).Scan(&userInfo.Email, &userInfo.Mobile, &userInfo.Password, &userInfo.Salt, &userInfo.Name, &userInfo.CardId, &userInfo.Type, &userInfo.TotpStatus)
 	if err != nil {
 		return nil, &base.MyError{Code: base.AccountLoginException, Log: fmt.Sprintf("account %s, query uid: %d, exception: %s", user.Account, mainUid, err.Error())}
 	}
@@ -257,8 +262,11 @@ func AccountLogin(user *base.LoginFields, mainUid int64) (*base.LoginReturnField
 				thirds = append(thirds, queryAccount)
 			}
 		}
-		//用户是否被禁用
+		//用户是否被禁用, 被禁用则撤销其所有会话
 		if gameUserInfo.Status == base.AccountDisabled {
+			if revokeErr := base.RevokeAllSessions(mainUid); revokeErr != nil {
+				log.Error().Int64("main_uid", mainUid).Msg(revokeErr.Log)
+			}
 			return nil, &base.MyError{Code: base.LoginAccountDisabled}
 		}
 
@@ -268,12 +276,16 @@ func AccountLogin(user *base.LoginFields, mainUid int64) (*base.LoginReturnField
 		}
 	}
 
-	saltPassword := base.Md5Sum([]byte(user.Password + userInfo.Salt))
 	//用户名+密码方式，需要比对密码
 	if user.Type == base.AccountUsername || user.Type == base.AccountMobile && user.Password != base.DefaultNoValue || user.Type == base.AccountEmail && user.Password != base.DefaultNoValue {
-		if saltPassword != userInfo.Password {
+		match, needRehash := base.VerifyPassword(user.Password, userInfo.Password, userInfo.Salt)
+		if !match {
 			return nil, &base.MyError{Code: base.LoginPasswordError, Log: "password error"}
 		}
+		//旧的md5格式或参数已调整, 登录成功后重新生成hash
+		if needRehash {
+			rehashPassword(dbTable, mainUid, user.Password)
+		}
 	}
 	adult, playTime := parseCardId(isRealName, userInfo.CardId)
 	loginRet := &base.LoginReturnFields{
@@ -298,21 +310,78 @@ func AccountLogin(user *base.LoginFields, mainUid int64) (*base.LoginReturnField
 		},
 	}
 
-	//获取生成token
-	token, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, loginRet, base.TokenTypeAccess, currTime+base.GConf.Base.LoginTokenExpires)
+	//已启用二次验证, 先返回challenge, 验证码通过后再签发token
+	if userInfo.TotpStatus == base.TotpStatusEnabled {
+		secondFactor, challengeErr := base.SaveTotpChallenge(&base.TotpChallengeInfo{
+			MainUid:    mainUid,
+			GameId:     user.GameId,
+			PlatformId: user.PlatformId,
+			ChannelId:  user.ChannelId,
+			LoginRet:   *loginRet,
+			Session:    session,
+		})
+		if challengeErr != nil {
+			return nil, challengeErr
+		}
+		return nil, &base.MyError{Code: base.LoginSecondFactorRequired, Data: secondFactor}
+	}
+
+	tokenErr := issueLoginTokens(loginRet, mainUid, user.GameId, user.PlatformId, user.ChannelId, session)
 	if tokenErr != nil {
 		return nil, tokenErr
 	}
+	return loginRet, &base.MyError{Code: base.LoginSuccess}
+}
+
+// 登录成功, 生成token及刷新token, 本次登录新建token族
+func issueLoginTokens(loginRet *base.LoginReturnFields, mainUid int64, gameId, platformId, channelId int, session *base.SessionInfo) *base.MyError {
+	currTime := base.GetTime()
+	familyId := base.BuildTokenId()
+	token, tokenErr := base.BuildLoginToken(gameId, platformId, channelId, loginRet, base.TokenTypeAccess, familyId, currTime+base.GConf.Base.LoginTokenExpires)
+	if tokenErr != nil {
+		return tokenErr
+	}
 	loginRet.Tokens.LoginToken = token
 
 	//获取生成token
-	refreshToken, tokenErr := base.BuildLoginToken(user.GameId, user.PlatformId, user.ChannelId, loginRet, base.TokenTypeRefresh, currTime+loginRet.Tokens.RefreshTokenExpiresIn)
+	refreshToken, tokenErr := base.BuildLoginToken(gameId, platformId, channelId, loginRet, base.TokenTypeRefresh, familyId, currTime+loginRet.Tokens.RefreshTokenExpiresIn)
 	if tokenErr != nil {
-		return nil, tokenErr
+		return tokenErr
 	}
 	loginRet.Tokens.RefreshToken = refreshToken
+	saveLoginSession(session, familyId, mainUid, gameId, platformId, channelId, currTime)
+	return nil
+}
 
-	return loginRet, &base.MyError{Code: base.LoginSuccess}
+// 记录本次登录的会话信息, 会话信息仅用于展示和撤销, 保存失败不影响登录
+func saveLoginSession(session *base.SessionInfo, familyId string, mainUid int64, gameId, platformId, channelId int, currTime int64) {
+	if session == nil {
+		return
+	}
+	session.SessionId = familyId
+	session.GameId = gameId
+	session.PlatformId = platformId
+	session.ChannelId = channelId
+	session.CreatedTime = currTime
+	session.LastActiveTime = currTime
+	err := base.SaveSessionInfo(mainUid, session)
+	if err != nil {
+		log.Error().Msgf("save login session error: %s", err.Log)
+	}
+}
+
+// 重新生成密码hash, 失败不影响登录, 下次登录时再升级
+func rehashPassword(dbTable *base.DbTable, mainUid int64, password string) {
+	newPassword, hashErr := base.HashPassword(password)
+	if hashErr != nil {
+		log.Error().Int64("main_uid", mainUid).Msg(hashErr.Log)
+		return
+	}
+	updateSql := fmt.Sprintf("UPDATE %s SET `password` = ?, salt = '' WHERE uid = ?", dbTable.AccountTable)
+	_, err := dbTable.AccountMasterDb.Exec(updateSql, newPassword, mainUid)
+	if err != nil {
+		log.Error().Int64("main_uid", mainUid).Msgf("rehash password, update account error: %s", err.Error())
+	}
 }
 
 // 根据身份证id解析年龄信息，精确到天
@@ -366,47 +435,174 @@ func buildAccountUid() (int64, *base.MyError) {
 // 一个项目最大999个大区
 // 每个项目的一个大区用户无限制，但在 9999999999 内容易区分
 func buildGameUid(accountUid int64, gameId, platformId int) int64 {
-	gId := strings.Trim(fmt.Sprintf("%6d", gameId), " ")
-	pId := strings.Trim(fmt.Sprintf("%3d", platformId), " ")
-	uidFormat := "%s%s0000000000"
-	gameUid, _ := strconv.ParseInt(fmt.Sprintf(uidFormat, gId, pId), 10, 64)
-	return gameUid + accountUid
+	return base.GameUidPrefix(gameId, platformId) + accountUid
 }
 
-// GetAccountUid 获取账号Uid
-func GetAccountUid(account string) int64 {
-	var uid int64
-	dbTable := base.GetHashDbTable(account)
-	userSql := fmt.Sprintf("SELECT uid FROM %s WHERE account = ?", dbTable.AccountHashTable)
-	err := dbTable.AccountSlaveDb.QueryRow(userSql, account).Scan(&uid)
+// RefreshLoginToken 使用刷新token换取新的登录token及刷新token
+// 需要重新检查项目用户状态，被禁用或注销中的不允许刷新
+func RefreshLoginToken(claims *base.CustomClaims) (*base.LoginTokensFields, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	querySql := fmt.Sprintf("SELECT uid, `status` FROM %s WHERE main_uid = ?", dbTable.GameUserTable)
+	rows, err := dbTable.GameUserSlaveDb.Query(querySql, mainUid)
 	if err != nil {
-		return 0
+		return nil, &base.MyError{Code: base.RefreshGameUserQueryError, Log: "refresh token query game users error: " + err.Error()}
 	}
-	return uid
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var (
+			queryUid    int64
+			queryStatus int
+		)
+		err = rows.Scan(&queryUid, &queryStatus)
+		if err != nil {
+			return nil, &base.MyError{Code: base.RefreshGameUserScanError, Log: "refresh token query game users scan error: " + err.Error()}
+		}
+		//用户是否被禁用, 被禁用则撤销其所有会话
+		if queryStatus == base.AccountDisabled {
+			if revokeErr := base.RevokeAllSessions(mainUid); revokeErr != nil {
+				log.Error().Int64("main_uid", mainUid).Msg(revokeErr.Log)
+			}
+			return nil, &base.MyError{Code: base.LoginAccountDisabled}
+		}
+		//账号注销中，返回错误码
+		if queryStatus == base.AccountDeleting {
+			return nil, &base.MyError{Code: base.AccountIsBeingDeleted}
+		}
+		if queryUid == claims.Uid {
+			exists = true
+		}
+	}
+	if !exists {
+		return nil, &base.MyError{Code: base.RefreshGameUserNotExists, Log: fmt.Sprintf("refresh token uid: %d, main uid: %d not exists", claims.Uid, mainUid)}
+	}
+
+	//刷新后的token沿用原登录时间, 服务器登录校验返回的login_time为实际认证时间
+	currTime := base.GetTime()
+	loginTime := claims.LoginTime
+	if loginTime <= 0 {
+		loginTime = currTime
+	}
+	loginRet := &base.LoginReturnFields{
+		Uid:       claims.Uid,
+		LoginTime: loginTime,
+		Tokens: base.LoginTokensFields{
+			ExpiresIn:             base.GConf.Base.LoginTokenExpires - 60,
+			RefreshTokenExpiresIn: base.GConf.Base.RefreshTokenExpires,
+		},
+	}
+
+	//沿用原token族, 上线token族之前签发的刷新token则新建
+	familyId := claims.FamilyId
+	if familyId == "" {
+		familyId = base.BuildTokenId()
+	}
+
+	//获取生成token
+	token, tokenErr := base.BuildLoginToken(claims.GameId, claims.PlatformId, claims.ChannelId, loginRet, base.TokenTypeAccess, familyId, currTime+base.GConf.Base.LoginTokenExpires)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	loginRet.Tokens.LoginToken = token
+
+	//获取refresh token
+	refreshToken, tokenErr := base.BuildLoginToken(claims.GameId, claims.PlatformId, claims.ChannelId, loginRet, base.TokenTypeRefresh, familyId, currTime+loginRet.Tokens.RefreshTokenExpiresIn)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	loginRet.Tokens.RefreshToken = refreshToken
+	base.TouchSessionInfo(familyId)
+
+	return &loginRet.Tokens, nil
+}
+
+// GetGameUserStatus 查询项目用户当前状态
+// 主账号下绑定的多个账号状态不一致时取最严重的(数值最大)
+func GetGameUserStatus(uid int64, gameId, platformId int) (int, *base.MyError) {
+	mainUid := base.GetMainUid(uid, gameId, platformId)
+	dbTable := base.GetDbTable(mainUid, gameId, platformId)
+
+	querySql := fmt.Sprintf("SELECT `status` FROM %s WHERE main_uid = ?", dbTable.GameUserTable)
+	rows, err := dbTable.GameUserSlaveDb.Query(querySql, mainUid)
+	if err != nil {
+		return 0, &base.MyError{Code: base.LoginAuthUserQueryError, Log: fmt.Sprintf("login auth uid: %d, query error: %s", uid, err.Error())}
+	}
+	defer rows.Close()
+
+	status := 0
+	for rows.Next() {
+		var queryStatus int
+		err = rows.Scan(&queryStatus)
+		if err != nil {
+			return 0, &base.MyError{Code: base.LoginAuthUserQueryError, Log: fmt.Sprintf("login auth uid: %d, scan error: %s", uid, err.Error())}
+		}
+		if queryStatus > status {
+			status = queryStatus
+		}
+	}
+	if status == 0 {
+		return 0, &base.MyError{Code: base.LoginAuthUserNotExists, Log: fmt.Sprintf("login auth uid: %d, main uid: %d not exists", uid, mainUid)}
+	}
+	return status, nil
 }
 
-// MailTplConfig 邮件模板配置
-func MailTplConfig(verifyInfo *base.VerifyCodeFields) (*base.MailTpl, *base.MyError) {
-	var config = &base.MailTpl{}
-	mailSql := fmt.Sprintf("SELECT `type`, lang_id, title, content FROM %s WHERE `type` = ? AND lang_id = ?", base.MailTplTable)
-	err := base.AccountBaseDb.QueryRow(mailSql, verifyInfo.CodeType, verifyInfo.LangId).Scan(&config.Type, &config.LangId, &config.Title, &config.Content)
+// CheckGameUserEnabled 校验token时检查项目用户是否已被禁用, 状态缓存UserStatusCacheExpires秒
+// 被禁用时撤销主账号的所有会话, 已签发的token立即失效
+func CheckGameUserEnabled(uid int64, gameId, platformId int) *base.MyError {
+	mainUid := base.GetMainUid(uid, gameId, platformId)
+	key := fmt.Sprintf(base.UserStatusFormat, gameId, platformId, mainUid)
+	status, err := base.RedisClient.Get(key).Int()
 	if err != nil {
-		return nil, &base.MyError{Code: base.MailTplConfigQueryFailure, Log: fmt.Sprintf("query %s, error: %s", mailSql, err.Error())}
+		var myErr *base.MyError
+		status, myErr = GetGameUserStatus(uid, gameId, platformId)
+		if myErr != nil {
+			return myErr
+		}
+		base.RedisClient.Set(key, status, base.UserStatusCacheExpires*time.Second)
+	}
+	if status != base.AccountDisabled {
+		return nil
 	}
 
-	return config, nil
+	myErr := base.RevokeAllSessions(mainUid)
+	if myErr != nil {
+		return myErr
+	}
+	return &base.MyError{Code: base.LoginAccountDisabled, Log: fmt.Sprintf("uid: %d disabled, sessions of main uid %d revoked", uid, mainUid)}
 }
 
-// SmsTplConfig 短信模板配置
-func SmsTplConfig(verifyInfo *base.VerifyCodeFields) (*base.SmsTpl, *base.MyError) {
-	var config = &base.SmsTpl{}
-	tplSql := fmt.Sprintf("SELECT `type`, lang_id, sms_id, title FROM %s WHERE `type` = ? AND lang_id = ?", base.SmsTplTable)
-	err := base.AccountBaseDb.QueryRow(tplSql, verifyInfo.CodeType, verifyInfo.LangId).Scan(&config.Type, &config.LangId, &config.SmsId, &config.Title)
+// GetOidcUserInfo OIDC userinfo, 查询主账号绑定的email
+func GetOidcUserInfo(claims *base.CustomClaims) (*base.OidcUserInfoFields, *base.MyError) {
+	mainUid := base.GetMainUid(claims.Uid, claims.GameId, claims.PlatformId)
+	dbTable := base.GetDbTable(mainUid, claims.GameId, claims.PlatformId)
+
+	var email string
+	querySql := fmt.Sprintf("SELECT IFNULL(email, '') email FROM %s WHERE uid = ?", dbTable.AccountTable)
+	err := dbTable.AccountSlaveDb.QueryRow(querySql, mainUid).Scan(&email)
 	if err != nil {
-		return nil, &base.MyError{Code: base.SmsTplConfigQueryFailure, Log: fmt.Sprintf("query %s, error: %s", tplSql, err.Error())}
+		return nil, &base.MyError{Code: base.OidcUserInfoQueryError, Log: fmt.Sprintf("oidc userinfo main uid: %d, query error: %s", mainUid, err.Error())}
 	}
 
-	return config, nil
+	return &base.OidcUserInfoFields{
+		Sub:   strconv.FormatInt(mainUid, 10),
+		Uid:   claims.Uid,
+		Email: email,
+	}, nil
+}
+
+// GetAccountUid 获取账号Uid
+func GetAccountUid(account string) int64 {
+	var uid int64
+	dbTable := base.GetHashDbTable(account)
+	userSql := fmt.Sprintf("SELECT uid FROM %s WHERE account = ?", dbTable.AccountHashTable)
+	err := dbTable.AccountSlaveDb.QueryRow(userSql, account).Scan(&uid)
+	if err != nil {
+		return 0
+	}
+	return uid
 }
 
 // ForgetPassword 忘记密码-重置密码
@@ -417,15 +613,23 @@ func ForgetPassword(info *base.ForgetPasswordFields) *base.MyError {
 	}
 	dbTable := base.GetDbTable(mainUid, info.GameId, info.PlatformId)
 	currTime := base.GetTime()
-	//密码加盐处理
-	salt := base.RandomString(base.PasswordSaltLength)
-	info.Password = base.Md5Sum([]byte(info.Password + salt))
-	updateSql := fmt.Sprintf("UPDATE %s SET `password` = ?, salt = ?, updated_time = ? WHERE uid = ?", dbTable.AccountTable)
+	//密码hash处理
+	password, hashErr := base.HashPassword(info.Password)
+	if hashErr != nil {
+		return hashErr
+	}
+	updateSql := fmt.Sprintf("UPDATE %s SET `password` = ?, salt = '', updated_time = ? WHERE uid = ?", dbTable.AccountTable)
 
-	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, info.Password, salt, currTime, mainUid)
+	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, password, currTime, mainUid)
 	if dbErr != nil {
 		return &base.MyError{Code: base.ForgetPasswordUpdateFailure, Log: fmt.Sprintf("update users exec error: %s", dbErr.Error())}
 	}
+
+	//重置密码后，已登录的会话全部失效
+	revokeErr := base.RevokeAllSessions(mainUid)
+	if revokeErr != nil {
+		log.Error().Int64("main_uid", mainUid).Msg(revokeErr.Log)
+	}
 	return nil
 }
 
@@ -467,45 +671,71 @@ func ChangePassword(info *base.ChangePasswordFields) *base.MyError {
 		return &base.MyError{Code: base.ChangePasswordGameUserQueryError, Log: "query game users error: " + err.Error()}
 	}
 
-	info.OldPassword = base.Md5Sum([]byte(info.OldPassword + salt))
-	if password != info.OldPassword {
+	match, _ := base.VerifyPassword(info.OldPassword, password, salt)
+	if !match {
 		return &base.MyError{Code: base.OldPasswordError, Log: "user password not equal to OldPassword"}
 	}
 
 	currTime := base.GetTime()
-	//密码加盐处理
-	newSalt := base.RandomString(base.PasswordSaltLength)
-	info.NewPassword = base.Md5Sum([]byte(info.NewPassword + newSalt))
-	updateSql := fmt.Sprintf("UPDATE %s SET `password` = ?, salt = ?, updated_time = ? WHERE uid = ?", dbTable.AccountTable)
-	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, info.NewPassword, newSalt, currTime, accountUid)
+	//密码hash处理
+	newPassword, hashErr := base.HashPassword(info.NewPassword)
+	if hashErr != nil {
+		return hashErr
+	}
+	updateSql := fmt.Sprintf("UPDATE %s SET `password` = ?, salt = '', updated_time = ? WHERE uid = ?", dbTable.AccountTable)
+	_, dbErr := dbTable.AccountMasterDb.Exec(updateSql, newPassword, currTime, accountUid)
 	if dbErr != nil {
 		return &base.MyError{Code: base.ChangePasswordUpdateFailure, Log: fmt.Sprintf("update users exec error: %s", dbErr.Error())}
 	}
+
+	//修改密码后，已登录的会话全部失效
+	revokeErr := base.RevokeAllSessions(accountUid)
+	if revokeErr != nil {
+		log.Error().Int64("main_uid", accountUid).Msg(revokeErr.Log)
+	}
 	return nil
 }
 
-// 保存验证码
+// 保存验证码, 重新发送时覆盖旧验证码并清空校验次数
 func SetVerifyCode(codeKey string, codeRand string) *base.MyError {
 	expireTime := time.Duration(base.GConf.Base.CodeExpires*60) * time.Second
 	//写入
-	err := base.RedisClient.Set(codeKey, codeRand, expireTime).Err()
+	pipe := base.RedisClient.TxPipeline()
+	pipe.Del(codeKey)
+	pipe.HSet(codeKey, base.CodeFieldValue, codeRand)
+	pipe.Expire(codeKey, expireTime)
+	_, err := pipe.Exec()
 	if err != nil {
 		return &base.MyError{Code: base.VerifyCodeInsertError, Log: fmt.Sprintf("set code %s exec error: %s", codeKey, err.Error())}
 	}
 	return nil
 }
 
-// 校验验证码
+// 校验验证码, 每次校验先累加次数, 错误达到 CodeCheckMax 次后删除验证码, 需重新获取
 func CheckVerifyCode(codeKey string, codeValue string) *base.MyError {
-	val := base.RedisClient.Get(codeKey).Val()
-	if codeValue == val {
+	pipe := base.RedisClient.TxPipeline()
+	codeCmd := pipe.HGet(codeKey, base.CodeFieldValue)
+	checksCmd := pipe.HIncrBy(codeKey, base.CodeFieldChecks, 1)
+	_, _ = pipe.Exec()
+	val := codeCmd.Val()
+	if val == "" {
+		//验证码已过期, 删除累加次数时创建的key
+		base.RedisClient.Del(codeKey)
+		return &base.MyError{Code: base.VerifyCodeNotExists, Log: fmt.Sprintf("code %s not exists", codeKey)}
+	}
+	checks := checksCmd.Val()
+	if checks > int64(base.GConf.Base.CodeCheckMax) {
+		base.RedisClient.Del(codeKey)
+		return &base.MyError{Code: base.VerifyCodeCheckLimit, Log: fmt.Sprintf("code %s checks: %d", codeKey, checks)}
+	}
+	if subtle.ConstantTimeCompare([]byte(codeValue), []byte(val)) == 1 {
 		return nil
 	}
-	if val != "" {
-		return &base.MyError{Code: base.VerifyCodeNotExists}
+	if checks == int64(base.GConf.Base.CodeCheckMax) {
+		base.RedisClient.Del(codeKey)
+		return &base.MyError{Code: base.VerifyCodeCheckLimit, Log: fmt.Sprintf("code %s checks: %d", codeKey, checks)}
 	}
-
-	return &base.MyError{Code: base.VerifyCodeError}
+	return &base.MyError{Code: base.VerifyCodeError, Log: fmt.Sprintf("code %s checks: %d", codeKey, checks)}
 }
 
 // 删除已使用验证码
@@ -580,13 +810,16 @@ func BindAccount(bindInfo *base.BindAccountFields) *base.MyError {
 	}
 	if bindInfo.Type == base.AccountEmail || bindInfo.Type == base.AccountMobile {
 		password := ""
-		salt := ""
 		if bindInfo.Password != base.DefaultNoValue {
-			salt = base.RandomString(base.PasswordSaltLength)
-			password = base.Md5Sum([]byte(bindInfo.Password + salt))
+			var hashErr *base.MyError
+			password, hashErr = base.HashPassword(bindInfo.Password)
+			if hashErr != nil {
+				hashDbTx.Rollback()
+				return hashErr
+			}
 		}
-		accountUpdate := fmt.Sprintf("UPDATE %s SET %s = ?, updated_time = ?, `password` = ?, salt = ? WHERE uid = ?", dbTable.AccountTable, base.AccountType[bindInfo.Type])
-		_, err = dbTx.Exec(accountUpdate, bindInfo.BindAccount, currTime, password, salt, accountUid)
+		accountUpdate := fmt.Sprintf("UPDATE %s SET %s = ?, updated_time = ?, `password` = ?, salt = '' WHERE uid = ?", dbTable.AccountTable, base.AccountType[bindInfo.Type])
+		_, err = dbTx.Exec(accountUpdate, bindInfo.BindAccount, currTime, password, accountUid)
 		if err != nil {
 			hashDbTx.Rollback()
 			return &base.MyError{Code: base.BindAccountTxExecUpdateError, Log: "bind, account update transaction exec error: " + err.Error()}
@@ -757,60 +990,11 @@ func UnBindAccount(unBindInfo *base.UnBindAccountFields) (*base.UserInfoRespFiel
 	}
 
 	//解绑，要删除hash表记录、account、项目用户表
-	hashDbTable := base.GetHashDbTable(unBindInfo.UnBindAccount)
-
-	gameDbTx, err := dbTable.GameUserMasterDb.Begin()
-	//如果是解绑第三方，则先删除第三方
-	if unBindInfo.Type == base.AccountThird {
-		deleteGameUser := fmt.Sprintf("DELETE FROM %s WHERE account = ?", dbTable.GameUserTable)
-		if err != nil {
-			return nil, &base.MyError{Code: base.UnBindGetGameUserDbTxError, Log: fmt.Sprintf("get game db transaction error: %s", err.Error())}
-		}
-		_, err = gameDbTx.Exec(deleteGameUser, unBindInfo.UnBindAccount)
-		if err != nil {
-			return nil, &base.MyError{Code: base.UnBindGameUserTxExecDeleteError, Log: fmt.Sprintf("unbind,delete game user transaction error: %s", err.Error())}
-		}
-	}
-
-	hashDbTx, err := hashDbTable.AccountMasterDb.Begin()
-	if err != nil {
-		if unBindInfo.Type == base.AccountThird {
-			gameDbTx.Rollback()
-		}
-		return nil, &base.MyError{Code: base.UnBindGetHashTxError, Log: fmt.Sprintf("get hash db transaction error: %s", err.Error())}
-	}
-	dbTx, err := dbTable.AccountMasterDb.Begin()
-	if err != nil {
-		if unBindInfo.Type == base.AccountThird {
-			gameDbTx.Rollback()
-		}
-		return nil, &base.MyError{Code: base.UnBindGetAccountDbTxError, Log: fmt.Sprintf("get account db transaction error: %s", err.Error())}
+	myErr := unbindAccountTx([]*base.DbTable{dbTable}, unBindInfo.UnBindAccount, unBindInfo.Type, unBindAccountUid)
+	if myErr != nil {
+		return nil, myErr
 	}
 
-	deleteHashSql := fmt.Sprintf("DELETE FROM %s WHERE account = ?", hashDbTable.AccountHashTable)
-	updateAccountSql := fmt.Sprintf("UPDATE %s SET %s = null, updated_time = ? WHERE uid = ?", dbTable.AccountTable, base.AccountType[unBindInfo.Type])
-	_, err = hashDbTx.Exec(deleteHashSql, unBindInfo.UnBindAccount)
-	if err != nil {
-		if unBindInfo.Type == base.AccountThird {
-			gameDbTx.Rollback()
-		}
-		return nil, &base.MyError{Code: base.UnBindHashTxExecUpdateError, Log: fmt.Sprintf("unbind, account hash update transaction error: %s", err.Error())}
-	}
-
-	_, err = dbTx.Exec(updateAccountSql, base.GetTime(), unBindAccountUid)
-	if err != nil {
-		if unBindInfo.Type == base.AccountThird {
-			gameDbTx.Rollback()
-		}
-		hashDbTx.Rollback()
-		return nil, &base.MyError{Code: base.UnBindAccountTxExecUpdateError, Log: fmt.Sprintf("unbind, account update transaction error: %s", err.Error())}
-	}
-	if unBindInfo.Type == base.AccountThird {
-		gameDbTx.Commit()
-	}
-	hashDbTx.Commit()
-	dbTx.Commit()
-
 	isRealName := 0
 	if cardId != "" && name != "" {
 		isRealName = 1
@@ -832,6 +1016,79 @@ func UnBindAccount(unBindInfo *base.UnBindAccountFields) (*base.UserInfoRespFiel
 	return binds, nil
 }
 
+// 解绑账号, 在事务中删除项目用户表(第三方账号)、hash表记录并清空账号表中的字段
+// gameTables为需要删除项目用户的项目分表, 账号表使用第一个的; 撤销苹果授权时解绑多个项目
+func unbindAccountTx(gameTables []*base.DbTable, account string, accountType int, mainUid int64) *base.MyError {
+	txs := []*sql.Tx{}
+	rollback := func() {
+		for _, tx := range txs {
+			tx.Rollback()
+		}
+	}
+
+	//如果是解绑第三方，则先删除第三方
+	if accountType == base.AccountThird {
+		for _, dbTable := range gameTables {
+			gameDbTx, err := dbTable.GameUserMasterDb.Begin()
+			if err != nil {
+				rollback()
+				return &base.MyError{Code: base.UnBindGetGameUserDbTxError, Log: fmt.Sprintf("get game db transaction error: %s", err.Error())}
+			}
+			txs = append(txs, gameDbTx)
+			deleteGameUser := fmt.Sprintf("DELETE FROM %s WHERE account = ?", dbTable.GameUserTable)
+			_, err = gameDbTx.Exec(deleteGameUser, account)
+			if err != nil {
+				rollback()
+				return &base.MyError{Code: base.UnBindGameUserTxExecDeleteError, Log: fmt.Sprintf("unbind,delete game user transaction error: %s", err.Error())}
+			}
+		}
+	}
+
+	hashDbTable := base.GetHashDbTable(account)
+	hashDbTx, err := hashDbTable.AccountMasterDb.Begin()
+	if err != nil {
+		rollback()
+		return &base.MyError{Code: base.UnBindGetHashTxError, Log: fmt.Sprintf("get hash db transaction error: %s", err.Error())}
+	}
+	txs = append(txs, hashDbTx)
+	dbTx, err := gameTables[0].AccountMasterDb.Begin()
+	if err != nil {
+		rollback()
+		return &base.MyError{Code: base.UnBindGetAccountDbTxError, Log: fmt.Sprintf("get account db transaction error: %s", err.Error())}
+	}
+	txs = append(txs, dbTx)
+
+	deleteHashSql := fmt.Sprintf("DELETE FROM %s WHERE account = ?", hashDbTable.AccountHashTable)
+	_, err = hashDbTx.Exec(deleteHashSql, account)
+	if err != nil {
+		rollback()
+		return &base.MyError{Code: base.UnBindHashTxExecUpdateError, Log: fmt.Sprintf("unbind, account hash update transaction error: %s", err.Error())}
+	}
+	column := base.AccountType[accountType]
+	updateAccountSql := fmt.Sprintf("UPDATE %s SET %s = null, updated_time = ? WHERE uid = ?", gameTables[0].AccountTable, column)
+	args := []interface{}{base.GetTime(), mainUid}
+	if accountType == base.AccountThird {
+		//账号表的third为注册时的第三方账号, 与解绑的账号相同时才清空
+		updateAccountSql = fmt.Sprintf("UPDATE %s SET %s = IF(%s = ?, null, %s), updated_time = ? WHERE uid = ?", gameTables[0].AccountTable, column, column, column)
+		args = []interface{}{account, base.GetTime(), mainUid}
+	}
+	_, err = dbTx.Exec(updateAccountSql, args...)
+	if err != nil {
+		rollback()
+		return &base.MyError{Code: base.UnBindAccountTxExecUpdateError, Log: fmt.Sprintf("unbind, account update transaction error: %s", err.Error())}
+	}
+
+	for i, tx := range txs {
+		if err = tx.Commit(); err != nil {
+			for _, rest := range txs[i+1:] {
+				rest.Rollback()
+			}
+			return &base.MyError{Code: base.UnBindTxCommitError, Log: fmt.Sprintf("unbind %s, commit transaction %d error: %s", account, i, err.Error())}
+		}
+	}
+	return nil
+}
+
 // 查询是否在白名单中
 func GetWhiteList(whiteListInfo *base.WhiteListFields, ip string) *base.WhiteListResponse {
 	//查询ip是否在白名单内
@@ -901,35 +1158,17 @@ func AddDeleteApply(deleteInfo *base.LogoutAccountFields, userLog *zerolog.Logge
 		gameConfigInfo.UserDeleteWaitDuration = 15
 	}
 
-	//如果有apple的信息，先获取token
+	//第三方需要注销时撤销授权的(如苹果), 先换取刷新token
 	thirdAccount := &base.ThirdAccount{}
 	deleteAccountExt := &base.DeleteAccountExt{}
 	jsonErr := json.Unmarshal([]byte(deleteInfo.ThirdInfo), thirdAccount)
-	if jsonErr == nil && thirdAccount.ThirdId == base.ThirdApple && thirdAccount.AuthorizationCode != "" && gameConfigInfo.AppleClientId != "" && gameConfigInfo.AppleClientSecret != "" {
-		code, decodeErr := base64.StdEncoding.DecodeString(thirdAccount.AuthorizationCode)
-		if decodeErr == nil {
-			appleTokenParams := map[string]string{
-				"client_id":     gameConfigInfo.AppleClientId,
-				"client_secret": gameConfigInfo.AppleClientSecret,
-				"code":          string(code),
-				"grant_type":    "authorization_code",
-			}
-			result, err := base.HttpPostForm(base.AppleValidateCodeUrl, appleTokenParams)
-			userLog.Info().Interface("apple_validate_params", appleTokenParams).RawJSON("apple_validate_return", result).Msg("apple validate code info")
-
-			if err == nil {
-				var appleValidateInfo struct {
-					RefreshToken string `json:"refresh_token"`
-				}
-				jsonErr = json.Unmarshal(result, &appleValidateInfo)
-				if jsonErr == nil && appleValidateInfo.RefreshToken != "" {
-					deleteAccountExt.AppleRefreshToken = appleValidateInfo.RefreshToken
-				}
-			} else {
-				userLog.Error().Str("apple_validate_error", err.Log).Msg("apple validate code error")
-			}
-		} else {
-			userLog.Error().Str("authorization_code", thirdAccount.AuthorizationCode).Msg("authorization code base64 decode error: " + decodeErr.Error())
+	if provider, ok := base.GetThirdProvider(thirdAccount.ThirdId); jsonErr == nil && ok {
+		refreshToken, exchangeErr := provider.ExchangeToken(thirdAccount, base.ThirdProviderConfig(provider), &gameConfigInfo)
+		if exchangeErr != nil {
+			userLog.Error().Int("third_id", thirdAccount.ThirdId).Str("exchange_error", exchangeErr.Log).Msg("third exchange token error")
+		} else if refreshToken != "" {
+			deleteAccountExt.ThirdId = thirdAccount.ThirdId
+			deleteAccountExt.RefreshToken = refreshToken
 		}
 	}
 
@@ -959,7 +1198,15 @@ func AddDeleteApply(deleteInfo *base.LogoutAccountFields, userLog *zerolog.Logge
 		return &base.MyError{Code: base.DeleteApplyUpdateUserStatusError, Log: "add delete apply update users status error: " + err.Error()}
 	}
 
-	gameUserTx.Commit()
+	if err = gameUserTx.Commit(); err != nil {
+		return &base.MyError{Code: base.AddDeleteApplyError, Log: "add delete apply commit error: " + err.Error()}
+	}
+
+	//申请注销后，已登录的会话全部失效
+	revokeErr := base.RevokeAllSessions(mainUid)
+	if revokeErr != nil {
+		userLog.Error().Int64("main_uid", mainUid).Msg(revokeErr.Log)
+	}
 	return nil
 }
 
@@ -14,6 +14,7 @@ package routers
 import (
 	"accounts/base"
 	"accounts/controllers"
+	"net"
 	"net/http"
 	"time"
 
@@ -25,16 +26,19 @@ import (
 func InitRouterService() {
 	//日志格式化
 	mid := base.Middleware{}
-	mid = mid.Append(hlog.NewHandler(log.Logger)).Append(hlog.RequestHandler("request")).Append(hlog.RequestIDHandler("req_id", "Request-Id"))
+	mid = mid.Append(base.ClientIpHandler).Append(hlog.NewHandler(log.Logger)).Append(hlog.RequestHandler("request")).Append(hlog.RequestIDHandler("req_id", "Request-Id")).Append(base.IpAccessHandler).Append(base.RateLimitHandler)
 
 	http.Handle("/user/register", mid.Then(http.HandlerFunc(controllers.Register)))             //注册、登录
 	http.Handle("/user/login", mid.Then(http.HandlerFunc(controllers.Login)))                   //登录, 相对于Register接口区别在于 在用户不存在的情况下，不会注册，上面接口适用于游客、第三方
+	http.Handle("/user/refreshToken", mid.Then(http.HandlerFunc(controllers.RefreshToken)))     //刷新token
 	http.Handle("/user/forgetPassword", mid.Then(http.HandlerFunc(controllers.ForgetPassword))) //忘记密码
 	http.Handle("/user/changePassword", mid.Then(http.HandlerFunc(controllers.ChangePassword))) //修改密码
 	http.Handle("/user/sendSmsCode", mid.Then(http.HandlerFunc(controllers.SendVerifyCode)))    //发送验证码
+	http.Handle("/user/mailStatus", mid.Then(http.HandlerFunc(controllers.MailStatus)))         //邮件发送状态
 	http.Handle("/user/bindAccount", mid.Then(http.HandlerFunc(controllers.BindAccount)))       //绑定账号
 	http.Handle("/user/unBindAccount", mid.Then(http.HandlerFunc(controllers.UnBindAccount)))   //解绑
 	http.Handle("/user/loginAuth", mid.Then(http.HandlerFunc(controllers.LoginAuth)))           //服务器登录校验
+	http.Handle("/user/loginAuthV2", mid.Then(http.HandlerFunc(controllers.LoginAuthV2)))       //服务器登录校验v2, 返回token信息及用户状态
 	http.Handle("/user/whiteList", mid.Then(http.HandlerFunc(controllers.WhiteList)))           //白名单校验
 	http.Handle("/user/applyLogout", mid.Then(http.HandlerFunc(controllers.ApplyLogout)))       //账号注销
 	http.Handle("/user/undoLogout", mid.Then(http.HandlerFunc(controllers.UndoLogout)))         //撤销账号注销
@@ -43,6 +47,37 @@ func InitRouterService() {
 	http.Handle("/user/realNameAuth", mid.Then(http.HandlerFunc(controllers.RealNameAuth)))     //实名认证
 	http.Handle("/captcha/image", mid.Then(http.HandlerFunc(controllers.Image)))                //图形验证码展示
 	http.Handle("/captcha/verify", mid.Then(http.HandlerFunc(controllers.Verify)))              //图形验证码验证
+	http.Handle("/.well-known/jwks.json", mid.Then(http.HandlerFunc(controllers.Jwks)))         //token验证公钥
+
+	//登录会话管理
+	http.Handle("/user/sessions", mid.Then(http.HandlerFunc(controllers.Sessions)))           //登录会话列表
+	http.Handle("/user/revokeSession", mid.Then(http.HandlerFunc(controllers.RevokeSession))) //撤销登录会话
+
+	//二次验证
+	http.Handle("/user/totpEnroll", mid.Then(http.HandlerFunc(controllers.TotpEnroll)))   //生成TOTP密钥
+	http.Handle("/user/totpConfirm", mid.Then(http.HandlerFunc(controllers.TotpConfirm))) //确认启用二次验证
+	http.Handle("/user/totpDisable", mid.Then(http.HandlerFunc(controllers.TotpDisable))) //关闭二次验证
+	http.Handle("/user/totpLogin", mid.Then(http.HandlerFunc(controllers.TotpLogin)))     //登录二次验证
+
+	//通行密钥
+	http.Handle("/user/passkeyRegisterOptions", mid.Then(http.HandlerFunc(controllers.PasskeyRegisterOptions))) //通行密钥注册选项
+	http.Handle("/user/passkeyRegister", mid.Then(http.HandlerFunc(controllers.PasskeyRegister)))               //注册通行密钥
+	http.Handle("/user/passkeyLoginOptions", mid.Then(http.HandlerFunc(controllers.PasskeyLoginOptions)))       //通行密钥登录选项
+	http.Handle("/user/passkeyLogin", mid.Then(http.HandlerFunc(controllers.PasskeyLogin)))                     //通行密钥登录
+
+	//第三方
+	http.Handle("/user/thirdCodeLogin", mid.Then(http.HandlerFunc(controllers.ThirdCodeLogin)))              //微信、QQ授权码登录
+	http.Handle("/third/appleNotification", mid.Then(http.HandlerFunc(controllers.AppleNotification)))       //苹果服务端通知
+	http.Handle("/third/facebookDataDeletion", mid.Then(http.HandlerFunc(controllers.FacebookDataDeletion))) //Facebook数据删除回调
+	http.Handle("/third/deletionStatus", mid.Then(http.HandlerFunc(controllers.ThirdDeletionStatus)))        //数据删除进度
+	http.Handle("/third/googleRiscEvent", mid.Then(http.HandlerFunc(controllers.GoogleRiscEvent)))           //Google安全事件
+
+	//OIDC provider
+	http.Handle("/.well-known/openid-configuration", mid.Then(http.HandlerFunc(controllers.OidcDiscovery))) //OIDC discovery
+	http.Handle("/oauth/authorize", mid.Then(http.HandlerFunc(controllers.OidcAuthorize)))                  //OIDC授权
+	http.Handle("/oauth/authorize/totp", mid.Then(http.HandlerFunc(controllers.OidcAuthorizeTotp)))         //OIDC授权二次验证
+	http.Handle("/oauth/token", mid.Then(http.HandlerFunc(controllers.OidcToken)))                          //OIDC换取token
+	http.Handle("/oauth/userinfo", mid.Then(http.HandlerFunc(controllers.OidcUserInfo)))                    //OIDC用户信息
 
 	srv := &http.Server{
 		Addr:         base.GConf.Server.Host,
@@ -50,7 +85,15 @@ func InitRouterService() {
 		WriteTimeout: time.Duration(base.GConf.HttpTimeout.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(base.GConf.HttpTimeout.IdleTimeout) * time.Second,
 	}
-	err := srv.ListenAndServe()
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		base.MultipleLog.Fatal().Msg(err.Error())
+	}
+	//四层负载均衡转发时解析PROXY头获取客户端地址
+	if base.GConf.TrustedProxy.ProxyProtocol {
+		listener = base.NewProxyProtocolListener(listener)
+	}
+	err = srv.Serve(listener)
 	if err != nil {
 		base.MultipleLog.Fatal().Msg(err.Error())
 	}
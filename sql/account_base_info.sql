@@ -41,6 +41,25 @@ INSERT INTO `holiday` VALUES ('2023-04-05'),('2023-05-01'),('2023-06-22'),('2023
 /*!40000 ALTER TABLE `holiday` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `ip_access_list`
+--
+
+DROP TABLE IF EXISTS `ip_access_list`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `ip_access_list` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `cidr` varchar(64) NOT NULL COMMENT 'ip段, 如 203.0.113.0/24、2001:db8::/32, 单个ip可不带掩码',
+  `type` tinyint(1) NOT NULL COMMENT '1: 允许(不受ip相关的访问限制), 2: 禁止访问',
+  `expire_time` int NOT NULL DEFAULT '0' COMMENT '过期时间, 0不过期',
+  `desc` varchar(255) NOT NULL DEFAULT '' COMMENT '备注信息',
+  `updated_time` int NOT NULL DEFAULT '0' COMMENT '最后更新时间',
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `cidr` (`cidr`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='ip访问名单';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `mail_tpl`
 --
@@ -66,7 +85,7 @@ CREATE TABLE `mail_tpl` (
 
 LOCK TABLES `mail_tpl` WRITE;
 /*!40000 ALTER TABLE `mail_tpl` DISABLE KEYS */;
-INSERT INTO `mail_tpl` VALUES (1,2,'zh-TW','XGame 會員更改密碼通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您已通過信箱重設新密碼，本次請求的認證碼為：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            請在認證碼輸入框中輸入此認證>碼以完成認證。（認證碼有效期為<span style=\"color:red\">120</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服>信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>',NULL),(2,2,'zh-CN','XGame 会员更改密码通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您已通过信箱重设新密码，本次请求的认证码为：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">10</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>\r\n',NULL),(3,2,'en','XGame Reminder: Reset Password','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;We received a request to reset your password via E-mail. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{CODE}</span>\r\n            Please enter it in the verfication code box to continue your reset.（The code will expire in <span style=\"color:red\">120</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more questions, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>\r\n',NULL),(4,3,'zh-TW','XGame 會員綁定郵件通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在進行帳號綁定，本次請求的認證碼\n為：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            請在認證碼輸入框中輸入此認證碼以完成認證。（認證碼有效期為<span style=\"color:red\">120</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑>問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>\r\n',NULL),(5,3,'zh-CN','XGame 会员绑定邮件通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在进行账号绑定，本次请求的认证码为：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">10</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>',NULL),(6,3,'en','XGame Reminder: Bind Account','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;You are trying to bind your account. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{CODE}</span>\r\n            Please enter it in the verfication code box and continue your reset.（The code will expire in <span style=\"color:red\">120</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more inquiry, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>',NULL),(7,4,'zh-TW','XGame 會員解除綁定通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在進行帳號解除綁定，本次請求的認證碼為：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            請在認證碼輸入框中輸入此認證碼以完成認證。（認證碼有效期為<span style=\"color:red\">120</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>',NULL),(8,4,'zh-CN','XGame 会员解除绑定通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame会员您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在进行账号解除绑\n定，本次请求的认证码为：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">10</span>分钟）\r\n        </p>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>',NULL),(9,4,'en','XGame Reminder: Unbind Account','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;You are trying to unbind your account. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{CODE}</span>\r\n            Please enter it in the verfication code box and continue your reset.（The code will expire in <span style=\"color:red\">120</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more inquiry, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>',NULL),(14,1,'zh-CN','XGame 会员注册验证码通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您本次请求的验证码为：\r\n            <span style=\"color:red\">{CODE}</span>\r\n            请在验证码输入框中输入此验证码完成检验。（认证码有效期为<span style=\"color:red\">10</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>\r\n',NULL);
+INSERT INTO `mail_tpl` VALUES (1,2,'zh-TW','XGame 會員更改密碼通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您已通過信箱重設新密碼，本次請求的認證碼為：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            請在認證碼輸入框中輸入此認證>碼以完成認證。（認證碼有效期為<span style=\"color:red\">{{.ExpireMinutes}}</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服>信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>',NULL),(2,2,'zh-CN','XGame 会员更改密码通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您已通过信箱重设新密码，本次请求的认证码为：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">{{.ExpireMinutes}}</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>\r\n',NULL),(3,2,'en','XGame Reminder: Reset Password','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;We received a request to reset your password via E-mail. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            Please enter it in the verfication code box to continue your reset.（The code will expire in <span style=\"color:red\">{{.ExpireMinutes}}</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more questions, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>\r\n',NULL),(4,3,'zh-TW','XGame 會員綁定郵件通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在進行帳號綁定，本次請求的認證碼\n為：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            請在認證碼輸入框中輸入此認證碼以完成認證。（認證碼有效期為<span style=\"color:red\">{{.ExpireMinutes}}</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑>問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>\r\n',NULL),(5,3,'zh-CN','XGame 会员绑定邮件通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在进行账号绑定，本次请求的认证码为：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">{{.ExpireMinutes}}</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>',NULL),(6,3,'en','XGame Reminder: Bind Account','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;You are trying to bind your account. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            Please enter it in the verfication code box and continue your reset.（The code will expire in <span style=\"color:red\">{{.ExpireMinutes}}</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more inquiry, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>',NULL),(7,4,'zh-TW','XGame 會員解除綁定通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">親愛的XGame會員您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在進行帳號解除綁定，本次請求的認證碼為：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            請在認證碼輸入框中輸入此認證碼以完成認證。（認證碼有效期為<span style=\"color:red\">{{.ExpireMinutes}}</span>分鐘）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;如您有疑問，請聯繫我們客服：\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">客服信箱</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此為系統自動發送信件，請勿回覆。</div>\r\n    </div>',NULL),(8,4,'zh-CN','XGame 会员解除绑定通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame会员您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您正在进行账号解除绑\n定，本次请求的认证码为：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            请在认证码输入框中输入此认证码以完成认证。（认证码有效期为<span style=\"color:red\">{{.ExpireMinutes}}</span>分钟）\r\n        </p>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>',NULL),(9,4,'en','XGame Reminder: Unbind Account','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">Dear XGame user,</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;You are trying to unbind your account. Here is the verifcation code for this request:\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            Please enter it in the verfication code box and continue your reset.（The code will expire in <span style=\"color:red\">{{.ExpireMinutes}}</span>minutes）\r\n        </p>\r\n\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;For more inquiry, please contact our customer service:\r\n            <a style=\"color: red;\" href=\"Mailto:service@xgame.com\">Customer Service Mail</a>\r\n        </div>\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;This is an automatically generated email. Please do not reply.</div>\r\n    </div>',NULL),(14,1,'zh-CN','XGame 会员注册验证码通知','<h3 style=\"font-weight: 900;font-weight: 700\r\n            margin-bottom: 20px;font-size:20px\">亲爱的XGame用户您好:</h3>\r\n\r\n    <div style=\"font-size:16px;margin-bottom: 10px;\">\r\n        <p style=\"line-height: 30px;\r\n            margin-bottom: 10px;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;您本次请求的验证码为：\r\n            <span style=\"color:red\">{{.Code}}</span>\r\n            请在验证码输入框中输入此验证码完成检验。（认证码有效期为<span style=\"color:red\">{{.ExpireMinutes}}</span>分钟）\r\n        </p>\r\n\r\n        <div style=\"margin: 10px 0;\">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;-XGame-</div>\r\n        <div>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;此为系统自动发送信件，请勿回复。</div>\r\n    </div>\r\n',NULL);
 /*!40000 ALTER TABLE `mail_tpl` ENABLE KEYS */;
 UNLOCK TABLES;
 
@@ -81,10 +100,11 @@ CREATE TABLE `sms_tpl` (
   `id` int NOT NULL AUTO_INCREMENT,
   `type` tinyint(1) NOT NULL COMMENT '类型, 1: 注册, 2: 忘记密码, 3: 账号绑定, 4: 账号解绑, 5:登录',
   `lang_id` varchar(16) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT '' COMMENT '语言id, i18n约束id, zh-CN: 简体中文, zh-TW: 繁体中文, en-US: 英文 ',
-  `sms_id` varchar(32) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT '' COMMENT '模板id(目前是阿里模板id)',
+  `sms_id` varchar(64) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT '' COMMENT '服务商的模板id, 阿里云模板CODE, Twilio Content SID',
   `title` varchar(64) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci DEFAULT '' COMMENT '短信标题',
+  `provider` varchar(32) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT 'Aliyun' COMMENT '短信服务商, Aliyun、Twilio',
   PRIMARY KEY (`id`),
-  UNIQUE KEY `lang_id` (`type`,`lang_id`) USING BTREE
+  UNIQUE KEY `lang_id` (`type`,`lang_id`,`provider`) USING BTREE
 ) ENGINE=InnoDB AUTO_INCREMENT=2 DEFAULT CHARSET=utf8mb3 COMMENT='用户短信模板';
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -94,7 +114,7 @@ CREATE TABLE `sms_tpl` (
 
 LOCK TABLES `sms_tpl` WRITE;
 /*!40000 ALTER TABLE `sms_tpl` DISABLE KEYS */;
-INSERT INTO `sms_tpl` VALUES (1,1,'zh-CN','SMS_123456789','注册');
+INSERT INTO `sms_tpl` VALUES (1,1,'zh-CN','SMS_123456789','注册','Aliyun');
 /*!40000 ALTER TABLE `sms_tpl` ENABLE KEYS */;
 UNLOCK TABLES;
 
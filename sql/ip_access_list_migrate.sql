@@ -0,0 +1,10 @@
+CREATE TABLE `ip_access_list` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `cidr` varchar(64) NOT NULL COMMENT 'ip段, 如 203.0.113.0/24、2001:db8::/32, 单个ip可不带掩码',
+  `type` tinyint(1) NOT NULL COMMENT '1: 允许(不受ip相关的访问限制), 2: 禁止访问',
+  `expire_time` int NOT NULL DEFAULT '0' COMMENT '过期时间, 0不过期',
+  `desc` varchar(255) NOT NULL DEFAULT '' COMMENT '备注信息',
+  `updated_time` int NOT NULL DEFAULT '0' COMMENT '最后更新时间',
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `cidr` (`cidr`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='ip访问名单';
@@ -0,0 +1,4 @@
+UPDATE `mail_tpl` SET
+    `content` = REPLACE(REPLACE(REPLACE(`content`, '{CODE}', '{{.Code}}'),
+        '<span style="color:red">120</span>', '<span style="color:red">{{.ExpireMinutes}}</span>'),
+        '<span style="color:red">10</span>', '<span style="color:red">{{.ExpireMinutes}}</span>');
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `passkey_%d`
+(
+    `credential_id`  varchar(128)  NOT NULL COMMENT '凭证id, base64url编码',
+    `uid`            bigint        NOT NULL DEFAULT '0' COMMENT '主账号id',
+    `public_key`     varbinary(1024) NOT NULL COMMENT '公钥, SPKI格式',
+    `algorithm`      int(11) NOT NULL DEFAULT '0' COMMENT '算法 -7: ES256, -257: RS256',
+    `sign_count`     int(11) unsigned NOT NULL DEFAULT '0' COMMENT '签名计数',
+    `name`           varchar(64)   NOT NULL DEFAULT '' COMMENT '凭证名称',
+    `created_time`   int(11) NOT NULL DEFAULT '0' COMMENT '注册时间',
+    `last_used_time` int(11) NOT NULL DEFAULT '0' COMMENT '最后使用时间',
+    PRIMARY KEY (`credential_id`),
+    KEY `uid` (`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='通行密钥表';
+
@@ -0,0 +1,4 @@
+ALTER TABLE `account_%d`
+    MODIFY `password` varchar(128) NOT NULL DEFAULT '' COMMENT '密码hash, argon2id编码格式, 旧数据为md5',
+    MODIFY `salt` char(16) NOT NULL DEFAULT '' COMMENT '旧md5密码的盐, argon2id格式不使用';
+
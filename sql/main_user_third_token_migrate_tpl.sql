@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `third_token_%d`
+(
+    `uid`           bigint        NOT NULL DEFAULT '0' COMMENT '主账号id',
+    `third_id`      int(11) NOT NULL DEFAULT '0' COMMENT '第三方编码, 如 1012 微信',
+    `app_id`        varchar(64)   NOT NULL DEFAULT '' COMMENT '第三方应用id',
+    `openid`        varchar(128)  NOT NULL DEFAULT '' COMMENT '应用下的用户id',
+    `unionid`       varchar(128)  NOT NULL DEFAULT '' COMMENT '开放平台下的用户id',
+    `access_token`  varchar(512)  NOT NULL DEFAULT '',
+    `refresh_token` varchar(512)  NOT NULL DEFAULT '',
+    `expires_time`  int(11) NOT NULL DEFAULT '0' COMMENT 'access token过期时间',
+    `updated_time`  int(11) NOT NULL DEFAULT '0' COMMENT '更新时间',
+    PRIMARY KEY (`uid`, `third_id`, `app_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='第三方token表';
@@ -0,0 +1,5 @@
+ALTER TABLE `account_%d`
+    ADD COLUMN `totp_secret` varchar(128) NOT NULL DEFAULT '' COMMENT '二次验证TOTP密钥, AES-256-GCM加密' AFTER `lang`,
+    ADD COLUMN `totp_status` tinyint(1) NOT NULL DEFAULT '0' COMMENT '二次验证状态 0: 未启用, 1: 待确认, 2: 已启用' AFTER `totp_secret`,
+    ADD COLUMN `recovery_codes` varchar(1024) NOT NULL DEFAULT '' COMMENT '二次验证恢复码sha256, json数组' AFTER `totp_status`;
+
@@ -6,7 +6,7 @@ CREATE TABLE `account_%d`
     `guest`           varchar(128)          DEFAULT NULL COMMENT '游客',
     `third`           varchar(128)          DEFAULT NULL COMMENT '第三方账号，第三方名称id加uid,如fb账号：1001_112257954430192',
     `mobile`          varchar(32)           DEFAULT NULL COMMENT '手机号',
-    `password`        char(32)     NOT NULL DEFAULT '' COMMENT '密码',
+    `password`        varchar(128) NOT NULL DEFAULT '' COMMENT '密码hash, argon2id编码格式, 旧数据为md5',
     `created_time`    int(11) NOT NULL DEFAULT '0' COMMENT '注册时间',
     `created_ip`      varchar(16)  NOT NULL DEFAULT '' COMMENT '注册时ip',
     `updated_time`    int(11) NOT NULL DEFAULT '0' COMMENT '最后更新时间',
@@ -14,9 +14,12 @@ CREATE TABLE `account_%d`
     `name`            varchar(32)  NOT NULL DEFAULT '' COMMENT '实名认证',
     `card_id`         varchar(32)  NOT NULL DEFAULT '' COMMENT '身份证id',
     `type`            tinyint(1) NOT NULL DEFAULT '0' COMMENT '注册类型 1: email, 2:手机号, 3: 用户名, 4: 游客, 5: 第三方',
-    `salt`            char(16)     NOT NULL DEFAULT '' COMMENT '密码盐',
+    `salt`            char(16)     NOT NULL DEFAULT '' COMMENT '旧md5密码的盐, argon2id格式不使用',
     `device_type`     tinyint(1) NOT NULL DEFAULT '0' COMMENT '1 ios, 2 android, 3 pc/h5, 4 其他',
     `lang`            varchar(32)  NOT NULL DEFAULT '' COMMENT '用户语言',
+    `totp_secret`     varchar(128) NOT NULL DEFAULT '' COMMENT '二次验证TOTP密钥, AES-256-GCM加密',
+    `totp_status`     tinyint(1) NOT NULL DEFAULT '0' COMMENT '二次验证状态 0: 未启用, 1: 待确认, 2: 已启用',
+    `recovery_codes`  varchar(1024) NOT NULL DEFAULT '' COMMENT '二次验证恢复码sha256, json数组',
     `extra`           varchar(255) NOT NULL DEFAULT '' COMMENT '其他扩展部分',
     PRIMARY KEY (`uid`) USING BTREE,
     UNIQUE KEY `email` (`email`) USING BTREE,
@@ -33,3 +36,31 @@ CREATE TABLE `account_hash_%d`
     PRIMARY KEY (`account`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='账号Hash表';
 
+CREATE TABLE `passkey_%d`
+(
+    `credential_id`  varchar(128)  NOT NULL COMMENT '凭证id, base64url编码',
+    `uid`            bigint        NOT NULL DEFAULT '0' COMMENT '主账号id',
+    `public_key`     varbinary(1024) NOT NULL COMMENT '公钥, SPKI格式',
+    `algorithm`      int(11) NOT NULL DEFAULT '0' COMMENT '算法 -7: ES256, -257: RS256',
+    `sign_count`     int(11) unsigned NOT NULL DEFAULT '0' COMMENT '签名计数',
+    `name`           varchar(64)   NOT NULL DEFAULT '' COMMENT '凭证名称',
+    `created_time`   int(11) NOT NULL DEFAULT '0' COMMENT '注册时间',
+    `last_used_time` int(11) NOT NULL DEFAULT '0' COMMENT '最后使用时间',
+    PRIMARY KEY (`credential_id`),
+    KEY `uid` (`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='通行密钥表';
+
+
+CREATE TABLE `third_token_%d`
+(
+    `uid`           bigint        NOT NULL DEFAULT '0' COMMENT '主账号id',
+    `third_id`      int(11) NOT NULL DEFAULT '0' COMMENT '第三方编码, 如 1012 微信',
+    `app_id`        varchar(64)   NOT NULL DEFAULT '' COMMENT '第三方应用id',
+    `openid`        varchar(128)  NOT NULL DEFAULT '' COMMENT '应用下的用户id',
+    `unionid`       varchar(128)  NOT NULL DEFAULT '' COMMENT '开放平台下的用户id',
+    `access_token`  varchar(512)  NOT NULL DEFAULT '',
+    `refresh_token` varchar(512)  NOT NULL DEFAULT '',
+    `expires_time`  int(11) NOT NULL DEFAULT '0' COMMENT 'access token过期时间',
+    `updated_time`  int(11) NOT NULL DEFAULT '0' COMMENT '更新时间',
+    PRIMARY KEY (`uid`, `third_id`, `app_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='第三方token表';
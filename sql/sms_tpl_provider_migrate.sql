@@ -0,0 +1,5 @@
+ALTER TABLE `sms_tpl`
+    MODIFY COLUMN `sms_id` varchar(64) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT '' COMMENT '服务商的模板id, 阿里云模板CODE, Twilio Content SID',
+    ADD COLUMN `provider` varchar(32) CHARACTER SET utf8mb3 COLLATE utf8mb3_general_ci NOT NULL DEFAULT 'Aliyun' COMMENT '短信服务商, Aliyun、Twilio' AFTER `title`,
+    DROP INDEX `lang_id`,
+    ADD UNIQUE KEY `lang_id` (`type`,`lang_id`,`provider`) USING BTREE;